@@ -0,0 +1,415 @@
+package imgix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cacheKeyExcludedParams are the params that CacheKey ignores. The
+// signature (s) depends on the token and the library param (ixlib)
+// depends on the SDK version; neither affects the rendered image.
+var cacheKeyExcludedParams = []string{"s", "ixlib"}
+
+// setParams are the imgix params whose values are comma-delimited sets
+// of members (e.g. auto=format,compress). The order of a set-param's
+// members does not affect the rendered image.
+var setParams = map[string]bool{
+	"auto":        true,
+	"ch":          true,
+	"crop":        true,
+	"blend-align": true,
+	"mark-align":  true,
+	"txt-align":   true,
+}
+
+// setParamsMu guards setParams, which RegisterSetParam may write while
+// builders read it.
+var setParamsMu sync.RWMutex
+
+// isSetParam reports whether the param key is a set-param.
+func isSetParam(key string) bool {
+	setParamsMu.RLock()
+	defer setParamsMu.RUnlock()
+	return setParams[key]
+}
+
+// MemberOrder determines the order of the members of a canonicalized
+// set-param (see CacheKeyWithMemberOrder and WithAutoFormatMemberOrder).
+// Either way, members are trimmed and de-duplicated.
+type MemberOrder int
+
+const (
+	// SortedMembers sorts the members, e.g. auto=compress,format. This is
+	// the default.
+	SortedMembers MemberOrder = iota
+
+	// InsertionOrderMembers keeps the members in order of first
+	// appearance, e.g. auto=format,compress stays as-is. This matches
+	// imgix-core-js, which emits set-params as they are given.
+	InsertionOrderMembers
+)
+
+// RegisterSetParam declares the param key to be a set-param, i.e. one
+// whose value is a comma-delimited set of members, so that it is merged
+// (see WithDefaultParams), de-duplicated (see WithDedupeParams), and
+// canonicalized (see CacheKey and ParamsEqual) member-wise, like auto.
+// This supports set-params that are newer than the library. It is safe
+// for concurrent use, but a URL created while a set-param is being
+// registered may or may not treat it as one, so set-params should still
+// be registered before any builder is used.
+func RegisterSetParam(key string) {
+	setParamsMu.Lock()
+	defer setParamsMu.Unlock()
+	setParams[key] = true
+}
+
+// ParamAliases maps the long-form names of imgix params to their
+// canonical short forms. It is used by WithCanonicalParamNames and
+// WithLongParamNames, and can be extended before a builder is created.
+var ParamAliases = map[string]string{
+	"width":   "w",
+	"height":  "h",
+	"quality": "q",
+}
+
+// ParamGroup is a named group of related params, e.g. those that size
+// the image (see LogicalParamOrder).
+type ParamGroup struct {
+	Name string
+	Keys []string
+}
+
+// LogicalParamOrder is the order in which WithLogicalParamOrder emits
+// params: grouped by category, following the grouping of imgix's
+// rendering API reference, so that a URL reads like the transform
+// pipeline: sizing, then cropping, then adjustments, and so on. Within a
+// group, params are emitted in the order of its keys. Groups and keys
+// can be added (e.g. for params that are newer than the library) before
+// a builder is created.
+var LogicalParamOrder = []ParamGroup{
+	{"sizing", []string{"w", "h", "ar", "dpr", "fit", "min-w", "min-h", "max-w", "max-h"}},
+	{"cropping", []string{"crop", "rect", "fp-x", "fp-y", "fp-z", "fp-debug", "faceindex", "facepad"}},
+	{"trim", []string{"trim", "trim-color", "trim-md", "trim-pad", "trim-sd", "trim-tol"}},
+	{"rotation", []string{"rot", "flip", "orient"}},
+	{"adjustment", []string{"bri", "con", "exp", "gam", "high", "hue", "invert", "sat", "shad",
+		"sharp", "usm", "usmrad", "vib"}},
+	{"stylize", []string{"blur", "duotone", "duotone-alpha", "htn", "monochrome", "px", "sepia"}},
+	{"noise reduction", []string{"nr", "nrs"}},
+	{"mask", []string{"mask", "corner-radius", "mask-bg"}},
+	{"border and padding", []string{"border", "border-radius", "border-radius-inner", "pad",
+		"pad-top", "pad-right", "pad-bottom", "pad-left", "bg"}},
+	{"blending", []string{"blend", "blend64", "blend-mode", "blend-align", "blend-alpha",
+		"blend-w", "blend-h", "blend-x", "blend-y", "blend-fit", "blend-crop", "blend-pad", "blend-size"}},
+	{"watermark", []string{"mark", "mark64", "mark-align", "mark-alpha", "mark-w", "mark-h",
+		"mark-x", "mark-y", "mark-fit", "mark-pad", "mark-scale", "mark-tile"}},
+	{"text", []string{"txt", "txt64", "txt-align", "txt-clip", "txt-color", "txt-fit", "txt-font",
+		"txt-lead", "txt-line", "txt-line-color", "txt-pad", "txt-shad", "txt-size", "txt-track", "txt-width"}},
+	{"format", []string{"auto", "fm", "q", "lossless", "chromasub", "colorquant", "cs", "dpi", "dl"}},
+}
+
+// logicalParamKeys returns the keys of LogicalParamOrder, group by
+// group.
+func logicalParamKeys() []string {
+	var keys []string
+	for _, group := range LogicalParamOrder {
+		keys = append(keys, group.Keys...)
+	}
+	return keys
+}
+
+// ExtractParams returns a copy of the params in all whose keys have the
+// prefix, with the prefix stripped, e.g. img_w and img_fit become w and
+// fit. This suits configs that namespace their keys. Keys without the
+// prefix, and the key that is the prefix itself, are skipped. The params
+// passed to this function are not modified.
+func ExtractParams(all url.Values, prefix string) url.Values {
+	extracted := url.Values{}
+	for k, v := range all {
+		if !strings.HasPrefix(k, prefix) || len(k) == len(prefix) {
+			continue
+		}
+		extracted[strings.TrimPrefix(k, prefix)] = append([]string(nil), v...)
+	}
+	return extracted
+}
+
+// MergeParams returns a copy of base to which the params of override
+// have been added. A scalar param in override replaces the param of the
+// same name in base, whereas the members of a set-param (e.g. auto) are
+// the union of the members in both, in order of first appearance (e.g.
+// auto=format merged with auto=compress,format yields
+// auto=format,compress). Neither base nor override is modified.
+func MergeParams(base url.Values, override url.Values) url.Values {
+	merged := mergeDefaultParams(base, override)
+	for k, v := range merged {
+		if isSetParam(k) {
+			merged[k] = dedupeParams(url.Values{k: v})[k]
+		}
+	}
+	return merged
+}
+
+// MergeParamsAll merges the layers from left to right (see MergeParams),
+// so that later layers take precedence over earlier ones, e.g. route
+// defaults, then user overrides, and then experiment tweaks. None of the
+// layers are modified.
+func MergeParamsAll(layers ...url.Values) url.Values {
+	merged := url.Values{}
+	for _, layer := range layers {
+		merged = MergeParams(merged, layer)
+	}
+	return merged
+}
+
+// mergeDefaultParams returns a copy of params to which the defaults have
+// been added. A key present in params replaces the default of the same
+// name, except that the members of set-params are appended to the
+// default's members. Neither the defaults nor the params are modified.
+func mergeDefaultParams(defaults url.Values, params url.Values) url.Values {
+	merged := cloneValues(defaults)
+	for k, v := range params {
+		if isSetParam(k) {
+			merged[k] = append(merged[k], v...)
+			continue
+		}
+		merged[k] = append([]string(nil), v...)
+	}
+	return merged
+}
+
+// dedupeParams removes the duplicate values of each param, keeping the
+// first occurrence of each value. Set-params are split into their members
+// and de-duplicated member-wise (e.g. auto=format,compress,format becomes
+// auto=format,compress), preserving the order of the members. The params
+// are modified in place.
+func dedupeParams(params url.Values) url.Values {
+	for k, values := range params {
+		seen := map[string]bool{}
+		var deduped []string
+
+		for _, v := range values {
+			members := []string{v}
+			if isSetParam(k) {
+				members = strings.Split(v, ",")
+			}
+
+			for _, m := range members {
+				if seen[m] {
+					continue
+				}
+				seen[m] = true
+				deduped = append(deduped, m)
+			}
+		}
+		params[k] = deduped
+	}
+	return params
+}
+
+// renameParams renames the params whose keys appear in aliases. If a
+// param's target is already present, the target wins and the alias is
+// dropped, since both would otherwise be emitted under one key (e.g.
+// w=100,400). Aliases are visited in sorted order, so if several aliases
+// of the same target are present, the first of them wins. The params are
+// modified in place.
+func renameParams(params url.Values, aliases map[string]string) url.Values {
+	froms := make([]string, 0, len(aliases))
+	for from := range aliases {
+		froms = append(froms, from)
+	}
+	sort.Strings(froms)
+
+	for _, from := range froms {
+		values, ok := params[from]
+		if !ok {
+			continue
+		}
+		delete(params, from)
+		if to := aliases[from]; to != from {
+			if _, exists := params[to]; !exists {
+				params[to] = values
+			}
+		}
+	}
+	return params
+}
+
+// canonicalNames returns a copy of params wherein the long-form names
+// of ParamAliases (e.g. width) have been renamed to their canonical
+// short forms (e.g. w), so that the params can be inspected by their
+// short names whether or not the builder emits the long forms (see
+// WithLongParamNames). The params passed to this function are not
+// modified.
+func canonicalNames(params url.Values) url.Values {
+	return renameParams(cloneValues(params), ParamAliases)
+}
+
+// copyAliases returns a copy of aliases, so that a builder isn't
+// affected by later changes to ParamAliases.
+func copyAliases(aliases map[string]string) map[string]string {
+	copied := make(map[string]string, len(aliases))
+	for from, to := range aliases {
+		copied[from] = to
+	}
+	return copied
+}
+
+// invertAliases returns an alias table mapping the values of aliases
+// back to their keys. If several keys share a value, the first of them,
+// in sorted order, is used.
+func invertAliases(aliases map[string]string) map[string]string {
+	froms := make([]string, 0, len(aliases))
+	for from := range aliases {
+		froms = append(froms, from)
+	}
+	sort.Strings(froms)
+
+	inverted := make(map[string]string, len(aliases))
+	for _, from := range froms {
+		if _, exists := inverted[aliases[from]]; !exists {
+			inverted[aliases[from]] = from
+		}
+	}
+	return inverted
+}
+
+// CacheKey creates a deterministic key for the image rendered by path
+// and params that is independent of the builder's domain, scheme, and
+// token. Two logically identical requests produce the same key, even if
+// their params were added in a different order.
+//
+// The key has the form "{PATH}?{QUERY}", where the path is sanitized
+// exactly as it is when building a URL and the query is encoded and
+// sorted by key. Set-params (e.g. auto) have their members de-duplicated
+// and sorted, and the s and ixlib params are excluded.
+func CacheKey(path string, params url.Values) string {
+	return CacheKeyWithMemberOrder(path, params, SortedMembers)
+}
+
+// CacheKeyWithMemberOrder functions like CacheKey except that the
+// members of set-params are in the given order. Choose
+// InsertionOrderMembers for byte-parity with the keys of imgix-core-js;
+// ParamsEqual and ParamsHash ignore member order regardless.
+func CacheKeyWithMemberOrder(path string, params url.Values, order MemberOrder) string {
+	canonical := canonicalizeParams(params, order)
+	for _, k := range cacheKeyExcludedParams {
+		canonical.Del(k)
+	}
+
+	key := sanitizePath(path)
+	if query := strings.Join(encodeQuery(canonical), "&"); query != "" {
+		key += "?" + query
+	}
+	return key
+}
+
+// cacheKeyChecksumLength is the number of hex digits of a
+// cacheKeyChecksum.
+const cacheKeyChecksumLength = 8
+
+// cacheKeyChecksum returns a short checksum of the CacheKey of path and
+// params: the first cacheKeyChecksumLength hex digits of its SHA-256
+// hash. It identifies a transform compactly, e.g. in HTML comments (see
+// WithChecksumComment), but is too short to be collision-free.
+func cacheKeyChecksum(path string, params url.Values) string {
+	sum := sha256.Sum256([]byte(CacheKey(path, params)))
+	return hex.EncodeToString(sum[:])[:cacheKeyChecksumLength]
+}
+
+// ParamsEqual reports whether the params a and b describe the same
+// transform. Like CacheKey, it ignores the order of params and of
+// set-param members (e.g. auto=format,compress and auto=compress,format
+// are equal), as well as the s and ixlib params. In addition, decimal
+// values are compared numerically (e.g. dpr=2.0 and dpr=2 are equal)
+// and multiple values are compared as they are encoded: joined by
+// commas.
+func ParamsEqual(a url.Values, b url.Values) bool {
+	return paramsQuery(a) == paramsQuery(b)
+}
+
+// ParamsHash returns a stable, hex-encoded SHA-256 hash of params that
+// is suitable as a cache key. Params that are equal according to
+// ParamsEqual have the same hash.
+func ParamsHash(params url.Values) string {
+	sum := sha256.Sum256([]byte(paramsQuery(params)))
+	return hex.EncodeToString(sum[:])
+}
+
+// paramsQuery returns the encoded, sorted query of the normalized form
+// of params, as used by ParamsEqual and ParamsHash.
+func paramsQuery(params url.Values) string {
+	normalized := canonicalizeParams(params, SortedMembers)
+	for _, k := range cacheKeyExcludedParams {
+		normalized.Del(k)
+	}
+
+	for k, v := range normalized {
+		value := strings.Join(v, ",")
+		if !isBase64(k) && k != "txt" {
+			value = canonicalizeDecimal(value)
+		}
+		normalized[k] = []string{value}
+	}
+	return strings.Join(encodeQuery(normalized), "&")
+}
+
+// canonicalizeDecimal formats a decimal value (i.e. one that contains a
+// '.', such as "2.0" or ".50") in its shortest form. Other values,
+// including integers and hex colors like "000", are returned unchanged.
+func canonicalizeDecimal(value string) string {
+	if !strings.Contains(value, ".") || strings.ContainsAny(value, "eEnNxX") {
+		return value
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	if f == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// canonicalizeParams returns a copy of params wherein the members of
+// each set-param have been canonicalized in the order (see
+// canonicalizeSetParam). The params passed to this function are not
+// modified.
+func canonicalizeParams(params url.Values, order MemberOrder) url.Values {
+	canonical := cloneValues(params)
+	for k, v := range canonical {
+		if isSetParam(k) {
+			canonical[k] = []string{canonicalizeSetParam(v, order)}
+		}
+	}
+	return canonical
+}
+
+// canonicalizeSetParam splits each of the values on commas, then trims,
+// de-duplicates, and (if the order is SortedMembers) sorts the resulting
+// members before joining them back together as a single, comma-delimited
+// value.
+func canonicalizeSetParam(values []string, order MemberOrder) string {
+	seen := map[string]bool{}
+	members := []string{}
+
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			m = strings.TrimSpace(m)
+			if m == "" || seen[m] {
+				continue
+			}
+			seen[m] = true
+			members = append(members, m)
+		}
+	}
+	if order == SortedMembers {
+		sort.Strings(members)
+	}
+	return strings.Join(members, ",")
+}
@@ -0,0 +1,342 @@
+package imgix
+
+import (
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonical_CacheKey(t *testing.T) {
+	params := url.Values{
+		"w":     {"400"},
+		"auto":  {"format", "compress"},
+		"s":     {"1a4e48641614d1109c6a7af51be23d18"},
+		"ixlib": {LibVersion}}
+	expected := "/users/1.png?auto=compress%2Cformat&w=400"
+	assert.Equal(t, expected, CacheKey("users/1.png", params))
+}
+
+func TestCanonical_CacheKeyEquivalentParams(t *testing.T) {
+	a := url.Values{"auto": {"format,compress"}, "w": {"400"}, "h": {"300"}}
+	b := url.Values{"h": {"300"}, "w": {"400"}, "auto": {"compress", "format", "compress"}}
+	assert.Equal(t, CacheKey("/image.png", a), CacheKey("image.png", b))
+
+	c := url.Values{"auto": {"format"}, "w": {"400"}, "h": {"300"}}
+	assert.NotEqual(t, CacheKey("/image.png", a), CacheKey("/image.png", c))
+}
+
+func TestCanonical_CacheKeyNoParams(t *testing.T) {
+	assert.Equal(t, "/image.png", CacheKey("image.png", nil))
+	assert.Equal(t, "/http%3A%2F%2Fwww.this.com%2Fpic.jpg", CacheKey("http://www.this.com/pic.jpg", nil))
+}
+
+func TestCanonical_CacheKeyDoesNotModifyParams(t *testing.T) {
+	params := url.Values{"auto": {"format", "compress"}, "s": {"abc"}}
+	CacheKey("image.png", params)
+	assert.Equal(t, url.Values{"auto": {"format", "compress"}, "s": {"abc"}}, params)
+}
+
+func TestCanonical_CacheKeyWithMemberOrder(t *testing.T) {
+	// The queries that imgix-core-js emits for the same auto params,
+	// alongside the sorted queries that are emitted by default.
+	fixtures := []struct {
+		auto      string
+		coreJS    string
+		sortedKey string
+	}{
+		{"format", "auto=format", "auto=format"},
+		{"format,compress", "auto=format%2Ccompress", "auto=compress%2Cformat"},
+		{"compress,format", "auto=compress%2Cformat", "auto=compress%2Cformat"},
+		{"format,enhance,compress", "auto=format%2Cenhance%2Ccompress", "auto=compress%2Cenhance%2Cformat"},
+		{"redeye,format", "auto=redeye%2Cformat", "auto=format%2Credeye"},
+	}
+
+	for _, f := range fixtures {
+		params := url.Values{"auto": {f.auto}, "w": {"400"}}
+		assert.Equal(t, "/image.png?"+f.sortedKey+"&w=400", CacheKey("image.png", params))
+		assert.Equal(t, "/image.png?"+f.sortedKey+"&w=400", CacheKeyWithMemberOrder("image.png", params, SortedMembers))
+		assert.Equal(t, "/image.png?"+f.coreJS+"&w=400", CacheKeyWithMemberOrder("image.png", params, InsertionOrderMembers))
+	}
+
+	// Members are still trimmed and de-duplicated, in order of first
+	// appearance.
+	params := url.Values{"auto": {"format, compress", "format"}}
+	assert.Equal(t, "/image.png?auto=format%2Ccompress", CacheKeyWithMemberOrder("image.png", params, InsertionOrderMembers))
+
+	// ParamsEqual ignores the order of members regardless.
+	assert.True(t, ParamsEqual(url.Values{"auto": {"format,compress"}}, url.Values{"auto": {"compress,format"}}))
+}
+
+func TestCanonical_WithAutoFormatMemberOrder(t *testing.T) {
+	params := []IxParam{Param("auto", "enhance,compress")}
+
+	// By default, AutoFormatSrcset sorts the members of auto...
+	c := testClient()
+	srcset := c.AutoFormatSrcset("image.png", params, WithWidths(100))
+	assert.Equal(t, "https://test.imgix.net/image.png?auto=compress%2Cenhance%2Cformat&w=100 100w", srcset)
+
+	// ...whereas InsertionOrderMembers appends format after the given
+	// members.
+	c = NewURLBuilder("test.imgix.net", WithLibParam(false), WithAutoFormatMemberOrder(InsertionOrderMembers))
+	srcset = c.AutoFormatSrcset("image.png", params, WithWidths(100))
+	assert.Equal(t, "https://test.imgix.net/image.png?auto=enhance%2Ccompress%2Cformat&w=100 100w", srcset)
+}
+
+func TestCanonical_WithCanonicalParamNames(t *testing.T) {
+	u := NewURLBuilder(
+		"my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithCanonicalParamNames())
+
+	expected := "https://my-social-network.imgix.net/users/1.png?h=300&w=400&s=1a4e48641614d1109c6a7af51be23d18"
+	long := u.CreateURL("/users/1.png", Param("height", "300"), Param("width", "400"))
+	short := u.CreateURL("/users/1.png", Param("h", "300"), Param("w", "400"))
+	assert.Equal(t, expected, long)
+	assert.Equal(t, expected, short)
+}
+
+func TestCanonical_WithLongParamNames(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithLongParamNames())
+	expected := "https://test.imgix.net/image.png?quality=60&width=400"
+	assert.Equal(t, expected, u.CreateURL("image.png", Param("w", "400"), Param("q", "60")))
+}
+
+func TestCanonical_renameParams(t *testing.T) {
+	// The canonical key wins over its alias.
+	params := url.Values{"width": {"400"}, "w": {"100"}, "fit": {"crop"}}
+	expected := url.Values{"w": {"100"}, "fit": {"crop"}}
+	assert.Equal(t, expected, renameParams(params, ParamAliases))
+
+	params = url.Values{"width": {"400"}}
+	assert.Equal(t, url.Values{"w": {"400"}}, renameParams(params, ParamAliases))
+
+	// Of several aliases of the same key, the first in sorted order wins,
+	// regardless of map iteration order.
+	aliases := map[string]string{"width": "w", "wid": "w", "x-width": "w"}
+	for i := 0; i < 20; i++ {
+		params = url.Values{"width": {"400"}, "wid": {"300"}, "x-width": {"200"}}
+		assert.Equal(t, url.Values{"w": {"300"}}, renameParams(params, aliases))
+	}
+	assert.Equal(t, map[string]string{"w": "wid"}, invertAliases(aliases))
+}
+
+func TestCanonical_WithCanonicalParamNamesSnapshot(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithCanonicalParamNames())
+
+	ParamAliases["wd"] = "w"
+	defer delete(ParamAliases, "wd")
+
+	assert.Equal(t, "https://test.imgix.net/image.png?wd=400", u.CreateURL("image.png", Param("wd", "400")))
+	assert.Equal(t, "https://test.imgix.net/image.png?w=100",
+		u.CreateURL("image.png", Param("w", "100"), Param("width", "400")))
+}
+
+func TestCanonical_WithDefaultParams(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net",
+		WithLibParam(false),
+		WithDefaultParams(url.Values{"auto": {"format"}, "q": {"75"}}))
+
+	expected := "https://test.imgix.net/image.png?auto=format%2Ccompress&q=40"
+	actual := u.CreateURL("image.png", Param("auto", "compress"), Param("q", "40"))
+	assert.Equal(t, expected, actual)
+
+	expected = "https://test.imgix.net/image.png?auto=format&q=75"
+	assert.Equal(t, expected, u.CreateURL("image.png"))
+}
+
+func TestCanonical_WithDedupeParams(t *testing.T) {
+	defaults := url.Values{"auto": {"format,compress"}}
+	redundant := []IxParam{Param("auto", "format", "compress"), Param("w", "400")}
+
+	u := NewURLBuilder("my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithDefaultParams(defaults))
+	expected := "https://my-social-network.imgix.net/image.png" +
+		"?auto=format%2Ccompress%2Cformat%2Ccompress&w=400&s=" +
+		createMd5Signature("FOO123bar", "/image.png", "auto=format%2Ccompress%2Cformat%2Ccompress&w=400")
+	assert.Equal(t, expected, u.CreateURL("image.png", redundant...))
+
+	deduped := NewURLBuilder("my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithDefaultParams(defaults),
+		WithDedupeParams())
+	expected = "https://my-social-network.imgix.net/image.png" +
+		"?auto=format%2Ccompress&w=400&s=" +
+		createMd5Signature("FOO123bar", "/image.png", "auto=format%2Ccompress&w=400")
+	assert.Equal(t, expected, deduped.CreateURL("image.png", redundant...))
+}
+
+func TestCanonical_dedupeParams(t *testing.T) {
+	params := url.Values{"auto": {"format,compress", "format"}, "txt": {"a", "a", "b"}}
+	expected := url.Values{"auto": {"format", "compress"}, "txt": {"a", "b"}}
+	assert.Equal(t, expected, dedupeParams(params))
+}
+
+func TestCanonical_ParamsEqual(t *testing.T) {
+	equal := []struct{ a, b url.Values }{
+		{
+			url.Values{"w": {"400"}, "h": {"300"}},
+			url.Values{"h": {"300"}, "w": {"400"}},
+		},
+		{
+			url.Values{"auto": {"format,compress"}},
+			url.Values{"auto": {"compress", "format", "compress"}},
+		},
+		{
+			url.Values{"dpr": {"2.0"}, "fp-x": {".50"}},
+			url.Values{"dpr": {"2"}, "fp-x": {"0.5"}},
+		},
+		{
+			url.Values{"w": {"400"}, "s": {"abc"}, "ixlib": {"go-v2.0.2"}},
+			url.Values{"w": {"400"}},
+		},
+		{nil, url.Values{}},
+	}
+
+	for _, test := range equal {
+		assert.True(t, ParamsEqual(test.a, test.b))
+		assert.Equal(t, ParamsHash(test.a), ParamsHash(test.b))
+	}
+
+	unequal := []struct{ a, b url.Values }{
+		{url.Values{"w": {"400"}}, url.Values{"w": {"401"}}},
+		{url.Values{"bg": {"000"}}, url.Values{"bg": {"0"}}},
+		{url.Values{"txt": {"2.0"}}, url.Values{"txt": {"2"}}},
+		{url.Values{"w": {"400"}}, url.Values{"w": {"400"}, "h": {"300"}}},
+	}
+
+	for _, test := range unequal {
+		assert.False(t, ParamsEqual(test.a, test.b))
+		assert.NotEqual(t, ParamsHash(test.a), ParamsHash(test.b))
+	}
+}
+
+func TestCanonical_ParamsHashIsStable(t *testing.T) {
+	params := url.Values{"w": {"400"}, "auto": {"format,compress"}}
+	expected := ParamsHash(params)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, expected, ParamsHash(params))
+	}
+	assert.Equal(t, 64, len(expected))
+	assert.Equal(t, url.Values{"w": {"400"}, "auto": {"format,compress"}}, params)
+}
+
+func TestCanonical_RegisterSetParam(t *testing.T) {
+	a := url.Values{"custom": {"b,a"}}
+	b := url.Values{"custom": {"a", "b", "a"}}
+	assert.False(t, ParamsEqual(a, b))
+
+	RegisterSetParam("custom")
+	t.Cleanup(func() { unregisterSetParam("custom") })
+
+	assert.True(t, ParamsEqual(a, b))
+	assert.Equal(t, CacheKey("image.png", a), CacheKey("image.png", b))
+	assert.Equal(t, url.Values{"custom": {"a,b"}}, canonicalizeParams(b, SortedMembers))
+
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithDedupeParams(),
+		WithDefaultParams(url.Values{"custom": {"a"}}))
+	assert.Equal(t, "https://test.imgix.net/image.png?custom=a%2Cb", u.CreateURL("image.png", Param("custom", "a,b")))
+}
+
+func TestCanonical_RegisterSetParamConcurrently(t *testing.T) {
+	keys := []string{"custom-a", "custom-b", "custom-c"}
+	t.Cleanup(func() {
+		for _, key := range keys {
+			unregisterSetParam(key)
+		}
+	})
+
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(2)
+		go func(key string) {
+			defer wg.Done()
+			RegisterSetParam(key)
+		}(key)
+		go func(key string) {
+			defer wg.Done()
+			CacheKey("image.png", url.Values{key: {"b,a"}})
+		}(key)
+	}
+	wg.Wait()
+
+	for _, key := range keys {
+		assert.True(t, isSetParam(key))
+	}
+}
+
+// unregisterSetParam undoes RegisterSetParam, so that tests leave the
+// registry as they found it.
+func unregisterSetParam(key string) {
+	setParamsMu.Lock()
+	defer setParamsMu.Unlock()
+	delete(setParams, key)
+}
+
+func TestCanonical_ExtractParams(t *testing.T) {
+	all := url.Values{
+		"img_w":    {"400"},
+		"img_fit":  {"crop"},
+		"img_auto": {"format", "compress"},
+		"img_":     {"ignored"},
+		"title":    {"Hello"},
+		"w":        {"100"},
+		"IMG_h":    {"300"},
+	}
+
+	extracted := ExtractParams(all, "img_")
+	assert.Equal(t, url.Values{
+		"w":    {"400"},
+		"fit":  {"crop"},
+		"auto": {"format", "compress"},
+	}, extracted)
+
+	// The params passed to ExtractParams are not modified.
+	extracted["auto"][0] = "enhance"
+	assert.Equal(t, []string{"format", "compress"}, all["img_auto"])
+	assert.Equal(t, 7, len(all))
+
+	assert.Equal(t, url.Values{}, ExtractParams(all, "cdn_"))
+}
+
+func TestCanonical_MergeParams(t *testing.T) {
+	base := url.Values{"w": {"400"}, "auto": {"format"}, "fit": {"crop"}}
+	override := url.Values{"w": {"800"}, "auto": {"compress,format"}}
+
+	assert.Equal(t, url.Values{
+		"w":    {"800"},
+		"auto": {"format", "compress"},
+		"fit":  {"crop"},
+	}, MergeParams(base, override))
+
+	// Neither base nor override is modified.
+	assert.Equal(t, url.Values{"w": {"400"}, "auto": {"format"}, "fit": {"crop"}}, base)
+	assert.Equal(t, url.Values{"w": {"800"}, "auto": {"compress,format"}}, override)
+}
+
+func TestCanonical_MergeParamsAll(t *testing.T) {
+	route := url.Values{"w": {"400"}, "fit": {"crop"}, "auto": {"format"}}
+	user := url.Values{"w": {"600"}, "auto": {"compress"}, "crop": {"faces"}}
+	experiment := url.Values{"q": {"60"}, "fit": {"max"}, "auto": {"enhance,format"}, "crop": {"edges,faces"}}
+
+	merged := MergeParamsAll(route, user, experiment)
+	assert.Equal(t, url.Values{
+		"w":    {"600"},
+		"fit":  {"max"},
+		"q":    {"60"},
+		"auto": {"format", "compress", "enhance"},
+		"crop": {"faces", "edges"},
+	}, merged)
+
+	c := testClient()
+	assert.Equal(t, "https://test.imgix.net/image.png?auto=format%2Ccompress%2Cenhance&crop=faces%2Cedges&fit=max&q=60&w=600",
+		c.createURLFromValues("image.png", merged))
+
+	assert.Equal(t, url.Values{}, MergeParamsAll())
+	assert.Equal(t, route, MergeParamsAll(route))
+}
@@ -4,9 +4,12 @@ import (
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/hex"
+	"fmt"
+	"hash"
 	"net/url"
 	"sort"
 	"strings"
+	"sync"
 )
 
 // checkProxyStatus checks if the path has one of the four possible
@@ -19,6 +22,14 @@ import (
 // This might be "just enough validation," but if we run into issues
 // we can make this check smarter/more-robust.
 func checkProxyStatus(p string) (isProxy bool, isEncoded bool) {
+	scheme, isEncoded := checkProxyScheme(p)
+	return scheme != "", isEncoded
+}
+
+// checkProxyScheme functions like checkProxyStatus except that it
+// reports the scheme ("http" or "https") of a proxy path's source. If
+// the path is not a proxy, the scheme is empty.
+func checkProxyScheme(p string) (scheme string, isEncoded bool) {
 	path := p
 	if strings.HasPrefix(p, "/") {
 		path = p[1:]
@@ -26,23 +37,53 @@ func checkProxyStatus(p string) (isProxy bool, isEncoded bool) {
 
 	const asciiHTTP = "http://"
 	const asciiHTTPS = "https://"
-	if strings.HasPrefix(path, asciiHTTP) || strings.HasPrefix(path, asciiHTTPS) {
-		return true, false
+	if strings.HasPrefix(path, asciiHTTP) {
+		return "http", false
+	}
+	if strings.HasPrefix(path, asciiHTTPS) {
+		return "https", false
 	}
 
+	// The hex digits of percent-encodings are case-insensitive, so the
+	// encoded prefixes are matched in any case (e.g. "%3A%2F%2F",
+	// "%3a%2f%2f", or even "%3A%2f%2F").
 	const encodedHTTP = "http%3A%2F%2F"
 	const encodedHTTPS = "https%3A%2F%2F"
-	if strings.HasPrefix(path, encodedHTTP) || strings.HasPrefix(path, encodedHTTPS) {
-		return true, true
+	if hasEncodedPrefix(path, encodedHTTP) {
+		return "http", true
+	}
+	if hasEncodedPrefix(path, encodedHTTPS) {
+		return "https", true
 	}
 
-	const encodedHTTPLower = "http%3a%2f%2f"
-	const encodedHTTPSLower = "https%3a%ff%2f"
-	if strings.HasPrefix(path, encodedHTTPLower) || strings.HasPrefix(path, encodedHTTPSLower) {
-		return true, true
+	return "", false
+}
+
+// hasEncodedPrefix reports whether path begins with the percent-encoded
+// prefix, ignoring the case of the prefix's percent-encodings only.
+func hasEncodedPrefix(path string, prefix string) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		if path[i] == prefix[i] {
+			continue
+		}
+		// Allow a hex digit of a percent-encoding to differ in case.
+		isHexDigit := (i >= 1 && prefix[i-1] == '%') || (i >= 2 && prefix[i-2] == '%')
+		if !isHexDigit || !strings.EqualFold(path[i:i+1], prefix[i:i+1]) {
+			return false
+		}
 	}
+	return true
+}
 
-	return false, false
+// IsProxyPath reports whether the builder treats the path as a web
+// proxy source (e.g. "https://example.com/image.png" or its
+// percent-encoded form). As in the builder, a leading slash is ignored.
+func IsProxyPath(path string) bool {
+	isProxy, _ := checkProxyStatus(path)
+	return isProxy
 }
 
 // encodeProxy will encode the given path string if it hasn't been
@@ -107,25 +148,184 @@ func splitAndEscape(path string) string {
 	return strings.Join(result, "/")
 }
 
+// strictEncodePath encodes the given path string like encodePath,
+// except that each component is encoded with encodeURIComponent, as
+// imgix-core-js does. A proxy path is instead encoded as a single
+// component, unless it's already percent-encoded.
+func strictEncodePath(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return "/"
+	}
+
+	isProxy, isEncoded := checkProxyStatus(path)
+	if isProxy {
+		if isEncoded {
+			return "/" + path
+		}
+		return "/" + encodeURIComponent(path)
+	}
+
+	return strictEncodeComponents(path)
+}
+
+// strictEncodeComponents encodes each of the path's components with
+// encodeURIComponent, without regard for whether the path is a proxy.
+func strictEncodeComponents(path string) string {
+	components := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, component := range components {
+		components[i] = encodeURIComponent(component)
+	}
+	return "/" + strings.Join(components, "/")
+}
+
+// encodeURIComponent percent-encodes s exactly as the JavaScript
+// function of the same name does: every byte of its UTF-8 encoding is
+// escaped except for ASCII letters, digits, and "-_.!~*'()".
+//
+// Unlike url.PathEscape, it escapes the sub-delims "$&+,:;=@" and it
+// doesn't escape "!*'()".
+func encodeURIComponent(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isURIComponentSafe(c) {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteByte('%')
+		sb.WriteByte(hexDigits[c>>4])
+		sb.WriteByte(hexDigits[c&15])
+	}
+	return sb.String()
+}
+
+// isURIComponentSafe reports whether encodeURIComponent leaves the byte
+// c unescaped.
+func isURIComponentSafe(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("-_.!~*'()", c) >= 0
+}
+
+// collapseSlashes replaces each run of consecutive forward slashes in
+// path with a single forward slash.
+func collapseSlashes(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	return path
+}
+
+// escapeChars percent-encodes each occurrence of the (ASCII) chars
+// within s. The '%' character should not be among the chars, since the
+// percent-encodings would then be re-encoded.
+func escapeChars(s string, chars string) string {
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if strings.IndexByte(chars, c) >= 0 {
+			sb.WriteString(fmt.Sprintf("%%%02X", c))
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
 // encodeQueryString encodes a set of params into a form that can be
 // safely used within the query string of a URL.
 func encodeQuery(params url.Values) (encodedQueryParts []string) {
+	return encodeQueryOrdered(params, nil)
+}
 
-	keys := make([]string, 0, len(params))
-
-	for k := range params {
-		keys = append(keys, k)
+// encodeQueryOrdered functions like encodeQuery except that the params
+// whose keys appear in order are encoded first, in that order. The rest
+// of the params follow, sorted by key.
+func encodeQueryOrdered(params url.Values, order []string) (encodedQueryParts []string) {
+	if len(params) == 0 {
+		return nil
 	}
-	sort.Strings(keys)
 
-	for _, k := range keys {
+	// This is on the hot path of every URL, so the parts are allocated
+	// up front and each pair is concatenated directly (in a single
+	// allocation) rather than joined.
+	encodedQueryParts = make([]string, 0, len(params))
+	for _, k := range orderedKeys(params, order) {
 		encodedKey, encodedValue := encodeQueryParam(k, params[k])
-		encodedPairStr := strings.Join([]string{encodedKey, encodedValue}, "=")
-		encodedQueryParts = append(encodedQueryParts, encodedPairStr)
+		encodedQueryParts = append(encodedQueryParts, encodedKey+"="+encodedValue)
 	}
 	return encodedQueryParts
 }
 
+// writeQuery functions like encodeQueryOrdered except that the encoded
+// params are written to sb, delimited by '&', which avoids allocating
+// each pair separately. If bare is set, the '=' of params with empty
+// values is omitted (e.g. "bust" rather than "bust=").
+func writeQuery(sb *strings.Builder, params url.Values, order []string, bare bool) {
+	// Grow sb to the unencoded length of the query, which is usually
+	// close to its encoded length.
+	n := 0
+	for k, v := range params {
+		n += len(k) + 2
+		for _, value := range v {
+			n += len(value) + 1
+		}
+	}
+	sb.Grow(n)
+
+	for i, k := range orderedKeys(params, order) {
+		encodedKey, encodedValue := encodeQueryParam(k, params[k])
+		if i > 0 {
+			sb.WriteByte('&')
+		}
+		sb.WriteString(encodedKey)
+		if encodedValue != "" || !bare {
+			sb.WriteByte('=')
+			sb.WriteString(encodedValue)
+		}
+	}
+}
+
+// orderedKeys returns the keys of params, beginning with those that
+// appear in order (in that order) and followed by the rest, sorted.
+func orderedKeys(params url.Values, order []string) []string {
+	keys := make([]string, 0, len(params))
+	if len(order) == 0 {
+		for k := range params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	seen := make(map[string]bool, len(order))
+
+	for _, k := range order {
+		if _, ok := params[k]; ok && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+
+	rest := make([]string, 0, len(params)-len(keys))
+	for k := range params {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
 // encodedQueryParam encodes a key and values into forms that can be
 // safely placed within a URL query string. If the key has been
 // suffixed with the base64 suffix, "64" (e.g. "text64"), then its
@@ -156,7 +356,9 @@ func encodeQueryParam(key string, values []string) (eK string, eV string) {
 
 // encodeQueryParamValue uses url.QueryEscape to escape the queryValue
 // into a form that is safe to use in URLs. Note that net/url uses
-// plus (+) as SPACE and does not percent-encode '+' to "%20".
+// plus (+) as SPACE and does not percent-encode '+' to "%20". Fragment
+// delimiters are escaped too (e.g. bg=#fff becomes bg=%23fff), so that a
+// '#' in a value can't truncate the query and break its signature.
 func encodeQueryParamValue(queryValue string) string {
 	return url.QueryEscape(queryValue)
 }
@@ -193,22 +395,67 @@ func unPadBase64Value(s string) string {
 	return s
 }
 
-// createMd5Signature creates the signature by joining the token, path, and params
-// strings into a signatureBase. Next, create a hashedSig and write the
-// signatureBase into it. Finally, return the encoded, signed string.
-func createMd5Signature(token string, path string, query string) string {
-	var delim string
+// SignatureBase returns the string that is hashed to create a URL's
+// signature, of the form {TOKEN}{PATH}{DELIM}{QUERY}, where the path and
+// query are already encoded and the delimiter is '?' if the query isn't
+// empty. Comparing it against the base expected by imgix is the fastest
+// way to diagnose a signature mismatch.
+//
+// The signature base contains the token, so it is never logged by this
+// package; take care not to log it either.
+func SignatureBase(token string, path string, query string) string {
+	return string(appendSignatureBase(nil, token, path, query))
+}
 
-	if query == "" {
-		delim = ""
-	} else {
-		delim = "?"
+// appendSignatureBase appends the signature base (see SignatureBase) to
+// dst and returns the extended buffer.
+func appendSignatureBase(dst []byte, token string, path string, query string) []byte {
+	dst = append(dst, token...)
+	dst = append(dst, path...)
+	if query != "" {
+		dst = append(dst, '?')
+		dst = append(dst, query...)
 	}
+	return dst
+}
+
+// md5Signer holds an md5 hasher along with the buffers used to sign a
+// URL, so that they can be reused across signatures (see md5Pool).
+type md5Signer struct {
+	hash hash.Hash
+	base []byte         // The signature base.
+	sum  [md5.Size]byte // The hash of the signature base.
+}
+
+// maxPooledSignatureBase is the capacity above which a signer's base
+// buffer isn't returned to md5Pool, so that one very long URL doesn't pin
+// a large buffer in the pool.
+const maxPooledSignatureBase = 8 << 10
+
+// md5Pool pools md5Signers, so that signing a URL doesn't allocate a new
+// hasher and buffers. Signers are reset before they're returned to the
+// pool, so no state carries over between signatures.
+var md5Pool = sync.Pool{
+	New: func() interface{} {
+		return &md5Signer{hash: md5.New()}
+	},
+}
+
+// createMd5Signature creates the signature by writing the signature base
+// (see SignatureBase) into a pooled hasher. Finally, it returns the
+// hex-encoded hash.
+func createMd5Signature(token string, path string, query string) string {
+	signer := md5Pool.Get().(*md5Signer)
+	defer func() {
+		if cap(signer.base) > maxPooledSignatureBase {
+			return
+		}
+		signer.hash.Reset()
+		signer.base = signer.base[:0]
+		md5Pool.Put(signer)
+	}()
 
-	// The expected signature base has the form:
-	// {TOKEN}{PATH}{DELIM}{QUERY}
-	signatureBase := strings.Join([]string{token, path, delim, query}, "")
-	hashedSig := md5.New()
-	hashedSig.Write([]byte(signatureBase))
-	return hex.EncodeToString(hashedSig.Sum(nil))
+	signer.base = appendSignatureBase(signer.base, token, path, query)
+	signer.hash.Write(signer.base)
+	return hex.EncodeToString(signer.hash.Sum(signer.sum[:0]))
 }
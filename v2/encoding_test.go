@@ -1,7 +1,13 @@
 package imgix
 
 import (
+	"crypto/md5"
 	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -43,6 +49,75 @@ func TestEncoding_base64UTF8(t *testing.T) {
 	assert.Equal(t, expected, actual)
 }
 
+func TestEncoding_base64RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"empty", ""},
+		{"one byte", "a"},
+		{"two bytes", "ab"},
+		{"three bytes", "abc"},
+		{"four bytes", "abcd"},
+		{"emoji", "😱🎉👩‍👩‍👧"},
+		{"emoji with skin tone", "👍🏽"},
+		{"flag", "🇯🇵"},
+		{"cjk", "こんにちは世界"},
+		{"korean", "안녕하세요"},
+		{"arabic", "مرحبا بالعالم"},
+		{"hebrew", "שלום עולם"},
+		{"mixed direction", "abc שלום 123 مرحبا"},
+		{"rtl mark", "\u200fשלום\u200e"},
+		{"url-safe alphabet", "\xfb\xff\xbf~~~???"},
+		{"reserved characters", "+/=&?#%"},
+		{"multi-line", "Hello\nWorld"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded := base64EncodeQueryParamValue(test.text)
+			assert.NotContains(t, encoded, "+")
+			assert.NotContains(t, encoded, "/")
+			assert.NotContains(t, encoded, "=")
+
+			decoded, err := DecodeBase64Param(encoded)
+			assert.NoError(t, err)
+			assert.Equal(t, test.text, decoded)
+
+			u := testClient()
+			parsed, err := ParseURL(u.CreateURL("image.png", Param("txt64", test.text)))
+			assert.NoError(t, err)
+			assert.Equal(t, test.text, parsed.Params.Get("txt64"))
+		})
+	}
+}
+
+func TestEncoding_base64Padding(t *testing.T) {
+	// Unpadded values are 0, 2, or 3 characters past a multiple of four,
+	// for inputs of 0, 1, or 2 bytes past a multiple of three.
+	for n := 0; n < 12; n++ {
+		text := strings.Repeat("é", n)[:n]
+		encoded := base64EncodeQueryParamValue(text)
+
+		expectedRem := map[int]int{0: 0, 1: 2, 2: 3}[n%3]
+		assert.Equal(t, expectedRem, len(encoded)%4)
+		assert.Equal(t, base64.RawURLEncoding.EncodeToString([]byte(text)), encoded)
+
+		decoded, err := DecodeBase64Param(encoded)
+		assert.NoError(t, err)
+		assert.Equal(t, text, decoded)
+	}
+
+	// Padded values decode as well.
+	decoded, err := DecodeBase64Param("YQ==")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", decoded)
+
+	// A single character past a multiple of four is never valid.
+	_, err = DecodeBase64Param("YWJjZ")
+	assert.Error(t, err)
+}
+
 func TestEncoding_BlueprintBase64(t *testing.T) {
 	s := `Hello,+World!`
 	actual := base64EncodeQueryParamValue(s)
@@ -89,3 +164,278 @@ func TestEncoding_encodePathProxyRaw(t *testing.T) {
 
 	assert.Equal(t, expected, actual)
 }
+
+func TestEncoding_IsProxyPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"http://www.this.com/pic.jpg", true},
+		{"https://www.this.com/pic.jpg", true},
+		{"/http://www.this.com/pic.jpg", true},
+		{"/https://www.this.com/pic.jpg", true},
+		{"http%3A%2F%2Fwww.this.com%2Fpic.jpg", true},
+		{"/https%3A%2F%2Fwww.this.com%2Fpic.jpg", true},
+		{"http%3a%2f%2fwww.this.com%2fpic.jpg", true},
+		{"/https%3a%2f%2fwww.this.com%2fpic.jpg", true},
+		{"", false},
+		{"/", false},
+		{"pic.jpg", false},
+		{"/users/http/pic.jpg", false},
+		{"ftp://www.this.com/pic.jpg", false},
+		{"//http://www.this.com/pic.jpg", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, IsProxyPath(test.path), test.path)
+	}
+}
+
+func TestEncoding_escapeChars(t *testing.T) {
+	assert.Equal(t, "/a%40b%7E.png", escapeChars("/a@b~.png", DefaultAggressiveEscapes))
+	assert.Equal(t, "/plain.png", escapeChars("/plain.png", DefaultAggressiveEscapes))
+	assert.Equal(t, "/a%20b.png", escapeChars("/a%20b.png", DefaultAggressiveEscapes))
+}
+
+func TestEncoding_encodeQueryNil(t *testing.T) {
+	assert.Equal(t, 0, len(encodeQuery(nil)))
+
+	key, value := encodeQueryParam("w", nil)
+	assert.Equal(t, "w", key)
+	assert.Equal(t, "", value)
+
+	key, value = encodeQueryParam("txt64", nil)
+	assert.Equal(t, "txt64", key)
+	assert.Equal(t, "", value)
+}
+
+func TestEncoding_SignatureBase(t *testing.T) {
+	assert.Equal(t, "FOO123bar/users/1.png?h=300&w=400", SignatureBase("FOO123bar", "/users/1.png", "h=300&w=400"))
+	assert.Equal(t, "FOO123bar/users/1.png", SignatureBase("FOO123bar", "/users/1.png", ""))
+}
+
+func TestEncoding_orderedKeys(t *testing.T) {
+	params := url.Values{"w": {"1"}, "h": {"2"}, "fit": {"crop"}, "auto": {"format"}}
+	assert.Equal(t, []string{"auto", "fit", "h", "w"}, orderedKeys(params, nil))
+	assert.Equal(t, []string{"w", "h", "auto", "fit"}, orderedKeys(params, []string{"w", "q", "h", "w"}))
+}
+
+func TestEncoding_strictEncodePath(t *testing.T) {
+	// The expected values match imgix-core-js, which encodes each path
+	// component with encodeURIComponent.
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"images/hello world!.png", "/images/hello%20world!.png"},
+		{"/images/(1)*it's.jpg", "/images/(1)*it's.jpg"},
+		{"a$&+,:;=@~b.png", "/a%24%26%2B%2C%3A%3B%3D%40~b.png"},
+		{"ü/its:(ok).png", "/%C3%BC/its%3A(ok).png"},
+		{"https://example.com/a (1)!.png", "/https%3A%2F%2Fexample.com%2Fa%20(1)!.png"},
+		{"https%3A%2F%2Fexample.com%2Fa.png", "/https%3A%2F%2Fexample.com%2Fa.png"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, strictEncodePath(test.path))
+	}
+}
+
+func TestEncoding_checkProxyScheme(t *testing.T) {
+	tests := []struct {
+		path      string
+		scheme    string
+		isEncoded bool
+	}{
+		{"http://example.com/a.png", "http", false},
+		{"/https://example.com/a.png", "https", false},
+		{"http%3A%2F%2Fexample.com%2Fa.png", "http", true},
+		{"https%3a%2f%2fexample.com%2fa.png", "https", true},
+		{"images/a.png", "", false},
+	}
+
+	for _, test := range tests {
+		scheme, isEncoded := checkProxyScheme(test.path)
+		assert.Equal(t, test.scheme, scheme)
+		assert.Equal(t, test.isEncoded, isEncoded)
+	}
+}
+
+func TestEncoding_collapseSlashes(t *testing.T) {
+	assert.Equal(t, "/a/b.jpg", collapseSlashes("/a//b.jpg"))
+	assert.Equal(t, "/a/b/", collapseSlashes("///a////b//"))
+	assert.Equal(t, "a/b.jpg", collapseSlashes("a/b.jpg"))
+	assert.Equal(t, "", collapseSlashes(""))
+}
+
+func TestEncoding_checkProxyStatusMixedCase(t *testing.T) {
+	for _, path := range []string{"https%3A%2f%2Fexample.com%2Fa.png", "/http%3a%2F%2fexample.com%2Fa.png"} {
+		isProxy, isEncoded := checkProxyStatus(path)
+		assert.True(t, isProxy)
+		assert.True(t, isEncoded)
+	}
+
+	// Only the percent-encodings are case-insensitive.
+	for _, path := range []string{"HTTPS%3A%2F%2Fexample.com%2Fa.png", "https%3A%2F%2"} {
+		isProxy, _ := checkProxyStatus(path)
+		assert.False(t, isProxy)
+	}
+}
+
+func TestEncoding_encodeQueryParamValueHash(t *testing.T) {
+	assert.Equal(t, "%23fff", encodeQueryParamValue("#fff"))
+
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	actual := u.CreateURL("users/1.png", Param("bg", "#fff"), Param("w", "400"))
+
+	const query = "bg=%23fff&w=400"
+	assert.Equal(t, "https://test.imgix.net/users/1.png?"+query+"&s="+
+		createMd5Signature("FOO123bar", "/users/1.png", query), actual)
+
+	// The full query survives parsing, and the signature matches it.
+	parsed, err := url.Parse(actual)
+	assert.NoError(t, err)
+	assert.Equal(t, "", parsed.Fragment)
+	assert.Equal(t, "#fff", parsed.Query().Get("bg"))
+	assert.Equal(t, "400", parsed.Query().Get("w"))
+	assert.Equal(t, createMd5Signature("FOO123bar", parsed.EscapedPath(), query), parsed.Query().Get("s"))
+}
+
+func TestEncoding_encodePathHash(t *testing.T) {
+	assert.Equal(t, "/images/a%23b.png", encodePath("images/a#b.png"))
+
+	c := testClient()
+	actual := c.CreateURL("images/a#b.png", Param("w", "100"))
+	assert.Equal(t, "https://test.imgix.net/images/a%23b.png?w=100", actual)
+
+	parsed, err := url.Parse(actual)
+	assert.NoError(t, err)
+	assert.Equal(t, "", parsed.Fragment)
+	assert.Equal(t, "/images/a#b.png", parsed.Path)
+}
+
+func TestEncoding_writeQuery(t *testing.T) {
+	params := url.Values{
+		"w":     {"400"},
+		"auto":  {"format", "compress"},
+		"txt64": {"Hello, World"},
+		"txt":   {"a b&c"},
+		"bust":  {""},
+	}
+
+	for _, order := range [][]string{nil, {"w", "txt"}} {
+		var sb strings.Builder
+		writeQuery(&sb, params, order, false)
+		assert.Equal(t, strings.Join(encodeQueryOrdered(params, order), "&"), sb.String())
+	}
+
+	var sb strings.Builder
+	writeQuery(&sb, params, nil, true)
+	assert.Equal(t, "auto=format%2Ccompress&bust&txt=a+b%26c&txt64=SGVsbG8sIFdvcmxk&w=400", sb.String())
+
+	sb.Reset()
+	writeQuery(&sb, nil, nil, false)
+	assert.Equal(t, "", sb.String())
+}
+
+func TestEncoding_createMd5Signature(t *testing.T) {
+	tests := []struct{ token, path, query string }{
+		{"FOO123bar", "/users/1.png", "w=400"},
+		{"FOO123bar", "/users/1.png", ""},
+		{"", "/", ""},
+		{"a-much-longer-token-than-usual", "/https%3A%2F%2Fexample.com%2Fa.png", strings.Repeat("txt=x&", 100)},
+		// A base too long to be pooled.
+		{"FOO123bar", "/users/1.png", strings.Repeat("txt=x&", maxPooledSignatureBase)},
+	}
+
+	for _, test := range tests {
+		sum := md5.Sum([]byte(SignatureBase(test.token, test.path, test.query)))
+		expected := hex.EncodeToString(sum[:])
+
+		// Pooled hashers are reset, so repeated signatures are identical.
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, expected, createMd5Signature(test.token, test.path, test.query))
+		}
+	}
+}
+
+func TestEncoding_appendSignatureBase(t *testing.T) {
+	dst := []byte("prefix:")
+	dst = appendSignatureBase(dst, "FOO123bar", "/users/1.png", "w=400")
+	assert.Equal(t, "prefix:FOO123bar/users/1.png?w=400", string(dst))
+}
+
+func TestEncoding_createMd5SignatureConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			query := "w=" + strconv.Itoa(i)
+			sum := md5.Sum([]byte(SignatureBase("FOO123bar", "/users/1.png", query)))
+			for j := 0; j < 100; j++ {
+				assert.Equal(t, hex.EncodeToString(sum[:]), createMd5Signature("FOO123bar", "/users/1.png", query))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// benchmarkParams returns n params, akin to those of a typical URL.
+func benchmarkParams(n int) url.Values {
+	params := url.Values{}
+	for i := 0; i < n; i++ {
+		params.Set("param-"+strconv.Itoa(i), "value "+strconv.Itoa(i))
+	}
+	return params
+}
+
+func BenchmarkEncoding_encodeQuery(b *testing.B) {
+	for _, n := range []int{1, 5, 20} {
+		params := benchmarkParams(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				encodeQuery(params)
+			}
+		})
+	}
+}
+
+func BenchmarkEncoding_writeQuery(b *testing.B) {
+	for _, n := range []int{1, 5, 20} {
+		params := benchmarkParams(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var sb strings.Builder
+				writeQuery(&sb, params, nil, false)
+			}
+		})
+	}
+}
+
+func BenchmarkEncoding_CreateURL(b *testing.B) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"))
+	params := []IxParam{Param("w", "400"), Param("h", "300"), Param("fit", "crop"), Param("auto", "format", "compress")}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		u.CreateURL("users/1.png", params...)
+	}
+}
+
+func BenchmarkEncoding_createMd5Signature(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		createMd5Signature("FOO123bar", "/users/1.png", "auto=format%2Ccompress&fit=crop&w=400")
+	}
+}
+
+func BenchmarkEncoding_CreateURLSigned(b *testing.B) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			u.CreateURL("users/1.png", Param("w", "400"))
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package imgix
+
+import "errors"
+
+// The errors below identify the kind of failure of the methods that
+// return an error (e.g. CreateURLE). The errors returned by those
+// methods wrap one of them, so callers can branch on the kind of failure
+// with errors.Is, e.g. errors.Is(err, ErrURLTooLong), while the message
+// still describes the failure in detail.
+var (
+	// ErrNoDomain is returned when the builder has no domain.
+	ErrNoDomain = errors.New("imgix: no domain")
+
+	// ErrEmptyToken is returned when a URL must be signed but the
+	// builder's token is empty.
+	ErrEmptyToken = errors.New("imgix: empty token")
+
+	// ErrInvalidProxySource is returned when a web proxy source is not
+	// allowed, e.g. a plaintext HTTP source (see
+	// WithRequireSecureProxySource).
+	ErrInvalidProxySource = errors.New("imgix: invalid proxy source")
+
+	// ErrURLTooLong is returned when a URL exceeds the builder's maximum
+	// URL length (see WithMaxURLLength).
+	ErrURLTooLong = errors.New("imgix: url too long")
+
+	// ErrMalformedURL is returned when a URL that the builder created
+	// fails to parse or isn't encoded canonically (see
+	// WithOutputValidation).
+	ErrMalformedURL = errors.New("imgix: malformed url")
+
+	// ErrUnknownParam is returned, wrapped in a ParamError, when a param
+	// isn't one that validation recognizes (see KnownParams).
+	ErrUnknownParam = errors.New("imgix: unknown param")
+
+	// ErrValueOutOfRange is returned, wrapped in a ParamError or a
+	// ParamWarning, when a param's value is outside of the values it
+	// accepts, e.g. a w greater than the builder's maximum (see
+	// WithMaxDimensions).
+	ErrValueOutOfRange = errors.New("imgix: param value out of range")
+)
+
+// ParamError describes a failure caused by a single param. Use
+// errors.As to access the offending param's name and value, and
+// errors.Is to check the kind of failure (e.g. ErrValueOutOfRange).
+type ParamError struct {
+	Param string // The name of the offending param, e.g. "w".
+	Value string // The value of the offending param, e.g. "5000".
+	Err   error  // The kind of failure, e.g. ErrValueOutOfRange.
+
+	msg string
+}
+
+// Error returns the error's message, which describes the failure in
+// detail. If it has none, the kind of failure (if any) and the param are
+// named.
+func (e *ParamError) Error() string {
+	if e.msg != "" {
+		return e.msg
+	}
+	if e.Err == nil {
+		return "imgix: invalid param `" + e.Param + "`"
+	}
+	return e.Err.Error() + " `" + e.Param + "`"
+}
+
+// Unwrap returns the kind of failure (e.g. ErrValueOutOfRange).
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// kindError is an error whose detailed message is wrapped around one of
+// the errors above (its kind), so that errors.Is matches the kind.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+// Error returns the error's detailed message.
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the kind of failure (e.g. ErrURLTooLong).
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
@@ -0,0 +1,121 @@
+package imgix
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors_NoDomain(t *testing.T) {
+	var u URLBuilder
+	_, err := u.CreateURLE("image.png")
+	assert.True(t, errors.Is(err, ErrNoDomain))
+}
+
+func TestErrors_EmptyToken(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithSignWhen(func(path string) bool { return true }))
+	_, err := u.CreateURLE("image.png")
+	assert.True(t, errors.Is(err, ErrEmptyToken))
+	assert.EqualError(t, err, "path `image.png` must be signed, but the token is empty")
+
+	// Without the predicate, an empty token means the URL isn't signed.
+	u = NewURLBuilder("test.imgix.net")
+	_, err = u.CreateURLE("image.png")
+	assert.NoError(t, err)
+}
+
+func TestErrors_InvalidProxySource(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithRequireSecureProxySource())
+	_, err := u.CreateURLE("http://example.com/image.png")
+	assert.True(t, errors.Is(err, ErrInvalidProxySource))
+	assert.False(t, errors.Is(err, ErrURLTooLong))
+	assert.EqualError(t, err, "proxy source must use https, found `http://example.com/image.png`")
+}
+
+func TestErrors_URLTooLong(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithMaxURLLength(20))
+	_, err := u.CreateURLE("image.png", Param("w", "100"))
+	assert.True(t, errors.Is(err, ErrURLTooLong))
+
+	n, err := u.URLLength("image.png", url.Values{"w": {"100"}})
+	assert.True(t, errors.Is(err, ErrURLTooLong))
+	assert.NotZero(t, n)
+}
+
+func TestErrors_ValueOutOfRange(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithMaxDimensions(4000, 3000))
+	_, err := u.CreateURLE("image.png", Param("h", "3001"))
+	assert.True(t, errors.Is(err, ErrValueOutOfRange))
+
+	var paramErr *ParamError
+	assert.True(t, errors.As(err, &paramErr))
+	assert.Equal(t, "h", paramErr.Param)
+	assert.Equal(t, "3001", paramErr.Value)
+	assert.Equal(t, "`h` of 3001 exceeds the maximum of 3000", err.Error())
+}
+
+func TestErrors_ParamWarning(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithValidation(ValidationStrict))
+
+	_, err := u.CreateURLE("image.png", Param("colorquant", "1"))
+	assert.True(t, errors.Is(err, ErrValueOutOfRange))
+	var warning ParamWarning
+	assert.True(t, errors.As(err, &warning))
+	assert.Equal(t, []string{"colorquant"}, warning.Keys)
+
+	// A conflict between params isn't an out-of-range value.
+	_, err = u.CreateURLE("image.png", Param("ar", "16:9"))
+	assert.True(t, errors.As(err, &warning))
+	assert.False(t, errors.Is(err, ErrValueOutOfRange))
+}
+
+func TestErrors_ParamError(t *testing.T) {
+	err := error(&ParamError{Param: "wdith", Err: ErrUnknownParam})
+	assert.True(t, errors.Is(err, ErrUnknownParam))
+	assert.False(t, errors.Is(err, ErrValueOutOfRange))
+	assert.EqualError(t, err, "imgix: unknown param `wdith`")
+
+	err = error(&ParamError{Param: "w", Err: ErrValueOutOfRange})
+	assert.True(t, errors.Is(err, ErrValueOutOfRange))
+	assert.False(t, errors.Is(err, ErrURLTooLong))
+	assert.EqualError(t, err, "imgix: param value out of range `w`")
+
+	// A ParamError without a kind of failure still has a message.
+	err = error(&ParamError{Param: "w"})
+	assert.False(t, errors.Is(err, ErrValueOutOfRange))
+	assert.EqualError(t, err, "imgix: invalid param `w`")
+}
+
+func TestErrors_UnknownParam(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithValidation(ValidationStrict))
+
+	actual, err := u.CreateURLE("image.png", Param("wdith", "400"), Param("fit", "crop"))
+	assert.True(t, errors.Is(err, ErrUnknownParam))
+	assert.Equal(t, "", actual)
+
+	var paramErr *ParamError
+	assert.True(t, errors.As(err, &paramErr))
+	assert.Equal(t, "wdith", paramErr.Param)
+	assert.Equal(t, "400", paramErr.Value)
+
+	// Known params, including long-form names, set-params, and the
+	// params that the builder adds, aren't unknown.
+	for _, param := range []IxParam{Param("w", "400"), Param("width", "400"), Param("auto", "format"),
+		Param("txt-align", "left"), Param("palette", "json"), Bust("1")} {
+		_, err = u.CreateURLE("image.png", param)
+		assert.NoError(t, err)
+	}
+
+	// Keys can be added to KnownParams.
+	KnownParams["x-custom"] = true
+	t.Cleanup(func() { delete(KnownParams, "x-custom") })
+	_, err = u.CreateURLE("image.png", Param("x-custom", "1"))
+	assert.NoError(t, err)
+
+	// Unknown params aren't checked when validation is off.
+	off := NewURLBuilder("test.imgix.net")
+	_, err = off.CreateURLE("image.png", Param("wdith", "400"))
+	assert.NoError(t, err)
+}
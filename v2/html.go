@@ -0,0 +1,312 @@
+package imgix
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// modernFormats are the formats, in order of preference, of the
+// <source> elements created by PictureModernFormats.
+var modernFormats = []struct {
+	fm       string
+	mimeType string
+}{
+	{"avif", "image/avif"},
+	{"webp", "image/webp"},
+}
+
+// PictureModernFormats creates a <picture> element for the path that
+// offers the image as AVIF, then as WebP, and then, via the fallback
+// <img>, in its original format. Each <source> has its own srcset (with
+// fm set accordingly) and the <img> has both a src and a srcset, as well
+// as the alt text, which is emitted even if it's empty (i.e. alt="",
+// which marks the image as decorative). All URLs are signed if the
+// builder has a token, and all attribute values are HTML-escaped.
+func (b *URLBuilder) PictureModernFormats(
+	path string,
+	params []IxParam,
+	alt string,
+	options ...SrcsetOption) string {
+
+	urlParams := applyParams(params)
+
+	opts := newSrcsetOpts(options...)
+	if err := opts.validateFor(b.isDprBased(path, urlParams)); err != nil {
+		log.Fatalln(err)
+	}
+	b = b.checkOnce(path, urlParams)
+
+	var sb strings.Builder
+	sb.WriteString("<picture>")
+
+	for _, format := range modernFormats {
+		sourceParams := cloneValues(urlParams)
+		sourceParams.Set("fm", format.fm)
+		srcset := b.createSrcsetFromValues(path, sourceParams, opts)
+
+		sb.WriteString("<source")
+		writeAttr(&sb, "type", format.mimeType)
+		writeAttr(&sb, "srcset", srcset)
+		sb.WriteString(">")
+	}
+
+	srcParams := b.prepareParams(path, urlParams)
+	src := b.createPreparedURL(path, srcParams)
+	srcset := b.createSrcsetFromValues(path, cloneValues(urlParams), opts)
+
+	sb.WriteString("<img")
+	writeAttr(&sb, "src", src)
+	writeAttr(&sb, "srcset", srcset)
+	writeAttr(&sb, "alt", alt)
+	sb.WriteString(">")
+
+	sb.WriteString("</picture>")
+	if opts.checksumComment {
+		writeChecksumComment(&sb, path, srcParams)
+	}
+	return sb.String()
+}
+
+// LazyImage creates the markup of a lazy-loaded image for the path,
+// params, and options (see CreateSrcset): the attributes of the lazy
+// <img>, whose URLs are deferred to data-src and data-srcset for a
+// lazy-loading script to swap in, and a <noscript> fallback containing a
+// plain <img> for clients without JavaScript. For example:
+//
+//	lazy:     ` data-src="…" data-srcset="…" sizes="100vw"`
+//	noscript: `<noscript><img src="…" srcset="…" sizes="100vw"></noscript>`
+//
+// Both are built from the same URLs, so they can't drift apart. The
+// sizes attribute is omitted if sizes is empty. All URLs are signed if
+// the builder has a token, and all attribute values are HTML-escaped.
+func (b *URLBuilder) LazyImage(
+	path string,
+	params []IxParam,
+	sizes string,
+	options ...SrcsetOption) (lazy string, noscript string) {
+
+	urlParams := applyParams(params)
+
+	opts := newSrcsetOpts(options...)
+	if err := opts.validateFor(b.isDprBased(path, urlParams)); err != nil {
+		log.Fatalln(err)
+	}
+
+	b = b.checkOnce(path, urlParams)
+	srcParams := b.prepareParams(path, urlParams)
+	src := b.createPreparedURL(path, srcParams)
+	srcset := b.createSrcsetFromValues(path, cloneValues(urlParams), opts)
+
+	var sb strings.Builder
+	writeAttr(&sb, "data-src", src)
+	writeAttr(&sb, "data-srcset", srcset)
+	if sizes != "" {
+		writeAttr(&sb, "sizes", sizes)
+	}
+	lazy = sb.String()
+
+	sb.Reset()
+	sb.WriteString("<noscript><img")
+	writeAttr(&sb, "src", src)
+	writeAttr(&sb, "srcset", srcset)
+	if sizes != "" {
+		writeAttr(&sb, "sizes", sizes)
+	}
+	sb.WriteString("></noscript>")
+	if opts.checksumComment {
+		writeChecksumComment(&sb, path, srcParams)
+	}
+	noscript = sb.String()
+	return lazy, noscript
+}
+
+// ImgAttrs are the attributes of an <img> element. Its fields are tagged
+// so that it can be serialized to JSON, e.g. for a frontend that renders
+// the element without further SDK calls.
+type ImgAttrs struct {
+	Src    string `json:"src"`
+	Srcset string `json:"srcset"`
+	Sizes  string `json:"sizes,omitempty"`
+
+	// Width and Height are the image's dimensions in CSS pixels, which
+	// let the browser reserve space for the image before it loads,
+	// avoiding cumulative layout shift (CLS).
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+
+	// Checksum identifies the transform of the image's src, like the
+	// comment of the HTML helpers (see WithChecksumComment). It's only
+	// set if that option is.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// ImgAttributesWithSize creates the src and srcset attributes of an
+// <img> for the path, params, and options (see CreateSrcset), along
+// with its width and height, as predicted by OutputSize for an origin
+// image of originW by originH pixels. The dpr param is disregarded when
+// the size is predicted, since the attributes are in CSS pixels. An
+// error is returned if the size can't be predicted (e.g. for
+// fit=facearea). All URLs are signed if the builder has a token. The
+// params passed to this method are not modified.
+func (b *URLBuilder) ImgAttributesWithSize(
+	path string,
+	originW int,
+	originH int,
+	params url.Values,
+	options ...SrcsetOption) (ImgAttrs, error) {
+
+	opts := newSrcsetOpts(options...)
+	if err := opts.validateFor(b.isDprBased(path, params)); err != nil {
+		return ImgAttrs{}, err
+	}
+
+	sizeParams := b.prepareParams(path, params)
+	sizeParams.Del("dpr")
+	width, height, err := OutputSize(originW, originH, sizeParams)
+	if err != nil {
+		return ImgAttrs{}, err
+	}
+
+	b = b.checkOnce(path, params)
+	srcParams := b.prepareParams(path, params)
+	attrs := ImgAttrs{
+		Src:    b.createPreparedURL(path, srcParams),
+		Srcset: b.createSrcsetFromValues(path, cloneValues(params), opts),
+		Width:  width,
+		Height: height}
+	if opts.checksumComment {
+		attrs.Checksum = cacheKeyChecksum(path, srcParams)
+	}
+	return attrs, nil
+}
+
+// ManifestItem describes an image in a manifest (see BuildManifest).
+type ManifestItem struct {
+	Path    string         // The image's path, which keys its attributes.
+	Params  url.Values     // The params of the image's URLs.
+	Options []SrcsetOption // The options of the image's srcset.
+	Sizes   string         // The image's sizes attribute, if any.
+}
+
+// BuildManifest creates the attributes of each of the items' images,
+// keyed by path, e.g. for a static-site build that serializes them to
+// JSON for the frontend to consume at runtime. Each image's src is the
+// URL that CreateURLE creates, and its srcset is the one that
+// CreateSrcsetE creates. All URLs are signed if the builder has a token.
+//
+// An error is returned, naming the item's path, if a path is repeated or
+// if either CreateURLE or CreateSrcsetE would return an error for an
+// item. The items passed to this method are not modified.
+func (b *URLBuilder) BuildManifest(items []ManifestItem) (map[string]ImgAttrs, error) {
+	manifest := make(map[string]ImgAttrs, len(items))
+	for _, item := range items {
+		if _, ok := manifest[item.Path]; ok {
+			return nil, fmt.Errorf("manifest item `%s` is repeated", item.Path)
+		}
+
+		attrs, err := b.manifestAttrs(item)
+		if err != nil {
+			return nil, fmt.Errorf("manifest item `%s`: %w", item.Path, err)
+		}
+		manifest[item.Path] = attrs
+	}
+	return manifest, nil
+}
+
+// manifestAttrs creates the attributes of the item's image (see
+// BuildManifest).
+func (b *URLBuilder) manifestAttrs(item ManifestItem) (ImgAttrs, error) {
+	opts := newSrcsetOpts(item.Options...)
+	if err := opts.validateFor(b.isDprBased(item.Path, item.Params)); err != nil {
+		return ImgAttrs{}, err
+	}
+
+	srcParams := b.prepareParams(item.Path, item.Params)
+	src, err := b.createPreparedURLE(item.Path, srcParams)
+	if err != nil {
+		return ImgAttrs{}, err
+	}
+
+	// The params were checked when the src was created.
+	attrs := ImgAttrs{
+		Src:    src,
+		Srcset: b.unchecked().createSrcsetFromValues(item.Path, cloneValues(item.Params), opts),
+		Sizes:  item.Sizes}
+	if opts.checksumComment {
+		attrs.Checksum = cacheKeyChecksum(item.Path, srcParams)
+	}
+	return attrs, nil
+}
+
+// PreloadHeader creates the value of a Link header that preloads an
+// image, e.g. the page's largest contentful paint (LCP) image, of the
+// form `<src>; rel=preload; as=image; imagesrcset="…"; imagesizes="…"`.
+// The srcset is the one that CreateSrcset creates for the path, params,
+// and options, except that its candidates are always separated by ", "
+// since header values cannot contain newlines. The sizes should match
+// the sizes attribute of the image (e.g. one created by GridSizes); if
+// it's empty, imagesizes is omitted. All URLs are signed if the builder
+// has a token. The params passed to this method are not modified.
+func (b *URLBuilder) PreloadHeader(
+	path string,
+	params url.Values,
+	sizes string,
+	options ...SrcsetOption) string {
+
+	opts := newSrcsetOpts(options...)
+	if err := opts.validateFor(b.isDprBased(path, params)); err != nil {
+		log.Fatalln(err)
+	}
+	opts.separator = ", "
+
+	b = b.checkOnce(path, params)
+	src := b.createURLFromValues(path, cloneValues(params))
+	srcset := b.createSrcsetFromValues(path, cloneValues(params), opts)
+
+	var sb strings.Builder
+	sb.WriteString("<" + src + ">; rel=preload; as=image")
+	writeHeaderParam(&sb, "imagesrcset", srcset)
+	if sizes != "" {
+		writeHeaderParam(&sb, "imagesizes", sizes)
+	}
+	return sb.String()
+}
+
+// writeHeaderParam writes a header param, of the form `; name="value"`,
+// with the value escaped as an HTTP quoted-string.
+func writeHeaderParam(sb *strings.Builder, name string, value string) {
+	sb.WriteString("; ")
+	sb.WriteString(name)
+	sb.WriteString(`="`)
+	sb.WriteString(headerValueEscaper.Replace(value))
+	sb.WriteString(`"`)
+}
+
+// headerValueEscaper escapes the characters that cannot appear unescaped
+// within an HTTP quoted-string.
+var headerValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// writeChecksumComment writes an HTML comment that identifies the
+// transform of the image rendered by path and params (see
+// WithChecksumComment). The params must be those that the image's src
+// was built from, after the builder's param transformations were applied
+// (see prepareParams), so that the checksum matches the src exactly,
+// even if it has a fresh cache-buster (see WithCacheBust).
+func writeChecksumComment(sb *strings.Builder, path string, params url.Values) {
+	sb.WriteString("<!-- imgix:")
+	sb.WriteString(cacheKeyChecksum(path, params))
+	sb.WriteString(" -->")
+}
+
+// writeAttr writes an HTML attribute, of the form ` name="value"`, with
+// the value HTML-escaped.
+func writeAttr(sb *strings.Builder, name string, value string) {
+	sb.WriteString(" ")
+	sb.WriteString(name)
+	sb.WriteString(`="`)
+	sb.WriteString(html.EscapeString(value))
+	sb.WriteString(`"`)
+}
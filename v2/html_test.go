@@ -0,0 +1,296 @@
+package imgix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTML_PictureModernFormats(t *testing.T) {
+	c := testClient()
+	actual := c.PictureModernFormats(
+		"image.png",
+		[]IxParam{Param("fit", "crop")},
+		"A <b>bold</b> cat",
+		WithMinWidth(100),
+		WithMaxWidth(116))
+
+	expected := "<picture>" +
+		`<source type="image/avif" srcset="` +
+		"https://test.imgix.net/image.png?fit=crop&amp;fm=avif&amp;w=100 100w,\n" +
+		`https://test.imgix.net/image.png?fit=crop&amp;fm=avif&amp;w=116 116w">` +
+		`<source type="image/webp" srcset="` +
+		"https://test.imgix.net/image.png?fit=crop&amp;fm=webp&amp;w=100 100w,\n" +
+		`https://test.imgix.net/image.png?fit=crop&amp;fm=webp&amp;w=116 116w">` +
+		`<img src="https://test.imgix.net/image.png?fit=crop" srcset="` +
+		"https://test.imgix.net/image.png?fit=crop&amp;w=100 100w,\n" +
+		`https://test.imgix.net/image.png?fit=crop&amp;w=116 116w" alt="A &lt;b&gt;bold&lt;/b&gt; cat">` +
+		"</picture>"
+	assert.Equal(t, expected, actual)
+}
+
+func TestHTML_PictureModernFormatsSigned(t *testing.T) {
+	c := NewURLBuilder("my-social-network.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	actual := c.PictureModernFormats("image.png", []IxParam{Param("w", "320")}, "")
+
+	// Fixed-width sources use dpr-based srcsets and every URL is signed.
+	assert.Contains(t, actual, "fm=avif&amp;q=75&amp;w=320&amp;s=")
+	assert.Contains(t, actual, "fm=webp&amp;q=20&amp;w=320&amp;s=")
+	assert.Contains(t, actual, `<img src="https://my-social-network.imgix.net/image.png?w=320&amp;s=`)
+	assert.NotContains(t, actual, "&w=")
+}
+
+func TestHTML_PictureModernFormatsDecorative(t *testing.T) {
+	c := testClient()
+	actual := c.PictureModernFormats("image.png", nil, "", WithWidths(100))
+	assert.Contains(t, actual, `srcset="https://test.imgix.net/image.png?w=100 100w" alt="">`)
+}
+
+func TestHTML_PictureModernFormatsValidatesUsedOptions(t *testing.T) {
+	// A dpr-based picture ignores the width options, as CreateSrcset does.
+	c := testClient()
+	actual := c.PictureModernFormats("image.png", []IxParam{Param("w", "100")}, "", WithMinWidth(-1))
+	assert.Contains(t, actual, "dpr=5")
+}
+
+func TestHTML_ChecksumComment(t *testing.T) {
+	sum := sha256.Sum256([]byte("/image.png?auto=compress%2Cformat&fit=crop"))
+	comment := "<!-- imgix:" + hex.EncodeToString(sum[:])[:8] + " -->"
+
+	// The checksum is of the transform after the builder's default
+	// params have been applied, regardless of their order.
+	c := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"),
+		WithDefaultParams(url.Values{"auto": {"format", "compress"}}))
+	params := []IxParam{Param("fit", "crop")}
+
+	picture := c.PictureModernFormats("image.png", params, "", WithChecksumComment(true))
+	assert.True(t, strings.HasSuffix(picture, "</picture>"+comment), picture)
+
+	_, noscript := c.LazyImage("image.png", params, "", WithChecksumComment(true))
+	assert.True(t, strings.HasSuffix(noscript, "</noscript>"+comment), noscript)
+
+	// The comment is off by default.
+	assert.NotContains(t, c.PictureModernFormats("image.png", params, ""), "<!--")
+	_, noscript = c.LazyImage("image.png", params, "")
+	assert.NotContains(t, noscript, "<!--")
+
+	// Different transforms have different checksums.
+	other := c.PictureModernFormats("image.png", []IxParam{Param("fit", "max")}, "", WithChecksumComment(true))
+	assert.NotContains(t, other, comment)
+}
+
+func TestHTML_PreloadHeader(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	actual := u.PreloadHeader(
+		"image.png",
+		url.Values{"fit": {"crop"}},
+		"(min-width: 800px) 50vw, 100vw",
+		WithMinWidth(100),
+		WithMaxWidth(116))
+
+	sign := func(query string) string {
+		return query + "&s=" + createMd5Signature("FOO123bar", "/image.png", query)
+	}
+	expected := "<https://test.imgix.net/image.png?" + sign("fit=crop") + ">; rel=preload; as=image; " +
+		`imagesrcset="https://test.imgix.net/image.png?` + sign("fit=crop&w=100") + " 100w, " +
+		"https://test.imgix.net/image.png?" + sign("fit=crop&w=116") + ` 116w"; ` +
+		`imagesizes="(min-width: 800px) 50vw, 100vw"`
+	assert.Equal(t, expected, actual)
+	assert.NotContains(t, actual, "\n")
+}
+
+func TestHTML_PreloadHeaderEscaping(t *testing.T) {
+	c := testClient()
+	actual := c.PreloadHeader("image.png", nil, `100vw"; rel=x \`, WithWidths(100))
+	assert.Equal(t, "<https://test.imgix.net/image.png>; rel=preload; as=image; "+
+		`imagesrcset="https://test.imgix.net/image.png?w=100 100w"; `+
+		`imagesizes="100vw\"; rel=x \\"`, actual)
+
+	// Without sizes, imagesizes is omitted.
+	assert.NotContains(t, c.PreloadHeader("image.png", nil, "", WithWidths(100)), "imagesizes")
+}
+
+func TestHTML_ImgAttributesWithSize(t *testing.T) {
+	c := testClient()
+
+	tests := []struct {
+		params url.Values
+		width  int
+		height int
+	}{
+		// clip (the default) fits the image within w and h.
+		{url.Values{"w": {"400"}, "h": {"400"}}, 400, 300},
+		{url.Values{"w": {"400"}, "h": {"400"}, "fit": {"clip"}}, 400, 300},
+		{url.Values{"h": {"600"}}, 800, 600},
+		// crop fills w and h exactly, or derives one from ar.
+		{url.Values{"w": {"400"}, "h": {"400"}, "fit": {"crop"}}, 400, 400},
+		{url.Values{"w": {"400"}, "ar": {"16:9"}, "fit": {"crop"}}, 400, 225},
+		// The dpr doesn't affect the CSS-pixel dimensions.
+		{url.Values{"w": {"400"}, "dpr": {"2"}}, 400, 300},
+		// Without sizing params, the origin dimensions are used.
+		{url.Values{}, 1600, 1200},
+	}
+
+	for _, test := range tests {
+		attrs, err := c.ImgAttributesWithSize("image.png", 1600, 1200, test.params, WithWidths(100, 200))
+		assert.NoError(t, err)
+		assert.Equal(t, test.width, attrs.Width)
+		assert.Equal(t, test.height, attrs.Height)
+		assert.Equal(t, c.createURLFromValues("image.png", test.params), attrs.Src)
+		assert.NotEmpty(t, attrs.Srcset)
+	}
+}
+
+func TestHTML_ImgAttributesWithSizeSrcset(t *testing.T) {
+	c := testClient()
+	params := url.Values{"fit": {"crop"}, "ar": {"1:1"}}
+
+	attrs, err := c.ImgAttributesWithSize("image.png", 1600, 1200, params, WithWidths(100, 200))
+	assert.NoError(t, err)
+	assert.Equal(t, ImgAttrs{
+		Src: "https://test.imgix.net/image.png?ar=1%3A1&fit=crop",
+		Srcset: "https://test.imgix.net/image.png?ar=1%3A1&fit=crop&w=100 100w,\n" +
+			"https://test.imgix.net/image.png?ar=1%3A1&fit=crop&w=200 200w",
+		Width:  1200,
+		Height: 1200}, attrs)
+
+	// The params passed to the method are not modified.
+	assert.Equal(t, url.Values{"fit": {"crop"}, "ar": {"1:1"}}, params)
+}
+
+func TestHTML_ImgAttributesWithSizeError(t *testing.T) {
+	c := testClient()
+	_, err := c.ImgAttributesWithSize("image.png", 1600, 1200, url.Values{"w": {"400"}, "fit": {"facearea"}})
+	assert.EqualError(t, err, "output size cannot be computed for `fit=facearea`")
+
+	_, err = c.ImgAttributesWithSize("image.png", 0, 0, url.Values{})
+	assert.Error(t, err)
+}
+
+func TestHTML_LazyImage(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	lazy, noscript := u.LazyImage("image.png", []IxParam{Param("fit", "crop")}, "(min-width: 800px) 50vw, 100vw",
+		WithWidths(100, 200))
+
+	sign := func(query string) string {
+		return query + "&amp;s=" + createMd5Signature("FOO123bar", "/image.png", strings.ReplaceAll(query, "&amp;", "&"))
+	}
+	src := "https://test.imgix.net/image.png?" + sign("fit=crop")
+	srcset := "https://test.imgix.net/image.png?" + sign("fit=crop&amp;w=100") + " 100w,\n" +
+		"https://test.imgix.net/image.png?" + sign("fit=crop&amp;w=200") + " 200w"
+	sizes := "(min-width: 800px) 50vw, 100vw"
+
+	assert.Equal(t, ` data-src="`+src+`" data-srcset="`+srcset+`" sizes="`+sizes+`"`, lazy)
+	assert.Equal(t, `<noscript><img src="`+src+`" srcset="`+srcset+`" sizes="`+sizes+`"></noscript>`, noscript)
+}
+
+func TestHTML_LazyImageWithoutSizes(t *testing.T) {
+	c := testClient()
+	lazy, noscript := c.LazyImage("image.png", nil, "", WithWidths(100))
+	assert.Equal(t, ` data-src="https://test.imgix.net/image.png" `+
+		`data-srcset="https://test.imgix.net/image.png?w=100 100w"`, lazy)
+	assert.Equal(t, `<noscript><img src="https://test.imgix.net/image.png" `+
+		`srcset="https://test.imgix.net/image.png?w=100 100w"></noscript>`, noscript)
+}
+
+func TestHTML_BuildManifest(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	manifest, err := u.BuildManifest([]ManifestItem{
+		{
+			Path:    "hero.jpg",
+			Params:  url.Values{"fit": {"crop"}, "ar": {"16:9"}},
+			Options: []SrcsetOption{WithWidths(400, 800), WithSeparator(", ")},
+			Sizes:   "100vw",
+		},
+		{
+			Path:    "avatar.png",
+			Params:  url.Values{"w": {"64"}},
+			Options: []SrcsetOption{WithVariableQuality(false)},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(manifest))
+
+	signed := func(path string, query string) string {
+		return "https://test.imgix.net" + path + "?" + query + "&s=" + createMd5Signature("FOO123bar", path, query)
+	}
+	assert.Equal(t, ImgAttrs{
+		Src: signed("/hero.jpg", "ar=16%3A9&fit=crop"),
+		Srcset: signed("/hero.jpg", "ar=16%3A9&fit=crop&w=400") + " 400w, " +
+			signed("/hero.jpg", "ar=16%3A9&fit=crop&w=800") + " 800w",
+		Sizes: "100vw"}, manifest["hero.jpg"])
+
+	avatar := manifest["avatar.png"]
+	assert.Equal(t, signed("/avatar.png", "w=64"), avatar.Src)
+	assert.Equal(t, 5, len(strings.Split(avatar.Srcset, ",\n")))
+	assert.Equal(t, "", avatar.Sizes)
+
+	// The manifest serializes to JSON for the frontend.
+	encoded, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(encoded), `"avatar.png":{"src":"`)
+	assert.Contains(t, string(encoded), `"sizes":"100vw"`)
+}
+
+func TestHTML_BuildManifestErrors(t *testing.T) {
+	c := testClient()
+
+	_, err := c.BuildManifest([]ManifestItem{{Path: "a.png"}, {Path: "a.png"}})
+	assert.EqualError(t, err, "manifest item `a.png` is repeated")
+
+	_, err = c.BuildManifest([]ManifestItem{{Path: "a.png", Options: []SrcsetOption{WithMaxCandidates(-1)}}})
+	assert.EqualError(t, err, "manifest item `a.png`: `maxCandidates` must be greater than, or equal to, zero")
+
+	short := NewURLBuilder("test.imgix.net", WithMaxURLLength(20))
+	_, err = short.BuildManifest([]ManifestItem{{Path: "a-long-path-to-an-image.png"}})
+	assert.True(t, errors.Is(err, ErrURLTooLong))
+}
+
+func TestHTML_ChecksumCommentCacheBust(t *testing.T) {
+	n := 0
+	c := NewURLBuilder("test.imgix.net", WithLibParam(false), WithCacheBust(true),
+		WithCacheBustSource(func() string {
+			n++
+			return strconv.Itoa(n)
+		}))
+	params := []IxParam{Param("fit", "crop")}
+
+	// The checksum is of the src as emitted, including its cache-buster.
+	lazy, noscript := c.LazyImage("image.png", params, "", WithWidths(100), WithChecksumComment(true))
+	src := html.UnescapeString(strings.Split(lazy, `"`)[1])
+	parsed, err := ParseURL(src)
+	assert.NoError(t, err)
+	comment := "<!-- imgix:" + cacheKeyChecksum("image.png", parsed.Params) + " -->"
+	assert.True(t, strings.HasSuffix(noscript, comment), noscript)
+}
+
+func TestHTML_ChecksumAttrs(t *testing.T) {
+	c := NewURLBuilder("test.imgix.net", WithDefaultParams(url.Values{"auto": {"format"}}))
+	params := url.Values{"w": {"400"}}
+	expected := cacheKeyChecksum("image.png", url.Values{"auto": {"format"}, "w": {"400"}})
+
+	attrs, err := c.ImgAttributesWithSize("image.png", 800, 600, params, WithChecksumComment(true))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, attrs.Checksum)
+
+	manifest, err := c.BuildManifest([]ManifestItem{
+		{Path: "image.png", Params: params, Options: []SrcsetOption{WithChecksumComment(true)}},
+		{Path: "other.png", Params: params},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, manifest["image.png"].Checksum)
+
+	// The checksum is off by default.
+	assert.Equal(t, "", manifest["other.png"].Checksum)
+	data, err := json.Marshal(manifest["other.png"])
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "checksum")
+}
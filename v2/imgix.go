@@ -1,21 +1,110 @@
 package imgix
 
 import (
+	"fmt"
 	"log"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
-const ixLibVersion = "go-v2.0.2"
+// LibVersion is the default value of the ixlib param, which identifies
+// this library (and its version) to imgix for analytics. It changes with
+// each release, so tests that compare full URLs should either disable
+// the param (see WithLibParam) or pin its value (see
+// WithLibraryParamValue).
+var LibVersion = "go-v2.0.2"
+
+// DefaultMaxURLLength is the default maximum length, in characters, of
+// a URL returned by CreateURLE. Long proxy sources (e.g. signed S3 URLs)
+// can push the final, percent-encoded URL past the limits imposed by
+// imgix and by most CDNs and browsers.
+const DefaultMaxURLLength = 8192
 
 // URLBuilder facilitates the building of imgix URLs.
 type URLBuilder struct {
 	domain      string // A source's domain, e.g. example.imgix.net
 	token       string // A source's secure token used to sign/secure URLs.
 	useHTTPS    bool   // Denotes whether or not to use HTTPS.
-	useLibParam bool   // Denotes whether or not to apply the ixLibVersion.
+	useLibParam bool   // Denotes whether or not to apply the ixlib param.
+
+	maxURLLength int                    // The maximum URL length; zero disables the check.
+	signWhen     func(path string) bool // Decides, per path, whether to sign.
+
+	trailingParams    url.Values // Params appended after the signature.
+	aggressiveEscapes string     // Otherwise-safe path characters to escape.
+	strictEncoding    bool       // Denotes whether to encode paths per RFC 3986.
+	secureProxyOnly   bool       // Denotes whether to reject http proxy sources.
+	basePath          string     // The service's base path, e.g. /imgix.
+	displayHost       string     // The emitted host, if not the domain.
+	displayPrefix     string     // An unsigned prefix of the emitted path.
+	libParamValue     string     // The ixlib value; empty uses LibVersion.
+	signatureCase     HexCase    // The case of the signature's hex digits.
+	signatureParam    string     // The signature's param name; empty uses s.
+	pathOnly          bool       // Denotes whether to omit the scheme and domain.
+	protocolRelative  bool       // Denotes whether to omit the scheme.
+	collapseSlashes   bool       // Denotes whether to collapse runs of slashes.
+	defaultFit        FitMode    // The fit used when both w and h are set.
+	extensionFormat   bool       // Denotes whether to set fm from the extension.
+	literalPaths      bool       // Denotes whether paths are never pre-encoded.
+	validateOutput    bool       // Denotes whether to re-parse checked URLs.
+	preEscapedPaths   bool       // Denotes whether paths are always pre-encoded.
+
+	maxWidth  int // The maximum explicit w; zero is unbounded.
+	maxHeight int // The maximum explicit h; zero is unbounded.
+
+	defaultParams url.Values // Params applied to every URL.
+	dedupeParams  bool       // Denotes whether to drop duplicate values.
+	cacheBust     bool       // Denotes whether to set a fresh v on every URL.
+	maxDPR        float64    // The maximum dpr; zero is unbounded.
+
+	formatQualities map[ImageFormat]int // Default qualities, by format.
+	defaultQuality  int                 // Default quality for other formats.
+
+	paramAliases   map[string]string // Param names to rewrite, e.g. width to w.
+	memberOrder    MemberOrder       // The order of the members AutoFormatSrcset adds to.
+	emptyParams    EmptyParamMode    // How to emit params with empty values.
+	paramOrder     []string          // Keys emitted first, in this order.
+	validationMode ValidationMode    // How to respond to rule violations.
+	paramsChecked  bool              // Denotes whether params were already checked (see checkOnce).
+	paramRules     []ParamRule       // Rules checked in addition to ConflictRules.
+
+	paramRewriter func(path string, params url.Values) url.Values // The last param transformation.
+
+	nowFunc         func() time.Time // The clock of expiring URLs; nil uses time.Now.
+	cacheBustSource func() string    // The source of cache-busters; nil uses cacheBuster.
+	pathTemplate    string           // The pattern filled by CreateURLFromFields.
 }
 
+// EmptyParamMode determines how params with empty values (e.g.
+// url.Values{"flag": {""}}) are emitted.
+type EmptyParamMode int
+
+const (
+	// EmitEmptyParams emits empty params as `key=`. This is the default.
+	EmitEmptyParams EmptyParamMode = iota
+
+	// DropEmptyParams drops empty params from the URL entirely.
+	DropEmptyParams
+
+	// BareEmptyParams emits empty params as a bare `key`.
+	BareEmptyParams
+)
+
+// HexCase determines the case of the hex digits in a URL's signature.
+type HexCase int
+
+const (
+	// HexLower emits lowercase hex digits, as imgix does. This is the
+	// default.
+	HexLower HexCase = iota
+
+	// HexUpper emits uppercase hex digits. imgix does NOT accept these
+	// signatures; see WithSignatureHexCase.
+	HexUpper
+)
+
 // BuilderOption provides a convenient interface for supplying URLBuilder
 // options to the NewURLBuilder constructor. See WithToken, WithHTTPS, etc.
 // for more details.
@@ -28,7 +117,11 @@ func NewURLBuilder(domain string, options ...BuilderOption) URLBuilder {
 		log.Fatal(err)
 	}
 
-	urlBuilder := URLBuilder{domain: validDomain, useHTTPS: true, useLibParam: true}
+	urlBuilder := URLBuilder{
+		domain:       validDomain,
+		useHTTPS:     true,
+		useLibParam:  true,
+		maxURLLength: DefaultMaxURLLength}
 
 	for _, fn := range options {
 		fn(&urlBuilder)
@@ -54,6 +147,20 @@ func WithHTTPS(useHTTPS bool) BuilderOption {
 	}
 }
 
+// WithProtocolRelative returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to omit the scheme from
+// the URLs that the builder creates, e.g. //test.imgix.net/image.png, so
+// that browsers request them with the scheme of the page. This applies to
+// srcset candidates as well. The signature doesn't cover the scheme, so
+// it is unaffected. Prefer HTTPS URLs where possible; protocol-relative
+// URLs are meant for legacy pages that are served over both HTTP and
+// HTTPS.
+func WithProtocolRelative() BuilderOption {
+	return func(b *URLBuilder) {
+		b.protocolRelative = true
+	}
+}
+
 // WithLibParam returns a BuilderOption that NewURLBuilder consumes.
 // The constructor uses this closure to set the URLBuilder's useLibParam
 // attribute.
@@ -63,6 +170,493 @@ func WithLibParam(useLibParam bool) BuilderOption {
 	}
 }
 
+// WithLibraryParamValue returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to set the value of the
+// ixlib param, which otherwise defaults to LibVersion. Pinning the value
+// keeps golden URLs (and their signatures) stable across releases of
+// this library. The option has no effect when the param is disabled (see
+// WithLibParam).
+func WithLibraryParamValue(value string) BuilderOption {
+	return func(b *URLBuilder) {
+		b.libParamValue = value
+	}
+}
+
+// WithSignatureHexCase returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to set the case of the hex
+// digits in URL signatures (the s param).
+//
+// WARNING: imgix compares signatures case-sensitively and expects
+// lowercase hex, so URLs signed with HexUpper will NOT validate against
+// imgix. HexUpper exists only for interop with a custom verifier that
+// expects uppercase hex. Leave the default (HexLower) for imgix URLs.
+func WithSignatureHexCase(hexCase HexCase) BuilderOption {
+	return func(b *URLBuilder) {
+		b.signatureCase = hexCase
+	}
+}
+
+// WithSignatureParamName returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to set the name of the
+// param that carries the URL signature, which is s by default. The
+// signature itself is unchanged.
+//
+// WARNING: imgix only reads the signature from the s param, so URLs
+// whose signature is in a param of any other name will NOT validate
+// against imgix. Other names exist only for custom edges (e.g. one that
+// expects sig) during a migration. Leave the default for imgix URLs.
+func WithSignatureParamName(name string) BuilderOption {
+	return func(b *URLBuilder) {
+		b.signatureParam = name
+	}
+}
+
+// WithSignWhen returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set a predicate that decides, for
+// each path, whether URLs should be signed. The predicate receives the
+// path exactly as it was passed to the builder (e.g. to CreateURL). When
+// it returns false, no signature is appended even if a token is set.
+// By default, every path is signed when a token is present.
+func WithSignWhen(signWhen func(path string) bool) BuilderOption {
+	return func(b *URLBuilder) {
+		b.signWhen = signWhen
+	}
+}
+
+// WithDefaultParams returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set params that are applied to
+// every URL the builder creates. A param passed to the builder (e.g. to
+// CreateURL) replaces the default param of the same name, except for
+// set-params (e.g. auto), whose members are appended to the default's.
+func WithDefaultParams(params url.Values) BuilderOption {
+	return func(b *URLBuilder) {
+		b.defaultParams = cloneValues(params)
+	}
+}
+
+// WithDedupeParams returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to drop duplicate param values
+// (and duplicate set-param members) before URLs are built and signed.
+// For instance, a call that passes auto=format,compress to a builder
+// whose default params already contain auto=format,compress emits
+// auto=format,compress rather than auto=format,compress,format,compress.
+// This is opt-in, since callers may repeat a value intentionally.
+func WithDedupeParams() BuilderOption {
+	return func(b *URLBuilder) {
+		b.dedupeParams = true
+	}
+}
+
+// WithAutoFormatMemberOrder returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to set the order of the
+// members of the auto param of AutoFormatSrcset, which are sorted by
+// default. Choose InsertionOrderMembers for byte-parity with the URLs of
+// imgix-core-js, e.g. auto=enhance,compress,format rather than
+// auto=compress,enhance,format.
+//
+// The order applies to AutoFormatSrcset only: de-duplication (see
+// WithDedupeParams) and MergeParams always keep members in order of
+// first appearance, and CacheKey always sorts them (see
+// CacheKeyWithMemberOrder to choose).
+func WithAutoFormatMemberOrder(order MemberOrder) BuilderOption {
+	return func(b *URLBuilder) {
+		b.memberOrder = order
+	}
+}
+
+// cacheBuster returns a fresh, time-based cache-buster for
+// WithCacheBust.
+func cacheBuster() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// WithCacheBust returns a BuilderOption that NewURLBuilder consumes. The
+// constructor uses this closure to enable (or disable) development cache
+// busting: when enabled, every URL gets a fresh, time-based cache-buster
+// (v), replacing any v in the params, so that imgix renders the image
+// anew rather than serving a cached rendition, e.g. while a transform is
+// being debugged. Like any other param, the v is signed. It's disabled by
+// default.
+//
+// WARNING: this is a development tool only. Since no two URLs are the
+// same, nothing is ever served from a cache, which defeats imgix's (and
+// any CDN's) caching entirely. Use Bust or Version to invalidate
+// renditions in production.
+func WithCacheBust(enabled bool) BuilderOption {
+	return func(b *URLBuilder) {
+		b.cacheBust = enabled
+	}
+}
+
+// WithCacheBustSource returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to set the function that
+// returns the cache-busters of WithCacheBust, e.g. to render golden URLs
+// deterministically or to bust once per deploy rather than per URL. The
+// option has no effect unless cache busting is enabled.
+func WithCacheBustSource(source func() string) BuilderOption {
+	return func(b *URLBuilder) {
+		b.cacheBustSource = source
+	}
+}
+
+// WithParamRewriter returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to set a hook that
+// rewrites the params of every URL the builder creates, e.g. to force
+// auto=format org-wide or to strip disallowed params. The rewriter is
+// the builder's last param transformation (see prepareParams): it
+// receives the params after all of the others, such as the default
+// params, have been applied, and the params it returns are exactly
+// those that are emitted, validated, and signed. Only the ixlib param
+// (see WithLibParam) is added afterwards.
+//
+// The params passed to the rewriter are the builder's own copy, never
+// those of the caller, so the rewriter may either modify and return
+// them or return new params; either way, the builder copies the params
+// it returns. If it returns nil, the params it was passed are used.
+func WithParamRewriter(rewriter func(path string, params url.Values) url.Values) BuilderOption {
+	return func(b *URLBuilder) {
+		b.paramRewriter = rewriter
+	}
+}
+
+// WithMaxDPR returns a BuilderOption that NewURLBuilder consumes. The
+// constructor uses this closure to cap the device pixel ratio of every
+// URL the builder creates, e.g. to control origin egress. A dpr param
+// above maxDPR is clamped to it (dpr=5 becomes dpr=3 for a maxDPR of 3)
+// and dpr-based srcsets stop at the cap. A maxDPR of zero is unbounded.
+func WithMaxDPR(maxDPR float64) BuilderOption {
+	return func(b *URLBuilder) {
+		b.maxDPR = maxDPR
+	}
+}
+
+// WithEmptyParams returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set how params with empty values
+// are emitted (see EmptyParamMode). The signature covers the params
+// exactly as they are emitted.
+func WithEmptyParams(mode EmptyParamMode) BuilderOption {
+	return func(b *URLBuilder) {
+		b.emptyParams = mode
+	}
+}
+
+// WithFormatQuality returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set the default quality (q) for
+// each output format, e.g. q=45 for WebP and AVIF but q=75 for JPG. The
+// output format is the fm param or, if it isn't set, is inferred from
+// the path's extension. An explicit q param is never overridden.
+func WithFormatQuality(qualities map[ImageFormat]int) BuilderOption {
+	return func(b *URLBuilder) {
+		b.formatQualities = make(map[ImageFormat]int, len(qualities))
+		for format, q := range qualities {
+			b.formatQualities[format] = q
+		}
+	}
+}
+
+// WithDefaultQuality returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set the default quality (q) used
+// when the output format is unknown or has no quality of its own (see
+// WithFormatQuality). An explicit q param is never overridden.
+func WithDefaultQuality(q int) BuilderOption {
+	return func(b *URLBuilder) {
+		b.defaultQuality = q
+	}
+}
+
+// WithParamOrder returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set an explicit order for the
+// query string's params, e.g. for a legacy cache that keys on the raw
+// query. The params whose keys appear in order are emitted first, in
+// that order, followed by the rest, sorted by key. The signature is
+// computed over the query exactly as it is emitted.
+//
+// Since Go's map iteration order is random, url.Values cannot carry an
+// order of their own; an explicit key order is the only way to emit an
+// unsorted query deterministically.
+func WithParamOrder(order ...string) BuilderOption {
+	return func(b *URLBuilder) {
+		b.paramOrder = append([]string(nil), order...)
+	}
+}
+
+// WithLogicalParamOrder returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to emit the query's params
+// grouped by category (see LogicalParamOrder) rather than sorted by key,
+// e.g. w=400&h=300&fit=crop&crop=faces&sat=-20&auto=format, which reads
+// like the transform pipeline in debug output. Params that aren't in
+// LogicalParamOrder are emitted last, sorted by key.
+//
+// Like WithParamOrder, which it replaces, this only changes the order
+// of the query: the rendered image is the same. The signature is
+// computed over the query exactly as it is emitted, since that is the
+// query that imgix verifies.
+func WithLogicalParamOrder() BuilderOption {
+	return func(b *URLBuilder) {
+		b.paramOrder = logicalParamKeys()
+	}
+}
+
+// WithCanonicalParamNames returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to rewrite the long-form
+// param names in ParamAliases (e.g. width) to their canonical short
+// forms (e.g. w) before URLs are built and signed. If a param is given
+// by both names (e.g. width and w), the canonical one wins. ParamAliases
+// is copied, so later changes to it don't affect the builder.
+func WithCanonicalParamNames() BuilderOption {
+	return func(b *URLBuilder) {
+		b.paramAliases = copyAliases(ParamAliases)
+	}
+}
+
+// WithLongParamNames returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to rewrite the short-form param
+// names in ParamAliases (e.g. w) to their long forms (e.g. width) before
+// URLs are built and signed. This is the inverse of
+// WithCanonicalParamNames.
+func WithLongParamNames() BuilderOption {
+	return func(b *URLBuilder) {
+		b.paramAliases = invertAliases(ParamAliases)
+	}
+}
+
+// WithUnsignedTrailingParams returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to set params that are
+// appended to every URL after the signature (s) and that are excluded
+// from the signature base.
+//
+// These params are invisible to imgix's signature check. They are meant
+// for CDNs (or other layers) in front of imgix that consume their own
+// params, e.g. a cache-buster, and must not be used for imgix params.
+func WithUnsignedTrailingParams(params url.Values) BuilderOption {
+	return func(b *URLBuilder) {
+		b.trailingParams = cloneValues(params)
+	}
+}
+
+// DefaultAggressiveEscapes are the characters that url.PathEscape leaves
+// unescaped in path components, but that WithAggressivePathEscaping
+// percent-encodes.
+const DefaultAggressiveEscapes = "$&:=@~"
+
+// WithAggressivePathEscaping returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to percent-encode the
+// DefaultAggressiveEscapes (and any extra characters) wherever they
+// appear in paths. These characters are valid in URL paths, but some
+// strict proxies and WAFs reject them. The signature is computed over
+// the escaped path.
+//
+// For example, WithAggressivePathEscaping('!') encodes '!' in
+// addition to the defaults. Extra characters must be ASCII and mustn't
+// be '%'; any other extra character is fatal.
+func WithAggressivePathEscaping(extra ...rune) BuilderOption {
+	return func(b *URLBuilder) {
+		if err := validateAggressiveEscapes(extra); err != nil {
+			log.Fatalln(err)
+		}
+		b.aggressiveEscapes = DefaultAggressiveEscapes + string(extra)
+	}
+}
+
+// WithStrictPathEncoding returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to encode paths the way
+// imgix-core-js does, with encodeURIComponent applied to each path
+// component (or to the whole source of a proxy path). Only ASCII
+// letters, digits, and "-_.!~*'()" are left unescaped.
+//
+// By default, paths are encoded with url.PathEscape, which leaves
+// "$&+:=@" unescaped but escapes "!*'()". Since the signature is
+// computed over the encoded path, use this option when URLs must match
+// (and be signed identically to) those built by other imgix SDKs.
+func WithStrictPathEncoding() BuilderOption {
+	return func(b *URLBuilder) {
+		b.strictEncoding = true
+	}
+}
+
+// WithRequireSecureProxySource returns a BuilderOption that
+// NewURLBuilder consumes. The constructor uses this closure to make
+// CreateURLE return an error when the source of a web proxy path uses
+// plaintext HTTP (e.g. "http://example.com/image.png" or its
+// percent-encoded form). CreateURL is unaffected.
+func WithRequireSecureProxySource() BuilderOption {
+	return func(b *URLBuilder) {
+		b.secureProxyOnly = true
+	}
+}
+
+// WithBasePath returns a BuilderOption that NewURLBuilder consumes. The
+// constructor uses this closure to set the base path at which the
+// rendering service is mounted, e.g. "/imgix" for a self-hosted,
+// imgix-compatible renderer served from "localhost:8080/imgix/". The
+// base path is prepended to every (encoded) image path, and the
+// signature is computed over the full path.
+//
+// The imgix service itself is always mounted at the domain's root, so
+// the base path is only needed for other renderers. It's distinct from
+// a source's path prefix, which imgix applies to the image path on the
+// origin side and which never appears in the URL.
+func WithBasePath(basePath string) BuilderOption {
+	return func(b *URLBuilder) {
+		basePath = strings.Trim(basePath, "/")
+		if basePath == "" {
+			b.basePath = ""
+			return
+		}
+		b.basePath = encodePath(basePath)
+	}
+}
+
+// WithDisplayHost returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set the host that URLs point at,
+// e.g. that of a reverse proxy which forwards requests to the imgix
+// domain. The host is validated like the domain, but, unlike the domain,
+// it isn't part of what imgix sees, so it never affects the signature.
+// See WithSignedPathPrefix.
+func WithDisplayHost(host string) BuilderOption {
+	return func(b *URLBuilder) {
+		validHost, err := validateDomain(host)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		b.displayHost = validHost
+	}
+}
+
+// WithSignedPathPrefix returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to set a prefix that is
+// emitted in front of the signed path, but isn't signed itself, e.g.
+// "/cdn" for a reverse proxy that rewrites /cdn/<path> to <path> on the
+// imgix domain (see WithDisplayHost). Since the proxy strips the prefix
+// before forwarding the request, the signature is computed over the path
+// that imgix sees, without the prefix, and remains valid.
+//
+// The prefix differs from a base path (see WithBasePath), which is part
+// of the path the renderer sees and so is signed.
+func WithSignedPathPrefix(prefix string) BuilderOption {
+	return func(b *URLBuilder) {
+		prefix = strings.Trim(prefix, "/")
+		if prefix == "" {
+			b.displayPrefix = ""
+			return
+		}
+		b.displayPrefix = encodePath(prefix)
+	}
+}
+
+// WithMaxDimensions returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set the maximum width (w) and
+// height (h) of the rendered image. CreateURLE returns an error when an
+// explicit w or h exceeds its maximum, and srcset width-ladders are
+// clamped to the maximum width. Dpr-described srcsets stop before the
+// candidate whose scaled w or h would exceed its maximum. A maximum of
+// zero is unbounded.
+//
+// Without this option, imgix silently clamps oversized dimensions (to
+// its own limits or to the source's size), hiding the bug and wasting
+// processing.
+func WithMaxDimensions(maxWidth int, maxHeight int) BuilderOption {
+	return func(b *URLBuilder) {
+		b.maxWidth = maxWidth
+		b.maxHeight = maxHeight
+	}
+}
+
+// WithCollapseSlashes returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to collapse each run of
+// consecutive slashes in a path into a single slash (e.g. "/a//b.jpg"
+// into "/a/b.jpg") before it's escaped and signed. Web proxy paths are
+// never collapsed, since their sources are URLs in their own right.
+//
+// By default, slashes are preserved, as some sources depend on them.
+func WithCollapseSlashes() BuilderOption {
+	return func(b *URLBuilder) {
+		b.collapseSlashes = true
+	}
+}
+
+// WithDefaultFit returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set the fit that is injected
+// when both w and h are set, but fit isn't. Without this option, imgix
+// applies its own default (fit=clip), which often surprises those who
+// expect the image to be cropped to the dimensions (fit=crop).
+func WithDefaultFit(fit FitMode) BuilderOption {
+	return func(b *URLBuilder) {
+		b.defaultFit = fit
+	}
+}
+
+// WithFormatFromExtension returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to set the output format
+// (fm) to the format of the path's extension (e.g. fm=png for .png, or
+// fm=jpg for .jpg and .jpeg), which preserves the original format rather
+// than letting imgix convert it. For proxy paths, the extension of the
+// source URL's path is used. The fm is left unset if the extension is
+// unknown, if fm was given explicitly, or if auto contains format, which
+// it would otherwise override.
+func WithFormatFromExtension() BuilderOption {
+	return func(b *URLBuilder) {
+		b.extensionFormat = true
+	}
+}
+
+// WithTreatPathAsLiteral returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to treat every path as
+// literal text, so that each '%' is encoded (as "%25") no matter what
+// follows it.
+//
+// Paths are already encoded this way, with one exception: a path that
+// starts with a percent-encoded proxy prefix (e.g. "https%3A%2F%2F") is
+// assumed to be a pre-encoded web proxy source and is used as-is. With
+// this option, such a path is encoded like any other instead, so a path
+// whose '%' characters are data is never misinterpreted.
+func WithTreatPathAsLiteral() BuilderOption {
+	return func(b *URLBuilder) {
+		b.literalPaths = true
+	}
+}
+
+// WithPreEscapedPath returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to use every path as-is,
+// on the assumption that it has already been escaped, e.g. by the system
+// that a service is migrating from. A path such as "/my%20image.png" is
+// then emitted (and signed) verbatim rather than being escaped again as
+// "/my%2520image.png". Only a leading '/' is added, if it is missing, and
+// the builder's base path is still prepended (see WithBasePath).
+//
+// The caller is responsible for escaping every path correctly: a path
+// that contains, e.g., a space, '?', or '#' produces a broken URL. This
+// option overrides the builder's other path-escaping options (see
+// WithStrictPathEncoding, WithAggressivePathEscaping, and
+// WithTreatPathAsLiteral).
+func WithPreEscapedPath() BuilderOption {
+	return func(b *URLBuilder) {
+		b.preEscapedPaths = true
+	}
+}
+
+// WithOutputValidation returns a BuilderOption that NewURLBuilder
+// consumes. The constructor uses this closure to make the methods that
+// return an error (e.g. CreateURLE) re-parse each final URL and return
+// an error that wraps ErrMalformedURL if it doesn't parse or if it isn't
+// encoded canonically (see validateOutputURL). This is a safety net
+// against escaping bugs, e.g. in paths given to WithPreEscapedPath; it
+// is off by default because of its cost.
+func WithOutputValidation() BuilderOption {
+	return func(b *URLBuilder) {
+		b.validateOutput = true
+	}
+}
+
+// WithMaxURLLength returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set the maximum length of URLs
+// returned by CreateURLE. A maxURLLength of zero disables the check.
+func WithMaxURLLength(maxURLLength int) BuilderOption {
+	return func(b *URLBuilder) {
+		b.maxURLLength = maxURLLength
+	}
+}
+
 // UseHTTPS returns whether HTTPS or HTTP should be used.
 func (b *URLBuilder) UseHTTPS() bool {
 	return b.useHTTPS
@@ -95,6 +689,17 @@ func (b *URLBuilder) Domain() string {
 	return b.domain
 }
 
+// MaxURLLength gets the maximum length of URLs returned by CreateURLE.
+func (b *URLBuilder) MaxURLLength() int {
+	return b.maxURLLength
+}
+
+// SetMaxURLLength sets the maximum length of URLs returned by CreateURLE.
+// Setting maxURLLength to zero disables the check.
+func (b *URLBuilder) SetMaxURLLength(maxURLLength int) {
+	b.maxURLLength = maxURLLength
+}
+
 // SetToken sets the token for this builder. This value will be used to sign
 // URLs created through the builder.
 func (b *URLBuilder) SetToken(token string) {
@@ -120,93 +725,610 @@ func Param(k string, v ...string) IxParam {
 	}
 }
 
-// CreateURL creates a URL string given a path and a set of
-// params.
-func (b *URLBuilder) CreateURL(path string, params ...IxParam) string {
+// applyParams applies each of the params to a new url.Values. Nil
+// params are skipped, so that passing nil never causes a panic.
+func applyParams(params []IxParam) url.Values {
 	urlParams := url.Values{}
 
 	for _, fn := range params {
-		fn(&urlParams)
+		if fn != nil {
+			fn(&urlParams)
+		}
 	}
+	return urlParams
+}
 
-	scheme := b.Scheme()
-	domain := b.Domain()
-	path = sanitizePath(path)
+// CreateURL creates a URL string given a path and a set of
+// params. The empty path and "/" both refer to the root path, so the URL
+// for either has the path "/" (e.g. "https://example.imgix.net/?w=100"),
+// and it's signed as such.
+func (b *URLBuilder) CreateURL(path string, params ...IxParam) string {
+	urlParams := applyParams(params)
+	return b.createURLFromValues(path, urlParams)
+}
+
+// Pair is a single query param, given as a key and a value. Unlike
+// url.Values, a slice of Pairs has an order of its own.
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// CreateURLOrdered creates a URL string given a path and params supplied
+// as ordered key/value pairs, e.g. for golden tests that must not depend
+// on map ordering. The params are emitted in the order in which their
+// keys first appear in the pairs (repeated keys are merged, like
+// Param("auto", "format", "compress")), followed by any params the
+// builder adds (e.g. ixlib), ordered as it would otherwise order them
+// (see WithParamOrder).
+//
+// Note that imgix renders the image identically whatever the order of
+// its params, but the signature is always computed over the query
+// exactly as it is emitted, since that is the query imgix verifies. The
+// emission order given here is therefore also the signing order, and
+// reordering the pairs changes the signature.
+func (b *URLBuilder) CreateURLOrdered(path string, pairs []Pair) string {
+	params := url.Values{}
+	order := make([]string, 0, len(pairs)+len(b.paramOrder))
+	for _, p := range pairs {
+		if _, ok := params[p.Key]; !ok {
+			order = append(order, p.Key)
+		}
+		params.Add(p.Key, p.Value)
+	}
+
+	ordered := *b
+	ordered.paramOrder = append(order, b.paramOrder...)
+	return ordered.createURLFromValues(path, params)
+}
+
+// CreateURLE functions like CreateURL except that it returns an error
+// if the URL cannot be used as-is. An error is returned when:
+//   - the builder has no domain (ErrNoDomain),
+//   - the path must be signed (see WithSignWhen) but the builder has no
+//     token (ErrEmptyToken),
+//   - the length of the final URL exceeds the builder's maximum URL
+//     length (ErrURLTooLong, see WithMaxURLLength),
+//   - an explicit w or h exceeds the builder's maximum (a ParamError
+//     wrapping ErrValueOutOfRange, see WithMaxDimensions),
+//   - a proxy source uses plaintext HTTP (ErrInvalidProxySource, see
+//     WithRequireSecureProxySource),
+//   - the final URL is malformed (ErrMalformedURL, see
+//     WithOutputValidation),
+//   - in ValidationStrict mode, a param isn't recognized (a ParamError
+//     wrapping ErrUnknownParam, see KnownParams), or
+//   - in ValidationStrict mode, the params violate one of the builder's
+//     ParamRules (a ParamWarning, see WithValidation).
+//
+// Use errors.Is to check the kind of failure, e.g.
+// errors.Is(err, ErrURLTooLong).
+func (b *URLBuilder) CreateURLE(path string, params ...IxParam) (string, error) {
+	urlParams := applyParams(params)
+	return b.createURLFromValuesE(path, urlParams)
+}
+
+// CreateExpiringURL creates a signed URL for the path and params that
+// expires ttl from now, e.g. CreateExpiringURL(path, params, 5*time.Minute)
+// for a URL that is valid for five minutes. The expiry is set as the exp
+// param, a Unix timestamp in seconds, which is signed with the other
+// params so that it can't be tampered with; an exp in params is
+// replaced. The URL is validated like the one created by CreateURLE.
+//
+// An error is returned if the ttl isn't positive, if the builder has no
+// token (ErrEmptyToken), or if the builder doesn't sign URLs for the path
+// (see WithSignWhen), since imgix only honors exp on signed URLs. The
+// params passed to this method are not modified.
+func (b *URLBuilder) CreateExpiringURL(path string, params url.Values, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", fmt.Errorf("`ttl` must be greater than zero, found `%s`", ttl)
+	}
+	if b.token == "" {
+		return "", &kindError{ErrEmptyToken, "expiring urls must be signed, but the token is empty"}
+	}
+	if !b.shouldSign(path) {
+		return "", fmt.Errorf("expiring urls must be signed, but path `%s` isn't signed; see WithSignWhen", path)
+	}
+
+	expiring := cloneValues(params)
+	expiring.Set("exp", strconv.FormatInt(b.now().Add(ttl).Unix(), 10))
+	return b.createURLFromValuesE(path, expiring)
+}
+
+// now returns the current time according to the builder's clock.
+func (b *URLBuilder) now() time.Time {
+	if b.nowFunc != nil {
+		return b.nowFunc()
+	}
+	return time.Now()
+}
+
+// newCacheBuster returns a cache-buster from the builder's source.
+func (b *URLBuilder) newCacheBuster() string {
+	if b.cacheBustSource != nil {
+		return b.cacheBustSource()
+	}
+	return cacheBuster()
+}
+
+// BuildProxyURLParam creates a URL that passes the sourceURL to imgix
+// as a base64-encoded `url64` query parameter rather than in the path.
+// This form is often shorter than path-based proxying and sidesteps
+// path-escaping edge cases. The `url64` param is signed like any other
+// param. The params passed to this method are not modified.
+func (b *URLBuilder) BuildProxyURLParam(sourceURL string, params url.Values) string {
+	urlParams := cloneValues(params)
+	urlParams.Set("url64", sourceURL)
+	return b.createURLFromValues("/", urlParams)
+}
+
+// URLLength returns the length of the URL that CreateURLE creates for the
+// path and params, e.g. to check a batch of transforms against a CDN's
+// limit before serving them. The URL is fully built (and signed), so the
+// length is exact. If the URL exceeds the builder's maximum URL length
+// (see WithMaxURLLength), its length is returned along with the error;
+// for any other error that CreateURLE would return, the length is zero.
+// The params passed to this method are not modified.
+func (b *URLBuilder) URLLength(path string, params url.Values) (int, error) {
+	u, err := b.buildCheckedURL(path, params)
+	if err != nil {
+		return 0, err
+	}
+	return len(u), validateURLLength(u, b.maxURLLength)
+}
+
+// CreateURLFromURL creates a URL from a parsed source URL, avoiding a
+// round-trip through a string (and the re-encoding that comes with it).
+// If the source has both a scheme and a host, it's treated as a web
+// proxy source, with the path and query kept as-is (and the fragment
+// dropped). Otherwise, its (decoded) path is used as the image path and
+// any params in its query are applied beneath the given params, which
+// take precedence. Neither source nor params are modified. A nil source
+// yields an empty string.
+func (b *URLBuilder) CreateURLFromURL(source *url.URL, params url.Values) string {
+	if source == nil {
+		return ""
+	}
+	if source.Scheme != "" && source.Host != "" {
+		proxy := *source
+		proxy.Fragment = ""
+		return b.createURLFromValues(proxy.String(), params)
+	}
+
+	urlParams := source.Query()
+	for k, v := range params {
+		urlParams[k] = append([]string(nil), v...)
+	}
+	return b.createURLFromValues(source.Path, urlParams)
+}
+
+// PurgeVariants creates the URL of each of the path's transform
+// variants, e.g. to submit to imgix's purge API when an asset is
+// re-uploaded. Each URL is identical to the one CreateURL creates for
+// the same path and params (including the signature and the ixlib
+// param), as a purge must exactly match the URL that was served.
+func (b *URLBuilder) PurgeVariants(path string, variants []url.Values) []string {
+	urls := make([]string, 0, len(variants))
+	for _, params := range variants {
+		urls = append(urls, b.createURLFromValues(path, params))
+	}
+	return urls
+}
+
+// DebugSignatureBase returns the signature base (see SignatureBase) that
+// the builder hashes to sign the URL for the path and params. If the
+// builder wouldn't sign the URL, an empty string is returned. This is an
+// explicit debugging aid: the signature base contains the token, so it
+// must not be logged or exposed in production.
+func (b *URLBuilder) DebugSignatureBase(path string, params ...IxParam) string {
+	if b.token == "" || !b.shouldSign(path) {
+		return ""
+	}
+
+	urlParams := b.prepareParams(path, applyParams(params))
 	query := b.buildQueryString(urlParams)
-	signature := b.sign(path, query)
+	return SignatureBase(b.token, b.sanitizePath(path), query)
+}
 
-	url := scheme + "://" + domain + path
+// SignedParams returns the params that the builder signs in the URL for
+// the path and params, e.g. to diagnose a signature mismatch between
+// environments with different builder configurations. These are the
+// params after the builder's param transformations have been applied
+// (see prepareParams), including the ixlib param if it is enabled, but
+// excluding the unsigned trailing params (see WithUnsignedTrailingParams)
+// and the signature itself. If the builder wouldn't sign the URL, nil is
+// returned. The params passed to this method are not modified.
+func (b *URLBuilder) SignedParams(path string, params url.Values) url.Values {
+	if b.token == "" || !b.shouldSign(path) {
+		return nil
+	}
+
+	signed := b.prepareParams(path, params)
+	b.buildQueryString(signed)
+	return signed
+}
 
-	// If the query and signature are empty, return the url.
-	if query == "" && signature == "" {
-		return url
+// EffectiveQuality returns the quality (q) of the URL that CreateURL
+// creates for the path and params, after the builder's quality defaults
+// have been applied: an explicit q always wins, then the quality for the
+// output format (see WithFormatQuality), and then the default quality
+// (see WithDefaultQuality). Zero means that no q is emitted (or that it
+// isn't an integer), in which case imgix applies its own default. The
+// path is needed because the output format may be inferred from its
+// extension.
+//
+// Note that a dpr param doesn't reduce the quality of a single URL, as
+// CreateURL never applies the per-dpr qualities; those only apply to the
+// candidates of a dpr-based srcset (see WithVariableQuality and
+// WithDprQualities), whose q each candidate carries explicitly. The
+// params passed to this method are not modified.
+func (b *URLBuilder) EffectiveQuality(path string, params url.Values) int {
+	q, err := strconv.Atoi(b.prepareParams(path, params).Get("q"))
+	if err != nil {
+		return 0
 	}
+	return q
+}
 
-	// If the signature is empty, but the query is not,
-	// return the url with the query appended.
-	if query != "" && signature == "" {
-		return url + "?" + query
+// createURLFromValuesE functions like createURLFromValues except that
+// it validates the resulting URL before returning it.
+func (b *URLBuilder) createURLFromValuesE(path string, params url.Values) (string, error) {
+	return b.createPreparedURLE(path, b.prepareParams(path, params))
+}
+
+// createPreparedURLE functions like createURLFromValuesE except that the
+// params have already been prepared (see prepareParams).
+func (b *URLBuilder) createPreparedURLE(path string, params url.Values) (string, error) {
+	result, err := b.buildCheckedPrepared(path, params)
+	if err != nil {
+		return "", err
 	}
+	if err := b.validateURL(result.URL); err != nil {
+		return "", err
+	}
+	return result.URL, nil
+}
+
+// validateURL checks the final URL u: its length (see WithMaxURLLength)
+// and, if the builder validates its output, its encoding (see
+// WithOutputValidation).
+func (b *URLBuilder) validateURL(u string) error {
+	if err := validateURLLength(u, b.maxURLLength); err != nil {
+		return err
+	}
+	if b.validateOutput {
+		return validateOutputURL(u)
+	}
+	return nil
+}
+
+// Build functions like CreateURLE except that, rather than only the
+// final URL, it returns the artifacts of each stage of building the URL
+// (see BuildResult). It creates exactly the URL that CreateURLE would,
+// which makes it the place to start when a URL doesn't match the one
+// that imgix expects. The params passed to this method are not modified.
+func (b *URLBuilder) Build(path string, params url.Values) (BuildResult, error) {
+	result, err := b.buildChecked(path, params)
+	if err != nil {
+		return BuildResult{}, err
+	}
+	if err := b.validateURL(result.URL); err != nil {
+		return BuildResult{}, err
+	}
+	return result, nil
+}
+
+// buildCheckedURL functions like createURLFromValuesE except that it
+// doesn't check the length of the resulting URL.
+func (b *URLBuilder) buildCheckedURL(path string, params url.Values) (string, error) {
+	result, err := b.buildChecked(path, params)
+	if err != nil {
+		return "", err
+	}
+	return result.URL, nil
+}
 
-	// If the query is empty, but the signature is not,
-	// return the url with the signature appended.
-	if query == "" && signature != "" {
-		return url + "?" + signature
+// buildChecked functions like buildCheckedURL except that it returns
+// the artifacts of each stage of building the URL.
+func (b *URLBuilder) buildChecked(path string, params url.Values) (BuildResult, error) {
+	return b.buildCheckedPrepared(path, b.prepareParams(path, params))
+}
+
+// buildCheckedPrepared functions like buildChecked except that the
+// params have already been prepared (see prepareParams).
+func (b *URLBuilder) buildCheckedPrepared(path string, params url.Values) (BuildResult, error) {
+	if b.domain == "" {
+		return BuildResult{}, ErrNoDomain
+	}
+	if err := b.checkToken(path); err != nil {
+		return BuildResult{}, err
+	}
+	if b.secureProxyOnly {
+		if scheme, _ := checkProxyScheme(path); scheme == "http" {
+			msg := fmt.Sprintf("proxy source must use https, found `%s`", path)
+			return BuildResult{}, &kindError{ErrInvalidProxySource, msg}
+		}
 	}
 
-	// If neither query nor signature is empty, append the
-	// query, then append the signature.
-	if query != "" && signature != "" {
-		url += "?" + query + "&" + signature
+	if err := validateDimensions(params, b.maxWidth, b.maxHeight); err != nil {
+		return BuildResult{}, err
 	}
+	if err := b.checkParams(params, true); err != nil {
+		return BuildResult{}, err
+	}
+
+	return b.build(path, params), nil
+}
 
-	return url
+// checkToken returns an error that wraps ErrEmptyToken if URLs for the
+// path must be signed (see WithSignWhen) but the builder has no token.
+func (b *URLBuilder) checkToken(path string) error {
+	if b.token == "" && b.signWhen != nil && b.signWhen(path) {
+		msg := fmt.Sprintf("path `%s` must be signed, but the token is empty", path)
+		return &kindError{ErrEmptyToken, msg}
+	}
+	return nil
 }
 
 // createURLFromValues functions like CreateURL except that
 // it accepts url.Values.
 func (b *URLBuilder) createURLFromValues(path string, params url.Values) string {
+	return b.createPreparedURL(path, b.prepareParams(path, params))
+}
+
+// createPreparedURL functions like createURLFromValues except that the
+// params have already been prepared (see prepareParams), so that the
+// caller can identify the URL by them (see writeChecksumComment).
+func (b *URLBuilder) createPreparedURL(path string, params url.Values) string {
+	b.checkParams(params, false)
+	return b.buildURL(path, params)
+}
+
+// prepareParams returns a copy of params to which the builder's param
+// transformations have been applied: first the default params are
+// merged in (see WithDefaultParams), then param names are rewritten
+// (see WithCanonicalParamNames), and then duplicate values are removed
+// (see WithDedupeParams). Next, a default fit is injected (see
+// WithDefaultFit) and the format is set from the path's extension (see
+// WithFormatFromExtension). Then, the dpr is clamped (see WithMaxDPR) and
+// a format-specific quality is injected (see WithFormatQuality). Finally,
+// a cache-buster is set (see WithCacheBust) and the params are rewritten
+// (see WithParamRewriter). The params passed to this method are not
+// modified.
+func (b *URLBuilder) prepareParams(path string, params url.Values) url.Values {
+	prepared := mergeDefaultParams(b.defaultParams, params)
+	if b.paramAliases != nil {
+		prepared = renameParams(prepared, b.paramAliases)
+	}
+	if b.dedupeParams {
+		prepared = dedupeParams(prepared)
+	}
+	if b.defaultFit != "" {
+		injectDefaultFit(prepared, b.defaultFit)
+	}
+	if b.extensionFormat {
+		injectExtensionFormat(path, prepared)
+	}
+	if b.maxDPR > 0 {
+		b.clampDPR(prepared)
+	}
+	if b.formatQualities != nil || b.defaultQuality != 0 {
+		b.injectQuality(path, prepared)
+	}
+	if b.cacheBust {
+		prepared.Set("v", b.newCacheBuster())
+	}
+	if b.paramRewriter != nil {
+		if rewritten := b.paramRewriter(path, prepared); rewritten != nil {
+			// The rewritten params may be shared, e.g. a package-level
+			// url.Values, and the builder goes on to modify them.
+			prepared = cloneValues(rewritten)
+		}
+	}
+	return prepared
+}
+
+// injectDefaultFit sets the fit param to the fit if both a width and a
+// height are set, by either their short (w and h) or long (width and
+// height) names, unless fit was given explicitly.
+func injectDefaultFit(params url.Values, fit FitMode) {
+	if params.Get("fit") != "" {
+		return
+	}
+
+	hasWidth := params.Get("w") != "" || params.Get("width") != ""
+	hasHeight := params.Get("h") != "" || params.Get("height") != ""
+	if hasWidth && hasHeight {
+		params.Set("fit", string(fit))
+	}
+}
+
+// injectExtensionFormat sets the fm param to the format of the path's
+// extension (see formatFromPath), unless fm was given explicitly or auto
+// contains format. If the extension is unknown, fm is left unset.
+func injectExtensionFormat(path string, params url.Values) {
+	if params.Get("fm") != "" || hasSetMember(params, "auto", "format") {
+		return
+	}
+	if format := formatFromPath(path); format != "" {
+		params.Set("fm", string(format))
+	}
+}
+
+// injectQuality sets the q param according to the output format, unless
+// q was given explicitly. The output format is the fm param or, if fm
+// isn't set (and auto doesn't contain format), is inferred from the
+// path's extension. If the format has no quality in the builder's
+// format qualities, the default quality (if any) is used.
+func (b *URLBuilder) injectQuality(path string, params url.Values) {
+	if params.Get("q") != "" {
+		return
+	}
+
+	format := ImageFormat(params.Get("fm"))
+	if format == "" && !hasSetMember(params, "auto", "format") {
+		format = formatFromPath(path)
+	}
+
+	if q, ok := b.formatQualities[format]; ok && format != "" {
+		params.Set("q", strconv.Itoa(q))
+	} else if b.defaultQuality != 0 {
+		params.Set("q", strconv.Itoa(b.defaultQuality))
+	}
+}
+
+// clampDPR clamps the dpr param to the builder's maximum DPR (see
+// WithMaxDPR). When validation is enabled, clamping is logged.
+func (b *URLBuilder) clampDPR(params url.Values) {
+	value := params.Get("dpr")
+	dpr, err := strconv.ParseFloat(value, 64)
+	if err != nil || dpr <= b.maxDPR {
+		return
+	}
+
+	clamped := formatFloat(b.maxDPR)
+	params.Set("dpr", clamped)
+	if b.validationMode != ValidationOff {
+		log.Printf("imgix: clamped `dpr=%s` to the maximum dpr of %s", value, clamped)
+	}
+}
+
+// BuildResult holds the artifacts of each stage of building a URL (see
+// Build), in the order in which they're produced.
+type BuildResult struct {
+	// Path is the sanitized and encoded path, including the builder's
+	// base path (see WithBasePath), e.g. "/image.png".
+	Path string
+
+	// Query is the encoded query, as signed and emitted, e.g.
+	// "ixlib=go-2.0.2&w=100". It doesn't contain the signature or the
+	// unsigned trailing params (see WithUnsignedTrailingParams).
+	Query string
+
+	// SignatureBase is the string that is hashed to create the
+	// signature (see SignatureBase), with the token redacted as
+	// "{TOKEN}". Use DebugSignatureBase for the unredacted base. It is
+	// empty if the URL isn't signed.
+	SignatureBase string
+
+	// Signature is the value of the signature param, e.g.
+	// "0b1f2c3d...". It is empty if the URL isn't signed.
+	Signature string
+
+	// URL is the final URL, exactly as CreateURLE would return it.
+	URL string
+}
+
+// redactedToken replaces the token in the signature base of a
+// BuildResult.
+const redactedToken = "{TOKEN}"
+
+// buildURL assembles the final URL from the builder's scheme and
+// domain, the sanitized path, the encoded query, and the signature.
+func (b *URLBuilder) buildURL(path string, params url.Values) string {
+	return b.build(path, params).URL
+}
+
+// build functions like buildURL except that it returns the artifacts of
+// each stage of building the URL.
+func (b *URLBuilder) build(path string, params url.Values) BuildResult {
 	scheme := b.Scheme()
 	domain := b.Domain()
-	path = sanitizePath(path)
+	shouldSign := b.shouldSign(path)
+	path = b.sanitizePath(path)
 	query := b.buildQueryString(params)
-	signature := b.sign(path, query)
+	result := BuildResult{Path: path, Query: query}
 
-	url := scheme + "://" + domain + path
+	var signature string
+	if shouldSign {
+		signature = b.sign(path, query)
+	}
+	if signature != "" {
+		result.SignatureBase = SignatureBase(redactedToken, path, query)
+		result.Signature = signature[strings.IndexByte(signature, '=')+1:]
+	}
 
-	// If the query and signature are empty, return the url.
-	if query == "" && signature == "" {
-		return url
+	if b.displayHost != "" {
+		domain = b.displayHost
 	}
 
-	// If the signature is empty, but the query is not,
-	// return the url with the query appended.
-	if query != "" && signature == "" {
-		return url + "?" + query
+	url := scheme + "://" + domain + b.displayPrefix + path
+	if b.protocolRelative {
+		url = "//" + domain + b.displayPrefix + path
+	}
+	if b.pathOnly {
+		url = b.displayPrefix + path
 	}
 
-	// If the query is empty, but the signature is not,
-	// return the url with the signature appended.
-	if query == "" && signature != "" {
-		return url + "?" + signature
+	// Append the query, then the signature, and then any unsigned
+	// trailing params, skipping those that are empty.
+	var queryParts []string
+	for _, part := range []string{query, signature, b.trailingQuery()} {
+		if part != "" {
+			queryParts = append(queryParts, part)
+		}
+	}
+
+	result.URL = url
+	if len(queryParts) > 0 {
+		result.URL += "?" + strings.Join(queryParts, "&")
+	}
+	return result
+}
+
+// trailingQuery encodes the builder's unsigned trailing params (see
+// WithUnsignedTrailingParams).
+func (b *URLBuilder) trailingQuery() string {
+	if len(b.trailingParams) == 0 {
+		return ""
 	}
+	return strings.Join(encodeQuery(b.trailingParams), "&")
+}
 
-	// If neither query nor signature is empty, append the
-	// query, then append the signature.
-	if query != "" && signature != "" {
-		url += "?" + query + "&" + signature
+// cloneValues returns a deep copy of params so that callers' url.Values
+// are never modified while a URL is being built. A nil params yields an
+// empty, non-nil url.Values.
+func cloneValues(params url.Values) url.Values {
+	clone := make(url.Values, len(params))
+	for k, v := range params {
+		clone[k] = append([]string(nil), v...)
 	}
+	return clone
+}
 
-	return url
+// libParam returns the value of the builder's ixlib param (see
+// WithLibraryParamValue).
+func (b *URLBuilder) libParam() string {
+	if b.libParamValue != "" {
+		return b.libParamValue
+	}
+	return LibVersion
 }
 
 func (b *URLBuilder) buildQueryString(params url.Values) string {
-	var encodedQueryParts []string
 	if b.useLibParam {
-		params.Set("ixlib", ixLibVersion)
+		params.Set("ixlib", b.libParam())
 	}
-	encodedQueryParts = encodeQuery(params)
-	return strings.Join(encodedQueryParts, "&")
+
+	if b.emptyParams == DropEmptyParams {
+		for k, v := range params {
+			if strings.Join(v, "") == "" {
+				delete(params, k)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	writeQuery(&sb, params, b.paramOrder, b.emptyParams == BareEmptyParams)
+	return sb.String()
+}
+
+// shouldSign reports whether URLs for the (unsanitized) path should be
+// signed. See WithSignWhen.
+func (b *URLBuilder) shouldSign(path string) bool {
+	if b.signWhen == nil {
+		return true
+	}
+	return b.signWhen(path)
 }
 
 func (b *URLBuilder) sign(path string, query string) string {
@@ -215,14 +1337,60 @@ func (b *URLBuilder) sign(path string, query string) string {
 	}
 
 	signature := createMd5Signature(b.token, path, query)
-	return strings.Join([]string{"s=", signature}, "")
+	if b.signatureCase == HexUpper {
+		signature = strings.ToUpper(signature)
+	}
+	name := "s"
+	if b.signatureParam != "" {
+		name = b.signatureParam
+	}
+	return strings.Join([]string{name, "=", signature}, "")
+}
+
+// sanitizePath functions like the package-level sanitizePath, and then
+// applies the builder's path-escaping options (see
+// WithAggressivePathEscaping and WithStrictPathEncoding). Finally, the
+// builder's base path is prepended (see WithBasePath). If the builder
+// collapses slashes (see WithCollapseSlashes), they're collapsed first.
+// A pre-escaped path (see WithPreEscapedPath) is only given a leading
+// '/' before the base path is prepended.
+func (b *URLBuilder) sanitizePath(path string) string {
+	if b.collapseSlashes && !IsProxyPath(path) {
+		path = collapseSlashes(path)
+	}
+
+	if b.preEscapedPaths {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		return b.basePath + path
+	}
+
+	sanitized := sanitizePath(path)
+	if b.strictEncoding {
+		sanitized = strictEncodePath(path)
+	}
+	if _, isEncoded := checkProxyStatus(path); b.literalPaths && isEncoded {
+		// The path only looks like a percent-encoded proxy source, so it
+		// is encoded like any other path.
+		if b.strictEncoding {
+			sanitized = strictEncodeComponents(path)
+		} else {
+			sanitized = encodePath(path)
+		}
+	}
+	if b.aggressiveEscapes != "" {
+		sanitized = escapeChars(sanitized, b.aggressiveEscapes)
+	}
+	return b.basePath + sanitized
 }
 
 // processPath processes a path string into a form that can be
-// safely used in a URL path segment.
+// safely used in a URL path segment. The empty path is the root path,
+// "/".
 func sanitizePath(path string) string {
 	if path == "" {
-		return path
+		return "/"
 	}
 
 	if !strings.HasPrefix(path, "/") {
@@ -0,0 +1,677 @@
+package imgix
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// floatPrecision is the maximum number of decimal places used when
+// formatting float param values.
+const floatPrecision = 3
+
+// formatFloat formats a float param value canonically: it is rounded to
+// at most floatPrecision decimal places, trailing zeros are stripped,
+// and scientific notation is never used. For instance, 2.0 and 2.00 are
+// both formatted as "2", and 0.500 is formatted as "0.5". Since imgix
+// treats these values as distinct cache keys, formatting them
+// consistently improves cache hit rates.
+func formatFloat(v float64) string {
+	scale := math.Pow(10, floatPrecision)
+	rounded := math.Round(v*scale) / scale
+	if rounded == 0 {
+		// Avoid formatting negative zero as "-0".
+		return "0"
+	}
+	return strconv.FormatFloat(rounded, 'f', -1, 64)
+}
+
+// ImageFormat is an output format (fm) that can be used when rendering
+// still images.
+type ImageFormat string
+
+const (
+	// AVIF renders the image as AVIF.
+	AVIF ImageFormat = "avif"
+
+	// GIF renders the image as GIF.
+	GIF ImageFormat = "gif"
+
+	// JPG renders the image as JPEG.
+	JPG ImageFormat = "jpg"
+
+	// PJPG renders the image as progressive JPEG, which browsers can
+	// display at a low quality before it has fully loaded.
+	PJPG ImageFormat = "pjpg"
+
+	// PNG renders the image as PNG.
+	PNG ImageFormat = "png"
+
+	// WebP renders the image as WebP.
+	WebP ImageFormat = "webp"
+)
+
+// extensionFormats maps (lowercase) file extensions to image formats.
+var extensionFormats = map[string]ImageFormat{
+	".avif": AVIF,
+	".gif":  GIF,
+	".jpeg": JPG,
+	".jpg":  JPG,
+	".png":  PNG,
+	".webp": WebP,
+}
+
+// Format returns an IxParam that sets the output format (fm).
+func Format(format ImageFormat) IxParam {
+	return Param("fm", string(format))
+}
+
+// Frame returns an IxParam that extracts a single frame (frame) of an
+// animated source (e.g. an animated GIF or WebP) as a still image in the
+// given format (fm), e.g. to generate a poster frame. Frames are counted
+// from one. The frame param only works on animated sources; imgix
+// ignores it for other images. An error is returned if the frame isn't
+// positive or the format isn't a still format (i.e. not GIF).
+func Frame(n int, format ImageFormat) (IxParam, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("`frame` must be greater than zero, found `%d`", n)
+	}
+
+	switch format {
+	case AVIF, JPG, PJPG, PNG, WebP:
+	default:
+		return nil, fmt.Errorf("`fm` must be a still format (one of avif, jpg, pjpg, "+
+			"png, or webp), found `%s`", format)
+	}
+
+	return func(u *url.Values) {
+		u.Set("frame", strconv.Itoa(n))
+		u.Set("fm", string(format))
+	}, nil
+}
+
+// formatFromPath infers the image format from the extension of the path.
+// For proxy paths, the extension of the source URL's path is used. If
+// the extension is unknown, an empty ImageFormat is returned.
+func formatFromPath(p string) ImageFormat {
+	if isProxy, isEncoded := checkProxyStatus(p); isProxy {
+		source := strings.TrimPrefix(p, "/")
+		if isEncoded {
+			unescaped, err := url.PathUnescape(source)
+			if err != nil {
+				return ""
+			}
+			source = unescaped
+		}
+
+		u, err := url.Parse(source)
+		if err != nil {
+			return ""
+		}
+		p = u.Path
+	}
+	return extensionFormats[strings.ToLower(path.Ext(p))]
+}
+
+// ChromaSub returns an IxParam that sets the chroma subsampling
+// (chromasub) of JPEG and progressive JPEG output. The valid values are
+// 420, 422, and 444; invalid values are reported when validation is
+// enabled (see WithValidation).
+func ChromaSub(chromaSub int) IxParam {
+	return Param("chromasub", strconv.Itoa(chromaSub))
+}
+
+// ColorQuant returns an IxParam that limits the number of unique colors
+// (colorquant) in the output. The valid values are integers between 2 and
+// 256; invalid values are reported when validation is enabled (see
+// WithValidation).
+func ColorQuant(colors int) IxParam {
+	return Param("colorquant", strconv.Itoa(colors))
+}
+
+// ClientHint is a Client Hint that imgix can use to size the image (see
+// ClientHints).
+type ClientHint string
+
+const (
+	// ClientHintWidth is the layout width of the image, in device pixels.
+	ClientHintWidth ClientHint = "Width"
+
+	// ClientHintDPR is the device pixel ratio of the client.
+	ClientHintDPR ClientHint = "DPR"
+
+	// ClientHintSaveData is the client's preference for reduced data
+	// usage.
+	ClientHintSaveData ClientHint = "Save-Data"
+
+	// ClientHintViewportWidth is the width of the client's viewport, in
+	// CSS pixels.
+	ClientHintViewportWidth ClientHint = "Viewport-Width"
+)
+
+// ClientHints returns an IxParam that sets the Client Hints (ch) that
+// imgix responds to, e.g. ch=DPR,Width. The hints are de-duplicated and
+// sorted, so the same hints always yield the same URL. If no hints are
+// given, no param is set.
+//
+// Note that browsers only send Client Hints to imgix once the page has
+// opted in to them, i.e. the page's response includes an Accept-CH
+// header (e.g. "Accept-CH: DPR, Width") and, since imgix is a
+// third-party origin, a Permissions-Policy that delegates the hints to
+// the imgix domain. The Width hint also requires a sizes attribute on
+// the <img>.
+func ClientHints(hints ...ClientHint) IxParam {
+	if len(hints) == 0 {
+		return func(u *url.Values) {}
+	}
+
+	members := make([]string, len(hints))
+	for i, hint := range hints {
+		members[i] = string(hint)
+	}
+	return Param("ch", canonicalizeSetParam(members, SortedMembers))
+}
+
+// TextOverlay returns an IxParam that sets multi-line overlay text. The
+// lines are joined by newlines and emitted base64-encoded as txt64,
+// which avoids any ambiguity in how the newlines are encoded. Empty
+// lines are preserved, so a trailing empty line yields a trailing
+// newline.
+func TextOverlay(lines ...string) IxParam {
+	return Param("txt64", strings.Join(lines, "\n"))
+}
+
+// Bust returns an IxParam that sets a cache-buster (v), e.g. a timestamp
+// or a content hash. Changing it changes the URL, so imgix (and any cache
+// in front of it) renders the image anew rather than serving a stale,
+// cached rendition.
+//
+// Like any other param, the cache-buster is part of the signed query, so
+// it can't be changed without invalidating the signature. Params that
+// must be appended without being signed, such as tracking params, belong
+// in WithUnsignedTrailingParams instead.
+func Bust(v string) IxParam {
+	return Param("v", v)
+}
+
+// Version returns an IxParam that sets a content version (v), e.g. a
+// short content hash or an S3 ETag, for assets that are cached forever.
+// Unlike Bust, the version is validated: it must be non-empty and made up
+// of URL-safe characters (letters, digits, '-', '.', '_', and '~'), so it
+// is emitted (and signed) verbatim.
+//
+// The version must be stable for a given version of the content: the
+// same content must always yield the same version, and so the same
+// (signed) URL, which the CDN can then cache indefinitely. Only a change
+// in the content should change the version.
+func Version(v string) (IxParam, error) {
+	if v == "" {
+		return nil, errors.New("version `v` must not be empty")
+	}
+	// RFC 3986's unreserved characters are those that encodeURIComponent
+	// leaves unescaped, except for its sub-delimiters (i.e. !*'()).
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; !isURIComponentSafe(c) || strings.IndexByte("!*'()", c) >= 0 {
+			return nil, fmt.Errorf("version `v` must be URL-safe, found `%s`", v)
+		}
+	}
+	return Bust(v), nil
+}
+
+// Quality returns an IxParam that sets the output quality (q). The valid
+// values are between 0 and 100. Unlike QualityFloat, Quality doesn't
+// check the range itself, so Quality(101) sets q=101 as-is; the value is
+// only reported (or rejected) when validation is enabled (see
+// WithValidation).
+func Quality(q int) IxParam {
+	return Param("q", strconv.Itoa(q))
+}
+
+// QualityFloat returns an IxParam that sets a fractional output quality
+// (q), e.g. 82.5. The quality is formatted canonically (see formatFloat),
+// so 82.50 and 82.5 both set q=82.5 and share a cache entry. An error is
+// returned if the quality isn't between 0 and 100.
+//
+// Most encoders use an integer quality scale and round a fractional
+// quality, in which case 82.4 and 82 render identical images but are
+// cached separately. A fractional quality is only meaningful when
+// tuning the quality finely (e.g. to match a file-size budget), so
+// prefer Quality unless the fraction is needed.
+func QualityFloat(q float64) (IxParam, error) {
+	if !(q >= 0 && q <= 100) {
+		return nil, fmt.Errorf("`q` must be between 0 and 100, found `%s`",
+			strconv.FormatFloat(q, 'f', -1, 64))
+	}
+	return Param("q", formatFloat(q)), nil
+}
+
+// DPR returns an IxParam that sets the device pixel ratio (dpr).
+func DPR(dpr float64) IxParam {
+	return Param("dpr", formatFloat(dpr))
+}
+
+// DevicePixels returns the IxParams that pin an image to cssWidth CSS
+// pixels (w) while letting imgix scale it by the device pixel ratio
+// (dpr), e.g. DevicePixels(400, 2) sets w=400&dpr=2 and renders an
+// 800px-wide image. This is the intended way to serve a sharp image on
+// high-density displays: multiplying w by the ratio manually instead
+// defeats imgix's dpr handling. An error is returned if cssWidth isn't
+// positive or dpr is negative.
+func DevicePixels(cssWidth int, dpr float64) ([]IxParam, error) {
+	if cssWidth <= 0 {
+		return nil, errors.New("`w` must be greater than zero")
+	}
+	if dpr < 0 || math.IsNaN(dpr) {
+		return nil, errors.New("`dpr` must be greater than, or equal to, zero")
+	}
+	return []IxParam{Param("w", strconv.Itoa(cssWidth)), DPR(dpr)}, nil
+}
+
+// AspectRatio returns an IxParam that sets the aspect ratio (ar) to
+// width:height, e.g. AspectRatio(16, 9) sets ar=16:9. Note that imgix
+// only applies the aspect ratio when fit=crop.
+func AspectRatio(width float64, height float64) IxParam {
+	return Param("ar", formatFloat(width)+":"+formatFloat(height))
+}
+
+// FocalPoint returns an IxParam that sets the focal point (fp-x and
+// fp-y) as fractions of the image's width and height. Note that imgix
+// only applies the focal point when crop=focalpoint and fit=crop.
+func FocalPoint(x float64, y float64) IxParam {
+	return func(u *url.Values) {
+		u.Set("fp-x", formatFloat(x))
+		u.Set("fp-y", formatFloat(y))
+	}
+}
+
+// VideoFormat is an output format that can be used when rendering video.
+type VideoFormat string
+
+const (
+	// MP4 renders the source as an H.264 MP4 video.
+	MP4 VideoFormat = "mp4"
+
+	// WebM renders the source as a WebM video.
+	WebM VideoFormat = "webm"
+)
+
+// Video captures the params used to render a video (or video clip).
+// It only covers URL construction: imgix performs the transcoding.
+//
+// These params apply to video sources (e.g. .mp4, .mov, and .webm files)
+// and to animated images rendered as video (e.g. an animated GIF with
+// fm=mp4). They are ignored by imgix for still images. Trimming via
+// Start and End requires a source that supports video rendering.
+type Video struct {
+	// Format is the output video format (fm).
+	Format VideoFormat
+
+	// FPS is the output frame rate (vid-fps). Zero omits the param.
+	FPS int
+
+	// Start is the offset, in seconds, at which the output begins
+	// (vid-start). Zero omits the param.
+	Start float64
+
+	// End is the offset, in seconds, at which the output ends
+	// (vid-end). Zero omits the param.
+	End float64
+}
+
+// Validate checks that the Video's values are valid. The Format is
+// required, the FPS must not be negative, and the range defined by Start
+// and End must not be negative or decreasing.
+func (v Video) Validate() error {
+	switch v.Format {
+	case MP4, WebM:
+	case "":
+		return errors.New("video `fm` is required")
+	default:
+		return fmt.Errorf("video `fm` must be one of mp4 or webm, found `%s`", v.Format)
+	}
+
+	if v.FPS < 0 {
+		return errors.New("`vid-fps` must be greater than, or equal to, zero")
+	}
+
+	if v.Start < 0 || v.End < 0 {
+		return errors.New("`vid-start` and `vid-end` must be greater than, or equal to, zero")
+	}
+
+	if v.End != 0 && v.End <= v.Start {
+		return errors.New("`vid-end` must be greater than `vid-start`")
+	}
+	return nil
+}
+
+// Params validates the Video and returns the IxParams that render it.
+func (v Video) Params() ([]IxParam, error) {
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
+
+	params := []IxParam{Param("fm", string(v.Format))}
+	if v.FPS != 0 {
+		params = append(params, Param("vid-fps", strconv.Itoa(v.FPS)))
+	}
+	if v.Start != 0 {
+		params = append(params, Param("vid-start", formatFloat(v.Start)))
+	}
+	if v.End != 0 {
+		params = append(params, Param("vid-end", formatFloat(v.End)))
+	}
+	return params, nil
+}
+
+// TextClip determines where overlay text is clipped when it overflows
+// (txt-clip).
+type TextClip string
+
+const (
+	// ClipStart clips the text at its start.
+	ClipStart TextClip = "start"
+
+	// ClipMiddle clips the text in its middle.
+	ClipMiddle TextClip = "middle"
+
+	// ClipEnd clips the text at its end.
+	ClipEnd TextClip = "end"
+
+	// ClipEllipsis adds an ellipsis where the text is clipped. It can be
+	// combined with the other clip positions.
+	ClipEllipsis TextClip = "ellipsis"
+)
+
+// RichText captures the params used to render a text overlay, including
+// its typesetting. The Text is emitted base64-encoded (txt64), so it may
+// contain any characters, including newlines.
+//
+// The Lead and Track are typesetting params: imgix only applies them
+// when rendering through the typesetting endpoint (the "~text" path),
+// and not to text blended onto an image with txt. The remaining params
+// apply to both.
+type RichText struct {
+	// Text is the text to render (txt64). It is required.
+	Text string
+
+	// Clip determines where overflowing text is clipped (txt-clip).
+	// An empty Clip omits the param.
+	Clip []TextClip
+
+	// Lead is the line spacing, in pixels (txt-lead). Zero omits the
+	// param.
+	Lead int
+
+	// Track is the letter spacing, in pixels (txt-track). It may be
+	// negative. Zero omits the param.
+	Track int
+
+	// Width is the maximum width of the text, in pixels (txt-width).
+	// Zero omits the param.
+	Width int
+
+	// Line is the width of the text's outline, in pixels (txt-line).
+	// Zero omits the param.
+	Line int
+
+	// LineColor is the color of the text's outline, as 3, 4, 6, or 8
+	// hex digits (txt-line-color). It requires a Line.
+	LineColor string
+}
+
+// Validate checks that the RichText's values are valid. The Text is
+// required, the Lead, Width, and Line must not be negative, the Clip
+// positions must be known, and the LineColor must be a hex color used
+// along with a Line.
+func (r RichText) Validate() error {
+	if r.Text == "" {
+		return errors.New("rich text `txt64` is required")
+	}
+
+	for _, c := range r.Clip {
+		switch c {
+		case ClipStart, ClipMiddle, ClipEnd, ClipEllipsis:
+		default:
+			return fmt.Errorf("`txt-clip` must be one of start, middle, end, or ellipsis, found `%s`", c)
+		}
+	}
+
+	if r.Lead < 0 {
+		return errors.New("`txt-lead` must be greater than, or equal to, zero")
+	}
+
+	if r.Width < 0 {
+		return errors.New("`txt-width` must be greater than, or equal to, zero")
+	}
+
+	if r.Line < 0 {
+		return errors.New("`txt-line` must be greater than, or equal to, zero")
+	}
+
+	if r.LineColor != "" {
+		if !isHexColor(r.LineColor) {
+			return fmt.Errorf("`txt-line-color` must be a hex color, found `%s`", r.LineColor)
+		}
+		if r.Line == 0 {
+			return errors.New("`txt-line-color` requires `txt-line`")
+		}
+	}
+	return nil
+}
+
+// Params validates the RichText and returns the IxParams that render it.
+func (r RichText) Params() ([]IxParam, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+
+	params := []IxParam{Param("txt64", r.Text)}
+	if len(r.Clip) > 0 {
+		clip := make([]string, len(r.Clip))
+		for i, c := range r.Clip {
+			clip[i] = string(c)
+		}
+		params = append(params, Param("txt-clip", clip...))
+	}
+	if r.Lead != 0 {
+		params = append(params, Param("txt-lead", strconv.Itoa(r.Lead)))
+	}
+	if r.Track != 0 {
+		params = append(params, Param("txt-track", strconv.Itoa(r.Track)))
+	}
+	if r.Width != 0 {
+		params = append(params, Param("txt-width", strconv.Itoa(r.Width)))
+	}
+	if r.Line != 0 {
+		params = append(params, Param("txt-line", strconv.Itoa(r.Line)))
+	}
+	if r.LineColor != "" {
+		params = append(params, Param("txt-line-color", r.LineColor))
+	}
+	return params, nil
+}
+
+// isHexColor reports whether s is a color of 3, 4, 6, or 8 hex digits
+// (i.e. RGB, ARGB, RRGGBB, or AARRGGBB), as imgix expects.
+func isHexColor(s string) bool {
+	switch len(s) {
+	case 3, 4, 6, 8:
+	default:
+		return false
+	}
+
+	_, err := strconv.ParseUint(s, 16, 32)
+	return err == nil
+}
+
+// FitMode determines how an image is resized to fit its target
+// dimensions (fit).
+type FitMode string
+
+const (
+	// FitClamp resizes to fit, extending the edge pixels to fill any
+	// remaining space.
+	FitClamp FitMode = "clamp"
+
+	// FitClip resizes to fit within the dimensions, preserving the
+	// aspect ratio. This is imgix's default.
+	FitClip FitMode = "clip"
+
+	// FitCrop resizes to fill the dimensions, cropping any excess.
+	FitCrop FitMode = "crop"
+
+	// FitFaceArea resizes to fit the area around a detected face.
+	FitFaceArea FitMode = "facearea"
+
+	// FitFill resizes to fit, filling any remaining space with a color.
+	FitFill FitMode = "fill"
+
+	// FitFillMax functions like FitFill, but never upscales the image.
+	FitFillMax FitMode = "fillmax"
+
+	// FitMax functions like FitClip, but never upscales the image.
+	FitMax FitMode = "max"
+
+	// FitMin functions like FitCrop, but never upscales the image.
+	FitMin FitMode = "min"
+
+	// FitScale resizes to the dimensions exactly, distorting the image
+	// if the aspect ratios differ.
+	FitScale FitMode = "scale"
+)
+
+// Alignment is a member of an alignment param (e.g. mark-align).
+// Horizontal and vertical alignments may be combined.
+type Alignment string
+
+const (
+	// AlignTop aligns to the top edge.
+	AlignTop Alignment = "top"
+
+	// AlignMiddle aligns to the vertical middle.
+	AlignMiddle Alignment = "middle"
+
+	// AlignBottom aligns to the bottom edge.
+	AlignBottom Alignment = "bottom"
+
+	// AlignLeft aligns to the left edge.
+	AlignLeft Alignment = "left"
+
+	// AlignCenter aligns to the horizontal center.
+	AlignCenter Alignment = "center"
+
+	// AlignRight aligns to the right edge.
+	AlignRight Alignment = "right"
+)
+
+// Watermark captures the params used to render a watermark (mark), i.e.
+// an image overlaid on the rendered image. The Source is emitted
+// base64-encoded (mark64), so it may be any URL, including one with its
+// own query string.
+type Watermark struct {
+	// Source is the URL of the watermark image (mark64). It is required.
+	Source string
+
+	// Align positions the watermark (mark-align), e.g. bottom and right.
+	// An empty Align omits the param; imgix then aligns to the bottom
+	// right.
+	Align []Alignment
+
+	// Pad is the distance, in pixels, between the watermark and the
+	// edges of the image (mark-pad). Zero omits the param.
+	Pad int
+
+	// Width and Height size the watermark (mark-w and mark-h), in pixels
+	// or, for values between zero and one, as a ratio of the image's
+	// dimensions. Zero omits the param.
+	Width  float64
+	Height float64
+
+	// Scale sizes the watermark as a percentage (0 to 100) of the
+	// image's width (mark-scale). It can't be combined with Width or
+	// Height. Zero omits the param.
+	Scale int
+
+	// Fit determines how the watermark is resized to its Width and
+	// Height (mark-fit). It must be one of FitClip, FitCrop, FitFill,
+	// FitMax, or FitScale. An empty Fit omits the param.
+	Fit FitMode
+}
+
+// Validate checks that the Watermark's values are valid. The Source is
+// required, the Align members must be known, the Pad, Width, and Height
+// must not be negative, the Scale must be between 0 and 100 and not
+// combined with a Width or Height, and the Fit must be supported.
+func (m Watermark) Validate() error {
+	if m.Source == "" {
+		return errors.New("watermark `mark64` is required")
+	}
+
+	for _, a := range m.Align {
+		switch a {
+		case AlignTop, AlignMiddle, AlignBottom, AlignLeft, AlignCenter, AlignRight:
+		default:
+			return fmt.Errorf("`mark-align` must be one of top, middle, bottom, "+
+				"left, center, or right, found `%s`", a)
+		}
+	}
+
+	if m.Pad < 0 {
+		return errors.New("`mark-pad` must be greater than, or equal to, zero")
+	}
+
+	if m.Width < 0 || m.Height < 0 {
+		return errors.New("`mark-w` and `mark-h` must be greater than, or equal to, zero")
+	}
+
+	if m.Scale < 0 || m.Scale > 100 {
+		return fmt.Errorf("`mark-scale` must be between 0 and 100, found `%d`", m.Scale)
+	}
+
+	if m.Scale != 0 && (m.Width != 0 || m.Height != 0) {
+		return errors.New("`mark-scale` cannot be combined with `mark-w` or `mark-h`")
+	}
+
+	switch m.Fit {
+	case "", FitClip, FitCrop, FitFill, FitMax, FitScale:
+	default:
+		return fmt.Errorf("`mark-fit` must be one of clip, crop, fill, max, or scale, found `%s`", m.Fit)
+	}
+	return nil
+}
+
+// Params validates the Watermark and returns the IxParams that render it.
+func (m Watermark) Params() ([]IxParam, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	params := []IxParam{Param("mark64", m.Source)}
+	if len(m.Align) > 0 {
+		align := make([]string, len(m.Align))
+		for i, a := range m.Align {
+			align[i] = string(a)
+		}
+		params = append(params, Param("mark-align", align...))
+	}
+	if m.Pad != 0 {
+		params = append(params, Param("mark-pad", strconv.Itoa(m.Pad)))
+	}
+	if m.Width != 0 {
+		params = append(params, Param("mark-w", formatFloat(m.Width)))
+	}
+	if m.Height != 0 {
+		params = append(params, Param("mark-h", formatFloat(m.Height)))
+	}
+	if m.Scale != 0 {
+		params = append(params, Param("mark-scale", strconv.Itoa(m.Scale)))
+	}
+	if m.Fit != "" {
+		params = append(params, Param("mark-fit", string(m.Fit)))
+	}
+	return params, nil
+}
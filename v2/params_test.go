@@ -0,0 +1,405 @@
+package imgix
+
+import (
+	"math"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParams_Video(t *testing.T) {
+	u := testBuilder()
+	params, err := Video{Format: MP4, FPS: 24, Start: 1.5, End: 4}.Params()
+	assert.Equal(t, nil, err)
+
+	expected := "https://test.imgix.net/clip.mov?fm=mp4&vid-end=4&vid-fps=24&vid-start=1.5"
+	actual := u.CreateURL("clip.mov", params...)
+	assert.Equal(t, expected, actual)
+}
+
+func TestParams_VideoFormatOnly(t *testing.T) {
+	u := testBuilder()
+	params, err := Video{Format: WebM}.Params()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://test.imgix.net/animated.gif?fm=webm", u.CreateURL("animated.gif", params...))
+}
+
+func TestParams_VideoInvalid(t *testing.T) {
+	invalid := []Video{
+		{},
+		{Format: "gif"},
+		{Format: MP4, FPS: -1},
+		{Format: MP4, Start: -1},
+		{Format: MP4, Start: 5, End: 2},
+	}
+
+	for _, v := range invalid {
+		params, err := v.Params()
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, 0, len(params))
+	}
+}
+
+func TestParams_formatFloat(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{2.0, "2"},
+		{2.00, "2"},
+		{1.5, "1.5"},
+		{0.500, "0.5"},
+		{0.33333, "0.333"},
+		{0.6666666, "0.667"},
+		{1.0005, "1.001"},
+		{-0.0001, "0"},
+		{1e21, "1000000000000000000000"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, formatFloat(test.value))
+	}
+}
+
+func TestParams_Version(t *testing.T) {
+	u := NewURLBuilder("my-social-network.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+
+	build := func(v string) string {
+		version, err := Version(v)
+		assert.NoError(t, err)
+		return u.CreateURL("users/1.png", Param("w", "400"), version)
+	}
+
+	expected := "https://my-social-network.imgix.net/users/1.png?v=9b2cf53&w=400&s=" +
+		createMd5Signature("FOO123bar", "/users/1.png", "v=9b2cf53&w=400")
+	assert.Equal(t, expected, build("9b2cf53"))
+
+	// The same version always yields the same signed URL, and a
+	// different version yields a different one.
+	assert.Equal(t, build("9b2cf53"), build("9b2cf53"))
+	assert.NotEqual(t, build("9b2cf53"), build("4e07408"))
+	assert.Contains(t, build("a-1.2_3~4"), "v=a-1.2_3~4&")
+}
+
+func TestParams_VersionInvalid(t *testing.T) {
+	_, err := Version("")
+	assert.EqualError(t, err, "version `v` must not be empty")
+
+	for _, v := range []string{"\"abc\"", "a b", "a/b", "a&b", "a%20b", "a*b"} {
+		version, err := Version(v)
+		assert.Error(t, err)
+		assert.Nil(t, version)
+	}
+}
+
+func TestParams_Frame(t *testing.T) {
+	u := testBuilder()
+
+	frame, err := Frame(1, PNG)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://test.imgix.net/animated.gif?fm=png&frame=1", u.CreateURL("animated.gif", frame))
+
+	frame, err = Frame(12, JPG)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://test.imgix.net/animated.webp?fm=jpg&frame=12&w=320",
+		u.CreateURL("animated.webp", Param("w", "320"), frame))
+}
+
+func TestParams_FrameInvalid(t *testing.T) {
+	_, err := Frame(0, PNG)
+	assert.EqualError(t, err, "`frame` must be greater than zero, found `0`")
+
+	_, err = Frame(1, GIF)
+	assert.EqualError(t, err, "`fm` must be a still format (one of avif, jpg, pjpg, png, or webp), found `gif`")
+
+	_, err = Frame(1, "")
+	assert.Error(t, err)
+}
+
+func TestParams_ClientHints(t *testing.T) {
+	u := testBuilder()
+
+	tests := []struct {
+		hints    []ClientHint
+		expected string
+	}{
+		{[]ClientHint{ClientHintWidth}, "https://test.imgix.net/image.png?ch=Width"},
+		{[]ClientHint{ClientHintWidth, ClientHintDPR, ClientHintSaveData}, "https://test.imgix.net/image.png?ch=DPR%2CSave-Data%2CWidth"},
+		{[]ClientHint{ClientHintSaveData, ClientHintDPR, ClientHintWidth}, "https://test.imgix.net/image.png?ch=DPR%2CSave-Data%2CWidth"},
+		{[]ClientHint{ClientHintDPR, ClientHintDPR, ClientHintViewportWidth}, "https://test.imgix.net/image.png?ch=DPR%2CViewport-Width"},
+		{nil, "https://test.imgix.net/image.png"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, u.CreateURL("image.png", ClientHints(test.hints...)))
+	}
+}
+
+func TestParams_DPR(t *testing.T) {
+	u := testBuilder()
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=2", u.CreateURL("image.png", DPR(2.0)))
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=1.5", u.CreateURL("image.png", DPR(1.50)))
+}
+
+func TestParams_Quality(t *testing.T) {
+	u := testBuilder()
+	assert.Equal(t, "https://test.imgix.net/image.jpg?q=75", u.CreateURL("image.jpg", Quality(75)))
+
+	// Without validation, an out-of-range quality is emitted as-is.
+	assert.Equal(t, "https://test.imgix.net/image.jpg?q=101", u.CreateURL("image.jpg", Quality(101)))
+
+	v := NewURLBuilder("test.imgix.net", WithLibParam(false), WithValidation(ValidationStrict))
+	_, err := v.CreateURLE("image.jpg", Quality(101))
+	assert.Equal(t, invalidValueRule, err.(ParamWarning).Rule)
+}
+
+func TestParams_QualityFloat(t *testing.T) {
+	u := testBuilder()
+
+	tests := []struct {
+		q        float64
+		expected string
+	}{
+		{82.5, "82.5"},
+		{82.50, "82.5"},
+		{82.125, "82.125"},
+		{82.0001, "82"},
+		{80, "80"},
+		{0, "0"},
+		{100, "100"},
+	}
+
+	for _, test := range tests {
+		param, err := QualityFloat(test.q)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://test.imgix.net/image.jpg?q="+test.expected, u.CreateURL("image.jpg", param))
+	}
+
+	for _, q := range []float64{-0.5, 100.5, math.NaN(), math.Inf(1)} {
+		param, err := QualityFloat(q)
+		assert.Error(t, err)
+		assert.Nil(t, param)
+	}
+
+	// Fractional qualities pass validation.
+	v := NewURLBuilder("test.imgix.net", WithLibParam(false), WithValidation(ValidationStrict))
+	param, _ := QualityFloat(82.5)
+	_, err := v.CreateURLE("image.jpg", param)
+	assert.NoError(t, err)
+}
+
+func TestParams_DevicePixels(t *testing.T) {
+	u := testBuilder()
+
+	tests := []struct {
+		dpr      float64
+		expected string
+	}{
+		{1, "https://test.imgix.net/image.png?dpr=1&w=400"},
+		{1.5, "https://test.imgix.net/image.png?dpr=1.5&w=400"},
+		{2.0, "https://test.imgix.net/image.png?dpr=2&w=400"},
+		{2.625, "https://test.imgix.net/image.png?dpr=2.625&w=400"},
+		{3.00, "https://test.imgix.net/image.png?dpr=3&w=400"},
+	}
+
+	for _, test := range tests {
+		params, err := DevicePixels(400, test.dpr)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, u.CreateURL("image.png", params...))
+	}
+}
+
+func TestParams_DevicePixelsInvalid(t *testing.T) {
+	_, err := DevicePixels(400, -1)
+	assert.EqualError(t, err, "`dpr` must be greater than, or equal to, zero")
+
+	_, err = DevicePixels(0, 2)
+	assert.EqualError(t, err, "`w` must be greater than zero")
+}
+
+func TestParams_AspectRatio(t *testing.T) {
+	u := testBuilder()
+	expected := "https://test.imgix.net/image.png?ar=16%3A9&fit=crop"
+	assert.Equal(t, expected, u.CreateURL("image.png", AspectRatio(16.0, 9), Param("fit", "crop")))
+
+	expected = "https://test.imgix.net/image.png?ar=1.778%3A1&fit=crop"
+	assert.Equal(t, expected, u.CreateURL("image.png", AspectRatio(16.0/9.0, 1), Param("fit", "crop")))
+}
+
+func TestParams_FocalPoint(t *testing.T) {
+	u := testBuilder()
+	expected := "https://test.imgix.net/image.png?crop=focalpoint&fit=crop&fp-x=0.5&fp-y=0.333"
+	actual := u.CreateURL("image.png",
+		FocalPoint(0.500, 1.0/3.0),
+		Param("crop", "focalpoint"),
+		Param("fit", "crop"))
+	assert.Equal(t, expected, actual)
+}
+
+func TestParams_Format(t *testing.T) {
+	u := testBuilder()
+	assert.Equal(t, "https://test.imgix.net/image.png?fm=webp", u.CreateURL("image.png", Format(WebP)))
+	assert.Equal(t, "https://test.imgix.net/image.png?fm=pjpg", u.CreateURL("image.png", Format(PJPG)))
+}
+
+func TestParams_FormatQualityPJPG(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false),
+		WithFormatQuality(map[ImageFormat]int{JPG: 75, PJPG: 70}))
+	assert.Equal(t, "https://test.imgix.net/image.png?fm=pjpg&q=70", u.CreateURL("image.png", Format(PJPG)))
+}
+
+func TestParams_formatFromPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected ImageFormat
+	}{
+		{"image.png", PNG},
+		{"/photos/image.JPEG", JPG},
+		{"image.jpg", JPG},
+		{"image.webp", WebP},
+		{"image.tiff", ""},
+		{"image", ""},
+		{"https://example.com/image.gif?v=1", GIF},
+		{"/https%3A%2F%2Fexample.com%2Fimage.avif", AVIF},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, formatFromPath(test.path), test.path)
+	}
+}
+
+func TestParams_ChromaSubAndColorQuant(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithValidation(ValidationStrict))
+	actual, err := u.CreateURLE("image.jpg", ChromaSub(444), ColorQuant(256))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://test.imgix.net/image.jpg?chromasub=444&colorquant=256", actual)
+
+	for _, param := range []IxParam{ChromaSub(421), ColorQuant(1), ColorQuant(257), Param("colorquant", "many")} {
+		_, err = u.CreateURLE("image.jpg", param)
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, invalidValueRule, err.(ParamWarning).Rule)
+	}
+}
+
+func TestParams_TextOverlay(t *testing.T) {
+	u := testBuilder()
+	tests := []struct {
+		lines    []string
+		expected string
+	}{
+		{[]string{"Hello"}, "Hello"},
+		{[]string{"Hello", "World"}, "Hello\nWorld"},
+		{[]string{"Hello", "", "World"}, "Hello\n\nWorld"},
+		{[]string{"Hello", "World", ""}, "Hello\nWorld\n"},
+		{[]string{"こんにちは", "世界"}, "こんにちは\n世界"},
+	}
+
+	for _, test := range tests {
+		parsed, err := ParseURL(u.CreateURL("~text", TextOverlay(test.lines...)))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, test.expected, parsed.Params.Get("txt64"))
+	}
+
+	expected := "https://test.imgix.net/~text?txt64=SGVsbG8KV29ybGQ"
+	assert.Equal(t, expected, u.CreateURL("~text", TextOverlay("Hello", "World")))
+}
+
+func TestParams_txtNewlinesArePercentEncoded(t *testing.T) {
+	u := testBuilder()
+	expected := "https://test.imgix.net/~text?txt=Hello%0AWorld"
+	assert.Equal(t, expected, u.CreateURL("~text", Param("txt", "Hello\nWorld")))
+}
+
+func TestParams_RichText(t *testing.T) {
+	u := testBuilder()
+	params, err := RichText{
+		Text:      "Hello",
+		Clip:      []TextClip{ClipEnd, ClipEllipsis},
+		Lead:      4,
+		Track:     -2,
+		Width:     600,
+		Line:      2,
+		LineColor: "FF0000",
+	}.Params()
+	assert.Equal(t, nil, err)
+
+	expected := "https://test.imgix.net/~text?txt-clip=end%2Cellipsis&txt-lead=4" +
+		"&txt-line=2&txt-line-color=FF0000&txt-track=-2&txt-width=600&txt64=SGVsbG8"
+	assert.Equal(t, expected, u.CreateURL("~text", params...))
+}
+
+func TestParams_RichTextInvalid(t *testing.T) {
+	invalid := []RichText{
+		{},
+		{Text: "Hello", Clip: []TextClip{"left"}},
+		{Text: "Hello", Lead: -1},
+		{Text: "Hello", Width: -1},
+		{Text: "Hello", Line: -1},
+		{Text: "Hello", Line: 2, LineColor: "red"},
+		{Text: "Hello", LineColor: "FF0000"},
+	}
+
+	for _, r := range invalid {
+		params, err := r.Params()
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, 0, len(params))
+	}
+}
+
+func TestParams_Watermark(t *testing.T) {
+	u := testBuilder()
+	params, err := Watermark{
+		Source: "https://assets.imgix.net/logo.png",
+		Align:  []Alignment{AlignBottom, AlignRight},
+		Pad:    20,
+		Width:  0.25,
+		Height: 80,
+		Fit:    FitMax,
+	}.Params()
+	assert.Equal(t, nil, err)
+
+	expected := "https://test.imgix.net/image.png?mark-align=bottom%2Cright&mark-fit=max" +
+		"&mark-h=80&mark-pad=20&mark-w=0.25&mark64=aHR0cHM6Ly9hc3NldHMuaW1naXgubmV0L2xvZ28ucG5n"
+	assert.Equal(t, expected, u.CreateURL("image.png", params...))
+
+	params, err = Watermark{Source: "logo.png", Scale: 30}.Params()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://test.imgix.net/image.png?mark-scale=30&mark64=bG9nby5wbmc",
+		u.CreateURL("image.png", params...))
+}
+
+func TestParams_WatermarkInvalid(t *testing.T) {
+	invalid := []Watermark{
+		{},
+		{Source: "logo.png", Align: []Alignment{"upper"}},
+		{Source: "logo.png", Pad: -1},
+		{Source: "logo.png", Width: -1},
+		{Source: "logo.png", Scale: 101},
+		{Source: "logo.png", Scale: 30, Width: 100},
+		{Source: "logo.png", Fit: FitFaceArea},
+	}
+
+	for _, m := range invalid {
+		params, err := m.Params()
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, 0, len(params))
+	}
+}
+
+func TestParams_Bust(t *testing.T) {
+	u := NewURLBuilder("my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithUnsignedTrailingParams(url.Values{"utm_source": {"email"}}))
+
+	expected := "https://my-social-network.imgix.net/users/1.png?v=1700000000&w=400&s=" +
+		createMd5Signature("FOO123bar", "/users/1.png", "v=1700000000&w=400") +
+		"&utm_source=email"
+	assert.Equal(t, expected, u.CreateURL("users/1.png", Param("w", "400"), Bust("1700000000")))
+
+	// A new cache-buster yields a new signature.
+	busted := u.CreateURL("users/1.png", Param("w", "400"), Bust("1700000001"))
+	assert.NotEqual(t, expected, busted)
+	assert.Contains(t, busted, "&s="+createMd5Signature("FOO123bar", "/users/1.png", "v=1700000001&w=400"))
+}
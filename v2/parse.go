@@ -0,0 +1,333 @@
+package imgix
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ParsedURL is the result of parsing an imgix URL with ParseURL.
+type ParsedURL struct {
+	Scheme string // The URL's scheme, e.g. https.
+	Domain string // The URL's host, e.g. example.imgix.net.
+
+	// Path is the unescaped path, e.g. /users/1.png. For proxy URLs,
+	// this is the source prefixed by a slash.
+	Path string
+
+	IsProxy bool   // Denotes whether or not the path is a proxy source.
+	Source  string // The unescaped proxy source, if IsProxy.
+
+	// Params are the URL's params, excluding the signature. The values
+	// of base64 params (e.g. txt64) are decoded.
+	Params url.Values
+
+	Signature string // The value of the s param, if any.
+}
+
+// ParseURL parses an imgix URL (e.g. one created by CreateURL) into its
+// components, unescaping the path and decoding base64 params.
+func ParseURL(raw string) (ParsedURL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ParsedURL{}, fmt.Errorf("failed to parse imgix URL %s due to %w", raw, err)
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return ParsedURL{}, fmt.Errorf("failed to parse query of imgix URL %s due to %w", raw, err)
+	}
+
+	parsed := ParsedURL{
+		Scheme:    u.Scheme,
+		Domain:    u.Host,
+		Path:      u.Path,
+		Params:    url.Values{},
+		Signature: query.Get("s")}
+	query.Del("s")
+
+	if isProxy, _ := checkProxyStatus(u.Path); isProxy {
+		parsed.IsProxy = true
+		parsed.Source = strings.TrimPrefix(u.Path, "/")
+	}
+
+	for k, values := range query {
+		for _, v := range values {
+			if isBase64(k) {
+				decoded, err := DecodeBase64Param(v)
+				if err != nil {
+					return ParsedURL{}, fmt.Errorf("failed to decode `%s` param due to %w", k, err)
+				}
+				v = decoded
+			}
+			parsed.Params.Add(k, v)
+		}
+	}
+	return parsed, nil
+}
+
+// OriginURL returns the URL of the untransformed image at its origin
+// for the imgix URL raw, e.g. to link to the origin directly when imgix
+// is unavailable. For a proxy URL, the origin URL is its (decoded)
+// source, and the originBase is ignored. Otherwise, the origin URL is
+// the URL's path under the originBase, e.g. the imgix URL
+// https://example.imgix.net/users/1.png?w=400 under the originBase
+// https://bucket.s3.amazonaws.com/images becomes
+// https://bucket.s3.amazonaws.com/images/users/1.png. The path is kept
+// escaped as it is in raw, and all params are dropped.
+//
+// OriginURL knows nothing of the builder that created raw, so a base
+// path or a signed path prefix (see WithBasePath and
+// WithSignedPathPrefix) is kept in the origin URL's path, and a proxy
+// URL with either isn't recognized as one. Use Describe, which strips
+// them, to recover the image's path.
+//
+// An error is returned if raw can't be parsed or if the originBase, when
+// it is needed, isn't an absolute URL without a query.
+func OriginURL(raw string, originBase string) (string, error) {
+	parsed, err := ParseURL(raw)
+	if err != nil {
+		return "", err
+	}
+	if parsed.IsProxy {
+		return parsed.Source, nil
+	}
+
+	base, err := url.Parse(originBase)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse origin base %s due to %w", originBase, err)
+	}
+	if base.Scheme == "" || base.Host == "" || base.RawQuery != "" || base.Fragment != "" {
+		return "", fmt.Errorf("origin base must be an absolute URL without a query, found `%s`", originBase)
+	}
+
+	// ParseURL succeeded, so raw parses.
+	u, _ := url.Parse(raw)
+	return strings.TrimSuffix(originBase, "/") + u.EscapedPath(), nil
+}
+
+// URLDescription describes the URL that a URLBuilder creates for a path
+// and params, e.g. for a dev panel that shows what an image will render.
+type URLDescription struct {
+	// OriginPath is the unescaped path of the image at its origin, e.g.
+	// /users/1.png. For proxy URLs, this is the source URL itself.
+	OriginPath string
+
+	IsProxy  bool // Denotes whether or not the path is a proxy source.
+	IsSigned bool // Denotes whether or not the URL is signed.
+
+	// Params are the URL's params, as imgix receives them (i.e. including
+	// any the builder adds, such as ixlib) but excluding the signature.
+	// The values of base64 params (e.g. txt64) are decoded.
+	Params url.Values
+
+	URL string // The URL, as CreateURL creates it.
+}
+
+// Describe describes the URL that CreateURL creates for the path and
+// params (see URLDescription). The URL is built and then parsed with
+// ParseURL, so the description reflects the URL exactly, except that the
+// builder's signed path prefix and base path (see WithSignedPathPrefix
+// and WithBasePath) are stripped from the OriginPath. The params passed
+// to this method are not modified.
+func (b *URLBuilder) Describe(path string, params url.Values) URLDescription {
+	u := b.createURLFromValues(path, cloneValues(params))
+	description := URLDescription{URL: u}
+
+	parsed, err := ParseURL(b.stripPathPrefixes(u))
+	if err != nil {
+		return description
+	}
+
+	description.OriginPath = parsed.Path
+	if parsed.IsProxy {
+		description.OriginPath = parsed.Source
+	}
+	description.IsProxy = parsed.IsProxy
+	description.IsSigned = parsed.Signature != ""
+	description.Params = parsed.Params
+	return description
+}
+
+// stripPathPrefixes returns the URL u without the builder's signed path
+// prefix and base path, so that its path is the image's path (e.g. the
+// path of https://example.com/cdn/imgix/users/1.png is /users/1.png for a
+// builder with the prefix /cdn and the base path /imgix).
+func (b *URLBuilder) stripPathPrefixes(u string) string {
+	prefix := b.displayPrefix + b.basePath
+	if prefix == "" {
+		return u
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	escaped := parsed.EscapedPath()
+	if !strings.HasPrefix(escaped, prefix+"/") {
+		return u
+	}
+	escaped = strings.TrimPrefix(escaped, prefix)
+
+	unescaped, err := url.PathUnescape(escaped)
+	if err != nil {
+		return u
+	}
+	parsed.Path = unescaped
+	parsed.RawPath = escaped
+	return parsed.String()
+}
+
+// ParsePreset parses a preset, a compact description of a transform
+// (e.g. one stored in a CMS), into params. A preset is a comma-delimited
+// list of key=value pairs, e.g. "w=800,fit=crop,q=70". Since the values
+// of set-params are comma-delimited too, a segment without an '=' is a
+// member of the previous param's value: "w=800,auto=format,compress"
+// sets w to 800 and auto to "format,compress". Whitespace around each
+// segment is ignored, and a value is everything after the first '=' of
+// its pair.
+//
+// An error is returned if a key is repeated, or isn't a valid param name
+// (i.e. lowercase letters, digits, and hyphens), if the preset starts
+// with a segment without an '=', or if a segment is empty (e.g. the
+// trailing one of "w=800,"). The values aren't validated; that's
+// left to the builder (see WithValidation).
+func ParsePreset(s string) (url.Values, error) {
+	params := url.Values{}
+	if strings.TrimSpace(s) == "" {
+		return params, nil
+	}
+
+	var key string
+	for _, segment := range strings.Split(s, ",") {
+		segment = strings.TrimSpace(segment)
+		if segment == "" {
+			return nil, fmt.Errorf("preset `%s` has an empty segment", s)
+		}
+
+		i := strings.IndexByte(segment, '=')
+		if i < 0 {
+			if key == "" {
+				return nil, fmt.Errorf("preset must start with a key=value pair, found `%s`", segment)
+			}
+			params[key][0] += "," + segment
+			continue
+		}
+
+		key = strings.TrimSpace(segment[:i])
+		if !isParamName(key) {
+			return nil, fmt.Errorf("preset key `%s` is not a valid param name", key)
+		}
+		if _, ok := params[key]; ok {
+			return nil, fmt.Errorf("preset key `%s` is repeated", key)
+		}
+		params.Set(key, strings.TrimSpace(segment[i+1:]))
+	}
+	return params, nil
+}
+
+// isParamName reports whether the key is a valid param name, i.e. a
+// non-empty string of lowercase letters, digits, and hyphens.
+func isParamName(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodeBase64Param decodes the value of a base64 param (e.g. txt64),
+// reversing base64EncodeQueryParamValue. Unpadded values are re-padded
+// before they're decoded.
+func DecodeBase64Param(value string) (string, error) {
+	if rem := len(value) % 4; rem != 0 {
+		value += strings.Repeat("=", 4-rem)
+	}
+
+	decoded, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// ParamChange describes a param whose value differs between two URLs.
+type ParamChange struct {
+	From string
+	To   string
+}
+
+// ParamDiff describes the differences between the params of two URLs.
+// Each param's values are normalized and joined by commas (e.g.
+// auto=compress,format).
+type ParamDiff struct {
+	Added   map[string]string      // Params only present in the second URL.
+	Removed map[string]string      // Params only present in the first URL.
+	Changed map[string]ParamChange // Params whose values differ.
+}
+
+// Empty reports whether the diff contains no differences.
+func (d ParamDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffURLs parses the imgix URLs a and b and reports the differences
+// between their effective transforms. Base64 params are decoded, set-
+// params (e.g. auto) are normalized so that the order of their members
+// doesn't matter, and the s and ixlib params are ignored.
+func DiffURLs(a, b string) (ParamDiff, error) {
+	parsedA, err := ParseURL(a)
+	if err != nil {
+		return ParamDiff{}, err
+	}
+	parsedB, err := ParseURL(b)
+	if err != nil {
+		return ParamDiff{}, err
+	}
+
+	paramsA := diffableParams(parsedA.Params)
+	paramsB := diffableParams(parsedB.Params)
+
+	diff := ParamDiff{
+		Added:   map[string]string{},
+		Removed: map[string]string{},
+		Changed: map[string]ParamChange{}}
+
+	for k, valueA := range paramsA {
+		valueB, ok := paramsB[k]
+		switch {
+		case !ok:
+			diff.Removed[k] = valueA
+		case valueA != valueB:
+			diff.Changed[k] = ParamChange{From: valueA, To: valueB}
+		}
+	}
+
+	for k, valueB := range paramsB {
+		if _, ok := paramsA[k]; !ok {
+			diff.Added[k] = valueB
+		}
+	}
+	return diff, nil
+}
+
+// diffableParams canonicalizes the params and joins each param's values,
+// excluding the params ignored by cache keys (i.e. s and ixlib).
+func diffableParams(params url.Values) map[string]string {
+	canonical := canonicalizeParams(params, SortedMembers)
+	for _, k := range cacheKeyExcludedParams {
+		canonical.Del(k)
+	}
+
+	joined := make(map[string]string, len(canonical))
+	for k, v := range canonical {
+		joined[k] = strings.Join(v, ",")
+	}
+	return joined
+}
@@ -0,0 +1,133 @@
+package imgix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// lqipParams are the params applied to the base params to create a
+// low-quality image placeholder (LQIP): a tiny, heavily blurred, and
+// heavily compressed version of the image.
+var lqipParams = url.Values{
+	"w":    {"32"},
+	"blur": {"200"},
+	"q":    {"20"},
+}
+
+// Placeholder creates the URLs used by the two-stage, "blur-up" loading
+// pattern. The lqipURL is a low-quality image placeholder (the image at
+// w=32, blur=200, and q=20, which replace any of those params given) to
+// display while the full image loads. So that the placeholder keeps the
+// image's shape at its tiny size, an absolute h is scaled along with the
+// w (e.g. w=800&h=600 becomes w=32&h=24), any other h is dropped, and
+// so is the dpr. The dominantColorURL requests the image's palette
+// (palette=json&colors=1), from which the dominant color can be used as
+// an instant background.
+//
+// Note that the caller must fetch the dominantColorURL to get the actual
+// color. Both URLs are signed if the builder has a token, and the params
+// passed to this method are not modified.
+func (b *URLBuilder) Placeholder(path string, params url.Values) (lqipURL string, dominantColorURL string) {
+	lqip := canonicalNames(params)
+	lqip.Del("dpr")
+	scaleLQIPHeight(lqip)
+	for k, v := range lqipParams {
+		lqip[k] = append([]string(nil), v...)
+	}
+	lqipURL = b.createURLFromValues(path, lqip)
+
+	palette := cloneValues(params)
+	palette.Set("palette", "json")
+	palette.Set("colors", "1")
+	dominantColorURL = b.createURLFromValues(path, palette)
+	return lqipURL, dominantColorURL
+}
+
+// scaleLQIPHeight scales an absolute h by the same factor as the LQIP
+// scales an absolute w, rounding to the nearest pixel (but at least
+// one). If either isn't an absolute pixel size, the h is dropped and the
+// LQIP's shape follows from its w (and ar, if any). The params are
+// modified in place.
+func scaleLQIPHeight(params url.Values) {
+	if params.Get("h") == "" {
+		return
+	}
+
+	w, wErr := strconv.ParseFloat(params.Get("w"), 64)
+	h, hErr := strconv.ParseFloat(params.Get("h"), 64)
+	if wErr != nil || hErr != nil || w < 1 || h < 1 {
+		params.Del("h")
+		return
+	}
+
+	lqipWidth, _ := strconv.ParseFloat(lqipParams.Get("w"), 64)
+	scaled := math.Max(1, math.Round(h*lqipWidth/w))
+	params.Set("h", strconv.Itoa(int(scaled)))
+}
+
+// PaletteColor is a color within a Palette. The red, green, and blue
+// components range from zero to one.
+type PaletteColor struct {
+	Red   float64 `json:"red"`
+	Green float64 `json:"green"`
+	Blue  float64 `json:"blue"`
+	Hex   string  `json:"hex"`
+}
+
+// Palette is the (partial) response imgix returns for palette=json,
+// e.g. when fetching the dominantColorURL created by Placeholder. The
+// Colors are ordered from most to least prominent.
+type Palette struct {
+	Colors           []PaletteColor          `json:"colors"`
+	AverageLuminance float64                 `json:"average_luminance"`
+	DominantColors   map[string]PaletteColor `json:"dominant_colors"`
+}
+
+// ParsePalette parses a palette=json response body into a Palette.
+func ParsePalette(r io.Reader) (*Palette, error) {
+	var p Palette
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, fmt.Errorf("failed to parse palette due to %w", err)
+	}
+	return &p, nil
+}
+
+// hexColorPattern matches the #rgb and #rrggbb color forms.
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// hex returns the color's #rrggbb form, in lowercase. A #rgb Hex field
+// is expanded (e.g. #f80 becomes #ff8800); if the Hex field isn't a
+// valid color, the form is computed from the color's components.
+func (c PaletteColor) hex() string {
+	if hexColorPattern.MatchString(c.Hex) {
+		digits := strings.ToLower(c.Hex[1:])
+		if len(digits) == 3 {
+			digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+		}
+		return "#" + digits
+	}
+	component := func(v float64) int {
+		return int(math.Round(math.Max(0, math.Min(1, v)) * 255))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", component(c.Red), component(c.Green), component(c.Blue))
+}
+
+// DominantColorDataURI creates a data URI of a 1x1 SVG image filled with
+// the palette's dominant (i.e. first) color, suitable for an <img> src
+// placeholder that requires no further network round trips. If the
+// palette is nil or has no colors, an empty string is returned.
+func DominantColorDataURI(p *Palette) string {
+	if p == nil || len(p.Colors) == 0 {
+		return ""
+	}
+
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="1" height="1">` +
+		`<rect width="1" height="1" fill="` + p.Colors[0].hex() + `"/></svg>`
+	return "data:image/svg+xml," + url.PathEscape(svg)
+}
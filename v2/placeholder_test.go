@@ -0,0 +1,94 @@
+package imgix
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceholder_Placeholder(t *testing.T) {
+	u := testBuilder()
+	params := url.Values{"fit": {"crop"}, "ar": {"1:1"}, "w": {"800"}}
+	lqipURL, dominantColorURL := u.Placeholder("image.png", params)
+
+	assert.Equal(t, "https://test.imgix.net/image.png?ar=1%3A1&blur=200&fit=crop&q=20&w=32", lqipURL)
+	assert.Equal(t, "https://test.imgix.net/image.png?ar=1%3A1&colors=1&fit=crop&palette=json&w=800", dominantColorURL)
+	assert.Equal(t, url.Values{"fit": {"crop"}, "ar": {"1:1"}, "w": {"800"}}, params)
+}
+
+func TestPlaceholder_PlaceholderDimensions(t *testing.T) {
+	u := testBuilder()
+	tests := []struct {
+		params   url.Values
+		expected string
+	}{
+		// The h is scaled along with the w, and the dpr is dropped.
+		{url.Values{"w": {"800"}, "h": {"600"}, "dpr": {"2"}}, "blur=200&h=24&q=20&w=32"},
+		{url.Values{"width": {"1600"}, "height": {"10"}}, "blur=200&h=1&q=20&w=32"},
+		// An h without an absolute w would stretch the LQIP.
+		{url.Values{"h": {"600"}}, "blur=200&q=20&w=32"},
+		{url.Values{"w": {"0.5"}, "h": {"600"}}, "blur=200&q=20&w=32"},
+		{url.Values{"w": {"800"}, "h": {"0.5"}}, "blur=200&q=20&w=32"},
+	}
+
+	for _, test := range tests {
+		lqipURL, _ := u.Placeholder("image.png", test.params)
+		assert.Equal(t, "https://test.imgix.net/image.png?"+test.expected, lqipURL)
+	}
+
+	// The dominant color URL keeps the params as given.
+	_, dominantColorURL := u.Placeholder("image.png", url.Values{"w": {"800"}, "h": {"600"}, "dpr": {"2"}})
+	assert.Equal(t, "https://test.imgix.net/image.png?colors=1&dpr=2&h=600&palette=json&w=800", dominantColorURL)
+}
+
+func TestPlaceholder_PlaceholderSigned(t *testing.T) {
+	u := NewURLBuilder("my-social-network.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	lqipURL, dominantColorURL := u.Placeholder("image.png", nil)
+
+	expectedLQIP := "https://my-social-network.imgix.net/image.png?blur=200&q=20&w=32&s=" +
+		createMd5Signature("FOO123bar", "/image.png", "blur=200&q=20&w=32")
+	assert.Equal(t, expectedLQIP, lqipURL)
+
+	expectedPalette := "https://my-social-network.imgix.net/image.png?colors=1&palette=json&s=" +
+		createMd5Signature("FOO123bar", "/image.png", "colors=1&palette=json")
+	assert.Equal(t, expectedPalette, dominantColorURL)
+}
+
+func TestPlaceholder_DominantColorDataURI(t *testing.T) {
+	body := `{"colors":[{"red":0.2,"hex":"#3d5a80","blue":0.5,"green":0.35}],` +
+		`"average_luminance":0.31,"dominant_colors":{}}`
+	p, err := ParsePalette(strings.NewReader(body))
+	assert.Equal(t, nil, err)
+
+	expected := "data:image/svg+xml," +
+		"%3Csvg%20xmlns=%22http:%2F%2Fwww.w3.org%2F2000%2Fsvg%22%20width=%221%22%20height=%221%22%3E" +
+		"%3Crect%20width=%221%22%20height=%221%22%20fill=%22%233d5a80%22%2F%3E%3C%2Fsvg%3E"
+	actual := DominantColorDataURI(p)
+	assert.Equal(t, expected, actual)
+
+	svg, err := url.PathUnescape(strings.TrimPrefix(actual, "data:image/svg+xml,"))
+	assert.Equal(t, nil, err)
+	assert.Contains(t, svg, `fill="#3d5a80"`)
+}
+
+func TestPlaceholder_DominantColorDataURIFromComponents(t *testing.T) {
+	p := &Palette{Colors: []PaletteColor{{Red: 1, Green: 0.5, Blue: 0}}}
+	assert.Contains(t, DominantColorDataURI(p), "%23ff8000")
+}
+
+func TestPlaceholder_DominantColorDataURIShortHex(t *testing.T) {
+	for _, hex := range []string{"#f80", "#F80", "#FF8800"} {
+		p := &Palette{Colors: []PaletteColor{{Hex: hex}}}
+		assert.Contains(t, DominantColorDataURI(p), "%23ff8800", hex)
+	}
+}
+
+func TestPlaceholder_DominantColorDataURIEmpty(t *testing.T) {
+	assert.Equal(t, "", DominantColorDataURI(nil))
+	assert.Equal(t, "", DominantColorDataURI(&Palette{}))
+
+	_, err := ParsePalette(strings.NewReader("not json"))
+	assert.NotEqual(t, nil, err)
+}
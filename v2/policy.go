@@ -0,0 +1,125 @@
+package imgix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// srcsetPolicy is the JSON form of SrcsetOpts read by LoadSrcsetPolicy.
+// Only the options that describe a responsive policy are included.
+type srcsetPolicy struct {
+	MinWidth       int            `json:"minWidth"`
+	MaxWidth       int            `json:"maxWidth"`
+	Tolerance      float64        `json:"tolerance"`
+	MaxCandidates  int            `json:"maxCandidates,omitempty"`
+	DescriptorKind DescriptorKind `json:"descriptorKind"`
+	Widths         []int          `json:"widths,omitempty"`
+	DprQualities   map[int]int    `json:"dprQualities,omitempty"`
+}
+
+// LoadSrcsetPolicy reads a responsive policy from a JSON document, such
+// as:
+//
+//	{
+//		"minWidth": 320,
+//		"maxWidth": 2560,
+//		"tolerance": 0.1,
+//		"maxCandidates": 8,
+//		"descriptorKind": "width",
+//		"dprQualities": {"1": 80, "2": 60, "3": 40}
+//	}
+//
+// Omitted fields keep their defaults. The descriptorKind is either
+// "width" or "dpr" (see DescriptorKind), the optional widths replace the
+// widths generated from the width-range (see WithWidths), and the
+// dprQualities are keyed by dpr (see WithDprQualities). Since dpr
+// descriptors only describe explicit widths, a "dpr" policy must list
+// its widths. The resulting options are validated (see
+// SrcsetOpts.Validate) before they are returned; apply them to a srcset
+// with WithPolicy. SrcsetOpts can be marshaled back into the same form
+// with json.Marshal.
+func LoadSrcsetPolicy(r io.Reader) (SrcsetOpts, error) {
+	opts := newSrcsetOpts()
+	policy := opts.policy()
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return SrcsetOpts{}, fmt.Errorf("invalid srcset policy: %w", err)
+	}
+	if policy.DescriptorKind == DPRDescriptor && policy.Widths == nil {
+		return SrcsetOpts{}, errors.New("invalid srcset policy: `descriptorKind` dpr requires `widths`")
+	}
+
+	opts.applyPolicy(policy)
+	if err := opts.Validate(); err != nil {
+		return SrcsetOpts{}, fmt.Errorf("invalid srcset policy: %w", err)
+	}
+	return opts, nil
+}
+
+// WithPolicy returns a SrcsetOption that sets the options of a
+// responsive policy (see LoadSrcsetPolicy) at once. Only the options
+// that a policy describes are set, so options that follow WithPolicy
+// (e.g. WithSeparator) still apply.
+func WithPolicy(policy SrcsetOpts) SrcsetOption {
+	return func(s *SrcsetOpts) {
+		s.applyPolicy(policy.policy())
+	}
+}
+
+// applyPolicy sets the options that the policy describes.
+func (o *SrcsetOpts) applyPolicy(policy srcsetPolicy) {
+	o.minWidth = policy.MinWidth
+	o.maxWidth = policy.MaxWidth
+	o.tolerance = policy.Tolerance
+	o.maxCandidates = policy.MaxCandidates
+	o.descriptorKind = policy.DescriptorKind
+	o.widths = policy.Widths
+	o.dprQualities = policy.DprQualities
+}
+
+// MarshalJSON encodes the options as a responsive policy that can be
+// read by LoadSrcsetPolicy.
+func (o SrcsetOpts) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.policy())
+}
+
+// policy returns the srcsetPolicy form of the options.
+func (o SrcsetOpts) policy() srcsetPolicy {
+	return srcsetPolicy{
+		MinWidth:       o.minWidth,
+		MaxWidth:       o.maxWidth,
+		Tolerance:      o.tolerance,
+		MaxCandidates:  o.maxCandidates,
+		DescriptorKind: o.descriptorKind,
+		Widths:         o.widths,
+		DprQualities:   o.dprQualities,
+	}
+}
+
+// MarshalText encodes the DescriptorKind as "width" or "dpr".
+func (k DescriptorKind) MarshalText() ([]byte, error) {
+	switch k {
+	case WidthDescriptor:
+		return []byte("width"), nil
+	case DPRDescriptor:
+		return []byte("dpr"), nil
+	}
+	return nil, fmt.Errorf("unknown descriptor kind `%d`", int(k))
+}
+
+// UnmarshalText decodes a DescriptorKind from "width" or "dpr".
+func (k *DescriptorKind) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "width":
+		*k = WidthDescriptor
+	case "dpr":
+		*k = DPRDescriptor
+	default:
+		return fmt.Errorf("descriptor kind must be one of width or dpr, found `%s`", text)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package imgix
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSrcsetPolicy = `{
+	"minWidth": 320,
+	"maxWidth": 2560,
+	"tolerance": 0.1,
+	"maxCandidates": 8,
+	"descriptorKind": "width",
+	"dprQualities": {"1": 80, "2": 60, "3": 40}
+}`
+
+func TestPolicy_LoadSrcsetPolicy(t *testing.T) {
+	opts, err := LoadSrcsetPolicy(strings.NewReader(testSrcsetPolicy))
+	assert.Equal(t, nil, err)
+
+	expected := newSrcsetOpts(
+		WithMinWidth(320),
+		WithMaxWidth(2560),
+		WithTolerance(0.1),
+		WithMaxCandidates(8),
+		WithDescriptorKind(WidthDescriptor),
+		WithDprQualities(map[int]int{1: 80, 2: 60, 3: 40}))
+	assert.Equal(t, expected, opts)
+	assert.Equal(t, 8, len(opts.targets()))
+}
+
+func TestPolicy_LoadSrcsetPolicyDefaults(t *testing.T) {
+	opts, err := LoadSrcsetPolicy(strings.NewReader(`{"maxWidth": 1000}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, newSrcsetOpts(WithMaxWidth(1000)), opts)
+}
+
+func TestPolicy_SrcsetPolicyRoundTrip(t *testing.T) {
+	opts := newSrcsetOpts(
+		WithMinWidth(200),
+		WithMaxWidth(1200),
+		WithTolerance(0.2),
+		WithMaxCandidates(4),
+		WithDescriptorKind(DPRDescriptor),
+		WithWidths(400, 800),
+		WithDprQualities(map[int]int{1: 70, 2: 45}))
+
+	data, err := json.Marshal(opts)
+	assert.Equal(t, nil, err)
+
+	loaded, err := LoadSrcsetPolicy(strings.NewReader(string(data)))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, opts, loaded)
+}
+
+func TestPolicy_LoadSrcsetPolicyInvalid(t *testing.T) {
+	invalid := []string{
+		`not json`,
+		`{"minWidth": 900, "maxWidth": 300}`,
+		`{"tolerance": 0.001}`,
+		`{"maxCandidates": -1}`,
+		`{"descriptorKind": "height"}`,
+		`{"dprQualities": {"6": 20}}`,
+		`{"dprQualities": {"1": 101}}`,
+		`{"maxWidths": 1000}`,
+		`{"descriptorKind": "dpr"}`,
+		`{"descriptorKind": "dpr", "widths": [800, 400]}`,
+		`{"widths": [100, 9000]}`,
+	}
+
+	for _, policy := range invalid {
+		_, err := LoadSrcsetPolicy(strings.NewReader(policy))
+		assert.NotEqual(t, nil, err)
+		assert.Contains(t, err.Error(), "invalid srcset policy")
+	}
+}
+
+func TestPolicy_WithPolicy(t *testing.T) {
+	c := testClient()
+	policy, err := LoadSrcsetPolicy(strings.NewReader(`{"minWidth": 320, "maxWidth": 2560, "maxCandidates": 3}`))
+	assert.Equal(t, nil, err)
+
+	expected := c.CreateSrcset("image.png", nil, WithMinWidth(320), WithMaxWidth(2560), WithMaxCandidates(3))
+	assert.Equal(t, expected, c.CreateSrcset("image.png", nil, WithPolicy(policy)))
+	assert.Equal(t, 3, len(strings.Split(expected, ",\n")))
+
+	// Options that follow the policy still apply.
+	srcset := c.CreateSrcset("image.png", nil, WithPolicy(policy), WithSeparator(", "))
+	assert.Equal(t, strings.Replace(expected, ",\n", ", ", -1), srcset)
+}
+
+func TestPolicy_WithPolicyDpr(t *testing.T) {
+	c := testClient()
+	policy, err := LoadSrcsetPolicy(strings.NewReader(`{"descriptorKind": "dpr", "widths": [400, 800]}`))
+	assert.Equal(t, nil, err)
+
+	expected := "https://test.imgix.net/image.png?w=400 1x,\n" +
+		"https://test.imgix.net/image.png?w=800 2x"
+	assert.Equal(t, expected, c.CreateSrcset("image.png", nil, WithPolicy(policy)))
+
+	// A dpr policy without widths has nothing to describe.
+	_, err = LoadSrcsetPolicy(strings.NewReader(`{"descriptorKind": "dpr"}`))
+	assert.EqualError(t, err, "invalid srcset policy: `descriptorKind` dpr requires `widths`")
+}
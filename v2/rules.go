@@ -0,0 +1,366 @@
+package imgix
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ValidationMode determines how a URLBuilder responds to params that
+// violate one of its ParamRules.
+type ValidationMode int
+
+const (
+	// ValidationOff disables param validation. This is the default.
+	ValidationOff ValidationMode = iota
+
+	// ValidationWarn logs a warning for every rule the params violate.
+	ValidationWarn
+
+	// ValidationStrict causes CreateURLE to return an error for the
+	// first rule the params violate. Methods that cannot return an
+	// error (e.g. CreateURL) log a warning instead.
+	ValidationStrict
+)
+
+// ParamRule describes a param combination that is known to misbehave.
+// Rules are data: the ConflictRules below are exported so that they can
+// be inspected, and callers can supply their own via WithParamRules.
+type ParamRule struct {
+	// Name is a short, unique identifier for the rule.
+	Name string
+
+	// Keys are the param keys involved in the conflict.
+	Keys []string
+
+	// Violated reports whether params violate the rule.
+	Violated func(params url.Values) bool
+
+	// Suggestion describes how to fix the conflict.
+	Suggestion string
+}
+
+// ParamWarning is produced when params violate a ParamRule. In
+// ValidationStrict mode it is returned as an error.
+type ParamWarning struct {
+	Rule       string
+	Keys       []string
+	Suggestion string
+}
+
+// Error returns the warning's message, naming the offending keys, the
+// violated rule, and the suggested fix.
+func (w ParamWarning) Error() string {
+	return fmt.Sprintf("imgix: params %s violate %s: %s",
+		strings.Join(w.Keys, ", "), w.Rule, w.Suggestion)
+}
+
+// Unwrap returns ErrValueOutOfRange if the warning reports an invalid
+// param value (see ValueValidators), so that errors.Is can distinguish it
+// from a conflict between params. Otherwise, it returns nil.
+func (w ParamWarning) Unwrap() error {
+	if w.Rule == invalidValueRule {
+		return ErrValueOutOfRange
+	}
+	return nil
+}
+
+// invalidValueRule is the name of the rule reported when a param value
+// fails its ValueValidators check.
+const invalidValueRule = "invalid-value"
+
+// ValueValidators maps param keys to functions that validate their
+// values. Like the ConflictRules, they are checked when validation is
+// enabled, and entries can be added before a builder is used.
+var ValueValidators = map[string]func(value string) error{
+	"chromasub":  validateChromaSub,
+	"colorquant": validateColorQuant,
+	"q":          validateQuality,
+}
+
+// KnownParams are the param keys that validation recognizes in addition
+// to those of LogicalParamOrder, ParamAliases, ValueValidators, and the
+// set-params (see RegisterSetParam). When validation is enabled, any
+// other key is reported as a ParamError that wraps ErrUnknownParam, e.g.
+// for a misspelt wdith. Keys can be added (e.g. for params that are
+// newer than the library) before a builder is used.
+var KnownParams = map[string]bool{
+	"ixlib":        true,
+	"s":            true,
+	"v":            true,
+	"exp":          true,
+	"url64":        true,
+	"palette":      true,
+	"colors":       true,
+	"prefix":       true,
+	"frame":        true,
+	"page":         true,
+	"vid-start":    true,
+	"vid-end":      true,
+	"vid-fps":      true,
+	"fill":         true,
+	"fill-color":   true,
+	"bg-remove":    true,
+	"bg-replace":   true,
+	"upscale":      true,
+	"mark-base":    true,
+	"mark-rot":     true,
+	"blend-color":  true,
+	"txt-ellipsis": true,
+}
+
+// isKnownParam reports whether validation recognizes the param key (see
+// KnownParams).
+func isKnownParam(key string) bool {
+	if KnownParams[key] || isSetParam(key) {
+		return true
+	}
+	if _, ok := ParamAliases[key]; ok {
+		return true
+	}
+	if _, ok := ValueValidators[key]; ok {
+		return true
+	}
+	for _, k := range logicalParamKeys() {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownParams returns a ParamError that wraps ErrUnknownParam for each
+// param whose key validation doesn't recognize, in key order.
+func unknownParams(params url.Values) []*ParamError {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []*ParamError
+	for _, k := range keys {
+		if !isKnownParam(k) {
+			errs = append(errs, &ParamError{Param: k, Value: params.Get(k), Err: ErrUnknownParam})
+		}
+	}
+	return errs
+}
+
+// ConflictRules is the default set of ParamRules that are checked when
+// validation is enabled.
+var ConflictRules = []ParamRule{
+	{
+		Name: "ar-without-fit-crop",
+		Keys: []string{"ar", "fit"},
+		Violated: func(params url.Values) bool {
+			return params.Get("ar") != "" && params.Get("fit") != "crop"
+		},
+		Suggestion: "`ar` is only applied when `fit=crop`; set `fit=crop`",
+	},
+	{
+		Name: "crop-without-fit-crop",
+		Keys: []string{"crop", "fit"},
+		Violated: func(params url.Values) bool {
+			return params.Get("crop") != "" && params.Get("fit") != "crop"
+		},
+		Suggestion: "`crop` is only applied when `fit=crop`; set `fit=crop`",
+	},
+	{
+		Name: "focalpoint-without-crop-focalpoint",
+		Keys: []string{"fp-x", "fp-y", "crop"},
+		Violated: func(params url.Values) bool {
+			hasFocalPoint := params.Get("fp-x") != "" || params.Get("fp-y") != ""
+			return hasFocalPoint && !hasSetMember(params, "crop", "focalpoint")
+		},
+		Suggestion: "`fp-x` and `fp-y` are only applied when `crop=focalpoint`; " +
+			"set `crop=focalpoint` and `fit=crop`",
+	},
+	{
+		Name: "mask-with-opaque-format",
+		Keys: []string{"mask", "fm"},
+		Violated: func(params url.Values) bool {
+			fm := ImageFormat(params.Get("fm"))
+			return params.Get("mask") != "" && (fm == JPG || fm == PJPG)
+		},
+		Suggestion: "masked areas are transparent, which `fm=jpg` cannot " +
+			"represent; use `fm=png`, `fm=webp`, or `auto=format`",
+	},
+}
+
+// NoEffectRules is the default set of ParamRules that detect redundant
+// params: params that are valid, but have no effect on the rendered
+// image in combination with the others, so removing them yields a
+// shorter URL (and fewer cache variants) for the same image. The first
+// of a rule's Keys is the redundant param. They are checked when
+// validation is enabled, but, since redundant params are harmless, their
+// warnings are only logged, even in ValidationStrict mode. Entries can
+// be added before a builder is used.
+//
+// The params of the URLs that the library derives from the caller's
+// params, e.g. the dpr=1 of a srcset's 1x candidate, aren't checked.
+// Note that dpr with an absolute w and h isn't redundant, since imgix
+// scales both by the dpr (see DevicePixels), so there is no rule for it.
+var NoEffectRules = []ParamRule{
+	{
+		Name: "q-with-lossless-output",
+		Keys: []string{"q", "fm", "lossless"},
+		Violated: func(params url.Values) bool {
+			lossless := params.Get("lossless") == "1" || params.Get("lossless") == "true"
+			isLossless := ImageFormat(params.Get("fm")) == PNG || lossless
+			return params.Get("q") != "" && isLossless && !hasSetMember(params, "auto", "format")
+		},
+		Suggestion: "`q` has no effect on lossless output; remove `q`",
+	},
+	{
+		Name: "ar-with-fit-max",
+		Keys: []string{"ar", "fit"},
+		Violated: func(params url.Values) bool {
+			return params.Get("ar") != "" && params.Get("fit") == "max"
+		},
+		Suggestion: "`fit=max` preserves the image's own aspect ratio, so `ar` has " +
+			"no effect; remove `ar`",
+	},
+	{
+		Name: "default-dpr",
+		Keys: []string{"dpr"},
+		Violated: func(params url.Values) bool {
+			return canonicalizeDecimal(params.Get("dpr")) == "1"
+		},
+		Suggestion: "`dpr=1` is the default; remove `dpr`",
+	},
+}
+
+// WithValidation returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set the URLBuilder's validation
+// mode (see ValidationMode).
+func WithValidation(mode ValidationMode) BuilderOption {
+	return func(b *URLBuilder) {
+		b.validationMode = mode
+	}
+}
+
+// WithParamRules returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to add rules that are checked in
+// addition to the ConflictRules when validation is enabled.
+func WithParamRules(rules ...ParamRule) BuilderOption {
+	return func(b *URLBuilder) {
+		b.paramRules = append(b.paramRules, rules...)
+	}
+}
+
+// CheckParams checks params against each of the rules and returns a
+// ParamWarning for every rule that is violated, in rule order.
+func CheckParams(params url.Values, rules []ParamRule) []ParamWarning {
+	var warnings []ParamWarning
+	for _, rule := range rules {
+		if rule.Violated != nil && rule.Violated(params) {
+			warnings = append(warnings, ParamWarning{
+				Rule:       rule.Name,
+				Keys:       rule.Keys,
+				Suggestion: rule.Suggestion,
+			})
+		}
+	}
+	return warnings
+}
+
+// CheckParamValues validates the value of each param that has a
+// validator and returns a ParamWarning for every invalid value, in key
+// order.
+func CheckParamValues(params url.Values, validators map[string]func(value string) error) []ParamWarning {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var warnings []ParamWarning
+	for _, k := range keys {
+		validate, ok := validators[k]
+		if !ok {
+			continue
+		}
+		if err := validate(strings.Join(params[k], ",")); err != nil {
+			warnings = append(warnings, ParamWarning{
+				Rule:       invalidValueRule,
+				Keys:       []string{k},
+				Suggestion: err.Error(),
+			})
+		}
+	}
+	return warnings
+}
+
+// checkParams checks params against the builder's rules according to
+// its validation mode. Unknown params (see KnownParams) and warnings are
+// logged unless strict is true and the builder is in ValidationStrict
+// mode, in which case the first unknown param or, if there are none, the
+// first warning is returned as an error. Redundant params (see
+// NoEffectRules) are only ever logged.
+func (b *URLBuilder) checkParams(params url.Values, strict bool) error {
+	if b.validationMode == ValidationOff || b.paramsChecked {
+		return nil
+	}
+
+	unknown := unknownParams(params)
+	rules := append(append([]ParamRule{}, ConflictRules...), b.paramRules...)
+	warnings := CheckParams(params, rules)
+	warnings = append(warnings, CheckParamValues(params, ValueValidators)...)
+
+	if strict && b.validationMode == ValidationStrict {
+		if len(unknown) > 0 {
+			return unknown[0]
+		}
+		if len(warnings) > 0 {
+			return warnings[0]
+		}
+	}
+
+	for _, err := range unknown {
+		log.Println(err)
+	}
+	warnings = append(warnings, CheckParams(params, NoEffectRules)...)
+	for _, w := range warnings {
+		log.Println(w)
+	}
+	return nil
+}
+
+// checkOnce checks the params, as prepared for the path (see
+// prepareParams), against the builder's rules like CreateURL does, and
+// returns a copy of the builder that doesn't check params. Methods that
+// create several URLs from the same params (e.g. the candidates of a
+// srcset) use the copy, so that each warning is logged once per call
+// rather than once per URL.
+func (b *URLBuilder) checkOnce(path string, params url.Values) *URLBuilder {
+	if b.validationMode == ValidationOff {
+		return b
+	}
+	b.checkParams(b.prepareParams(path, params), false)
+	return b.unchecked()
+}
+
+// unchecked returns a copy of the builder that doesn't check params
+// (see checkOnce). The copy keeps its validation mode, so that it still
+// logs what it does to each URL, e.g. clamping the dpr.
+func (b *URLBuilder) unchecked() *URLBuilder {
+	unchecked := *b
+	unchecked.paramsChecked = true
+	return &unchecked
+}
+
+// hasSetMember reports whether the (possibly comma-delimited) values of
+// params[key] contain the member.
+func hasSetMember(params url.Values, key string, member string) bool {
+	for _, v := range params[key] {
+		for _, m := range strings.Split(v, ",") {
+			if strings.TrimSpace(m) == member {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package imgix
+
+import (
+	"bytes"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRules_CheckParamsNoConflicts(t *testing.T) {
+	params := url.Values{"w": {"400"}, "ar": {"16:9"}, "fit": {"crop"}}
+	assert.Equal(t, 0, len(CheckParams(params, ConflictRules)))
+}
+
+func TestRules_CheckParamsConflicts(t *testing.T) {
+	params := url.Values{
+		"ar":   {"16:9"},
+		"crop": {"faces"},
+		"fp-x": {"0.5"},
+		"mask": {"ellipse"},
+		"fm":   {"jpg"}}
+	warnings := CheckParams(params, ConflictRules)
+
+	var names []string
+	for _, w := range warnings {
+		names = append(names, w.Rule)
+	}
+	expected := []string{
+		"ar-without-fit-crop",
+		"crop-without-fit-crop",
+		"focalpoint-without-crop-focalpoint",
+		"mask-with-opaque-format"}
+	assert.Equal(t, expected, names)
+	assert.Contains(t, warnings[0].Error(), "ar, fit")
+	assert.Contains(t, warnings[0].Error(), "set `fit=crop`")
+}
+
+func TestRules_ValidationStrict(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net",
+		WithLibParam(false),
+		WithValidation(ValidationStrict))
+
+	actual, err := u.CreateURLE("image.png", Param("w", "400"), Param("ar", "16:9"))
+	assert.Equal(t, "", actual)
+	assert.Equal(t, "ar-without-fit-crop", err.(ParamWarning).Rule)
+
+	actual, err = u.CreateURLE("image.png", Param("ar", "16:9"), Param("fit", "crop"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://test.imgix.net/image.png?ar=16%3A9&fit=crop", actual)
+}
+
+func TestRules_ValidationWarn(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	u := NewURLBuilder("test.imgix.net",
+		WithLibParam(false),
+		WithValidation(ValidationWarn))
+
+	actual, err := u.CreateURLE("image.png", Param("crop", "faces"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://test.imgix.net/image.png?crop=faces", actual)
+	assert.Contains(t, buf.String(), "crop-without-fit-crop")
+}
+
+func TestRules_ValidationOncePerCall(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	u := NewURLBuilder("test.imgix.net", WithValidation(ValidationWarn))
+	params := []IxParam{Param("crop", "faces")}
+
+	calls := map[string]func(){
+		"CreateSrcset":          func() { u.CreateSrcset("image.png", params) },
+		"CreateSrcsetFixed":     func() { u.CreateSrcset("image.png", append(params, Param("w", "100"))) },
+		"CreateSrcsetFromWidth": func() { u.CreateSrcsetFromWidths("image.png", params, []int{100, 200}) },
+		"ResponsiveSet":         func() { u.ResponsiveSet("image.png", params) },
+		"PictureModernFormats":  func() { u.PictureModernFormats("image.png", params, "") },
+		"LazyImage":             func() { u.LazyImage("image.png", params, "") },
+		"FixedImage":            func() { u.FixedImage("image.png", 100, url.Values{"crop": {"faces"}}, 3) },
+		"PreloadHeader":         func() { u.PreloadHeader("image.png", url.Values{"crop": {"faces"}}, "") },
+	}
+	for name, call := range calls {
+		buf.Reset()
+		call()
+		assert.Equal(t, 1, strings.Count(buf.String(), "crop-without-fit-crop"), name)
+	}
+}
+
+func TestRules_ValidationOnceKeepsClampLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	u := NewURLBuilder("test.imgix.net",
+		WithLibParam(false),
+		WithMaxDPR(2),
+		WithValidation(ValidationWarn))
+	// The candidates' dpr isn't among the params that are checked once.
+	perWidth := WithPerWidth(func(width int, base url.Values) url.Values {
+		params := cloneValues(base)
+		params.Set("dpr", "3")
+		return params
+	})
+
+	calls := map[string]func(){
+		"CreateSrcset": func() {
+			u.CreateSrcset("image.png", []IxParam{Param("crop", "faces")}, WithWidths(100, 200), perWidth)
+		},
+		"BuildManifest": func() {
+			u.BuildManifest([]ManifestItem{{
+				Path:    "image.png",
+				Params:  url.Values{"crop": {"faces"}},
+				Options: []SrcsetOption{WithWidths(100, 200), perWidth}}})
+		},
+	}
+	for name, call := range calls {
+		buf.Reset()
+		call()
+		assert.Equal(t, 1, strings.Count(buf.String(), "crop-without-fit-crop"), name)
+		assert.Contains(t, buf.String(), "clamped `dpr=3` to the maximum dpr of 2", name)
+	}
+}
+
+func TestRules_ValidationOff(t *testing.T) {
+	u := testBuilder()
+	actual, err := u.CreateURLE("image.png", Param("ar", "16:9"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://test.imgix.net/image.png?ar=16%3A9", actual)
+}
+
+func TestRules_WithParamRules(t *testing.T) {
+	noBlur := ParamRule{
+		Name: "no-blur",
+		Keys: []string{"blur"},
+		Violated: func(params url.Values) bool {
+			return params.Get("blur") != ""
+		},
+		Suggestion: "remove `blur`",
+	}
+	u := NewURLBuilder("test.imgix.net",
+		WithValidation(ValidationStrict),
+		WithParamRules(noBlur))
+
+	_, err := u.CreateURLE("image.png", Param("blur", "200"))
+	assert.Equal(t, "no-blur", err.(ParamWarning).Rule)
+}
+
+func TestRules_NoEffectRules(t *testing.T) {
+	tests := []struct {
+		params   url.Values
+		expected []string
+	}{
+		{url.Values{"q": {"80"}, "fm": {"png"}}, []string{"q-with-lossless-output"}},
+		{url.Values{"q": {"80"}, "fm": {"webp"}, "lossless": {"1"}}, []string{"q-with-lossless-output"}},
+		{url.Values{"q": {"80"}, "fm": {"png"}, "auto": {"format"}}, nil},
+		{url.Values{"q": {"80"}, "fm": {"jpg"}}, nil},
+		{url.Values{"ar": {"16:9"}, "fit": {"max"}}, []string{"ar-with-fit-max"}},
+		{url.Values{"ar": {"16:9"}, "fit": {"crop"}}, nil},
+		{url.Values{"dpr": {"2"}, "w": {"400"}, "h": {"300"}}, nil},
+		{url.Values{"dpr": {"1.0"}, "w": {"400"}}, []string{"default-dpr"}},
+		{url.Values{"dpr": {"1.5"}, "w": {"400"}}, nil},
+		{url.Values{"q": {"80"}, "fm": {"png"}, "dpr": {"1"}}, []string{"q-with-lossless-output", "default-dpr"}},
+	}
+
+	for _, test := range tests {
+		var names []string
+		for _, w := range CheckParams(test.params, NoEffectRules) {
+			names = append(names, w.Rule)
+		}
+		assert.Equal(t, test.expected, names, test.params.Encode())
+	}
+
+	// The first key names the redundant param.
+	warnings := CheckParams(url.Values{"q": {"80"}, "fm": {"png"}}, NoEffectRules)
+	assert.Equal(t, "q", warnings[0].Keys[0])
+	assert.Contains(t, warnings[0].Error(), "remove `q`")
+}
+
+func TestRules_NoEffectRulesAreLogged(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// Redundant params are logged, but don't fail strict validation.
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithValidation(ValidationStrict))
+	actual, err := u.CreateURLE("image.png", Param("q", "80"), Param("fm", "png"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://test.imgix.net/image.png?fm=png&q=80", actual)
+	assert.Contains(t, buf.String(), "q-with-lossless-output")
+
+	// The 1x candidate of a dpr srcset is created by the library, so its
+	// dpr=1 isn't reported.
+	buf.Reset()
+	w := NewURLBuilder("test.imgix.net", WithValidation(ValidationWarn))
+	srcset := w.CreateSrcset("image.png", []IxParam{Param("w", "100")})
+	assert.Contains(t, srcset, "dpr=1&")
+	assert.Equal(t, "", buf.String())
+
+	// With validation off, nothing is checked.
+	buf.Reset()
+	c := testClient()
+	c.CreateURL("image.png", Param("dpr", "1"))
+	assert.Equal(t, "", buf.String())
+}
+
+func TestRules_CheckParamValues(t *testing.T) {
+	params := url.Values{"colorquant": {"1"}, "chromasub": {"411"}, "w": {"100"}}
+	warnings := CheckParamValues(params, ValueValidators)
+
+	assert.Equal(t, 2, len(warnings))
+	assert.Equal(t, []string{"chromasub"}, warnings[0].Keys)
+	assert.Equal(t, []string{"colorquant"}, warnings[1].Keys)
+	assert.Contains(t, warnings[1].Error(), "between 2 and 256")
+}
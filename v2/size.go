@@ -0,0 +1,149 @@
+package imgix
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// OutputSize predicts the dimensions of the image imgix renders for a
+// source of inputW by inputH pixels given the sizing params w, h, fit,
+// ar, and dpr. This makes it possible to reserve layout space (avoiding
+// cumulative layout shift) without requesting fm=json.
+//
+// The following fit modes are supported (clip is imgix's default):
+//
+//	clip:  scale to fit within w and h, preserving the aspect ratio
+//	max:   like clip, but never upscale beyond the input dimensions
+//	crop:  exactly w by h; with a single dimension, ar (if given) is used
+//	       to derive the other, otherwise it behaves like clip
+//	fill:  exactly w by h (padded); with a single dimension, like clip
+//	scale: exactly w by h (distorted); with a single dimension, like clip
+//
+// Width and height values between zero and one are treated as ratios of
+// the input dimensions, as they are by imgix. Fit modes whose output
+// depends on the image content (e.g. facearea) cannot be predicted and
+// result in an error.
+func OutputSize(inputW, inputH int, params url.Values) (outW, outH int, err error) {
+	if inputW <= 0 || inputH <= 0 {
+		return 0, 0, errors.New("input dimensions must be greater than zero")
+	}
+	srcW, srcH := float64(inputW), float64(inputH)
+
+	w, err := parseDimension("w", params.Get("w"), srcW)
+	if err != nil {
+		return 0, 0, err
+	}
+	h, err := parseDimension("h", params.Get("h"), srcH)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	dpr := 1.0
+	if v := params.Get("dpr"); v != "" {
+		dpr, err = strconv.ParseFloat(v, 64)
+		if err != nil || dpr <= 0 {
+			return 0, 0, fmt.Errorf("`dpr` must be a number greater than zero, found `%s`", v)
+		}
+	}
+
+	fit := params.Get("fit")
+	if fit == "" {
+		fit = "clip"
+	}
+
+	switch fit {
+	case "clip", "max", "fill", "scale":
+	case "crop":
+		if v := params.Get("ar"); v != "" {
+			ar, err := parseAspectRatio(v)
+			if err != nil {
+				return 0, 0, err
+			}
+			w, h = applyAspectRatio(w, h, srcW, srcH, ar)
+		}
+	default:
+		return 0, 0, fmt.Errorf("output size cannot be computed for `fit=%s`", fit)
+	}
+
+	var width, height float64
+	switch {
+	case w == 0 && h == 0:
+		width, height = srcW, srcH
+	case w != 0 && h != 0 && fit != "clip" && fit != "max":
+		width, height = w, h
+	default:
+		scale := math.Inf(1)
+		if w != 0 {
+			scale = w / srcW
+		}
+		if h != 0 {
+			scale = math.Min(scale, h/srcH)
+		}
+		if fit == "max" && scale > 1 {
+			scale = 1
+		}
+		width, height = srcW*scale, srcH*scale
+	}
+
+	return int(math.Round(width * dpr)), int(math.Round(height * dpr)), nil
+}
+
+// parseDimension parses the value of the w or h param. An empty value
+// yields zero. Values between zero and one are ratios of the input
+// dimension, so they are multiplied by it.
+func parseDimension(key string, value string, input float64) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	d, err := strconv.ParseFloat(value, 64)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("`%s` must be a number greater than zero, found `%s`", key, value)
+	}
+
+	if d < 1 {
+		return d * input, nil
+	}
+	return d, nil
+}
+
+// parseAspectRatio parses an ar value of the form "W:H" (e.g. "16:9")
+// into the ratio of the width to the height.
+func parseAspectRatio(value string) (float64, error) {
+	msg := fmt.Sprintf("`ar` must have the form W:H, found `%s`", value)
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, errors.New(msg)
+	}
+
+	arW, wErr := strconv.ParseFloat(parts[0], 64)
+	arH, hErr := strconv.ParseFloat(parts[1], 64)
+	if wErr != nil || hErr != nil || arW <= 0 || arH <= 0 {
+		return 0, errors.New(msg)
+	}
+	return arW / arH, nil
+}
+
+// applyAspectRatio derives the missing dimension(s) from the aspect
+// ratio. If both w and h are set, the aspect ratio has no effect. If
+// neither is set, the largest region of the source with the aspect
+// ratio is used.
+func applyAspectRatio(w, h, srcW, srcH, ar float64) (float64, float64) {
+	switch {
+	case w != 0 && h != 0:
+		return w, h
+	case w != 0:
+		return w, w / ar
+	case h != 0:
+		return h * ar, h
+	case srcW/srcH > ar:
+		return srcH * ar, srcH
+	default:
+		return srcW, srcW / ar
+	}
+}
@@ -0,0 +1,66 @@
+package imgix
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSize_OutputSize(t *testing.T) {
+	tests := []struct {
+		params    url.Values
+		expectedW int
+		expectedH int
+	}{
+		// No sizing params.
+		{url.Values{}, 2000, 1000},
+		// clip is the default fit mode.
+		{url.Values{"w": {"400"}}, 400, 200},
+		{url.Values{"h": {"300"}}, 600, 300},
+		{url.Values{"w": {"400"}, "h": {"400"}}, 400, 200},
+		{url.Values{"w": {"4000"}, "fit": {"clip"}}, 4000, 2000},
+		// max never upscales.
+		{url.Values{"w": {"4000"}, "fit": {"max"}}, 2000, 1000},
+		{url.Values{"w": {"400"}, "h": {"400"}, "fit": {"max"}}, 400, 200},
+		// crop, fill, and scale honor both dimensions.
+		{url.Values{"w": {"400"}, "h": {"400"}, "fit": {"crop"}}, 400, 400},
+		{url.Values{"w": {"400"}, "h": {"400"}, "fit": {"fill"}}, 400, 400},
+		{url.Values{"w": {"400"}, "h": {"400"}, "fit": {"scale"}}, 400, 400},
+		{url.Values{"w": {"400"}, "fit": {"fill"}}, 400, 200},
+		// ar only applies with fit=crop.
+		{url.Values{"w": {"400"}, "ar": {"4:3"}, "fit": {"crop"}}, 400, 300},
+		{url.Values{"h": {"300"}, "ar": {"1:1"}, "fit": {"crop"}}, 300, 300},
+		{url.Values{"ar": {"1:1"}, "fit": {"crop"}}, 1000, 1000},
+		{url.Values{"w": {"400"}, "ar": {"4:3"}}, 400, 200},
+		// Ratios of the input dimensions and dpr.
+		{url.Values{"w": {"0.5"}}, 1000, 500},
+		{url.Values{"w": {"400"}, "dpr": {"2"}}, 800, 400},
+		{url.Values{"w": {"300"}, "h": {"300"}, "fit": {"crop"}, "dpr": {"1.5"}}, 450, 450},
+	}
+
+	for _, test := range tests {
+		w, h, err := OutputSize(2000, 1000, test.params)
+		assert.Equal(t, nil, err, test.params.Encode())
+		assert.Equal(t, test.expectedW, w, test.params.Encode())
+		assert.Equal(t, test.expectedH, h, test.params.Encode())
+	}
+}
+
+func TestSize_OutputSizeErrors(t *testing.T) {
+	invalid := []url.Values{
+		{"fit": {"facearea"}},
+		{"w": {"-1"}},
+		{"h": {"abc"}},
+		{"dpr": {"0"}},
+		{"w": {"400"}, "ar": {"16x9"}, "fit": {"crop"}},
+	}
+
+	for _, params := range invalid {
+		_, _, err := OutputSize(2000, 1000, params)
+		assert.NotEqual(t, nil, err, params.Encode())
+	}
+
+	_, _, err := OutputSize(0, 1000, url.Values{})
+	assert.NotEqual(t, nil, err)
+}
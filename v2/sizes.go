@@ -0,0 +1,81 @@
+package imgix
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GridBreakpoint describes the layout of a CSS grid from a minimum
+// viewport width onwards: the number of columns, the gutter between
+// them, and the width of the grid's container.
+type GridBreakpoint struct {
+	// MinWidth is the minimum viewport width, in CSS pixels, at which
+	// the layout applies. A MinWidth of zero describes the default
+	// layout, which applies below every other breakpoint.
+	MinWidth int
+
+	// Columns is the number of columns. Values less than one are
+	// treated as one.
+	Columns int
+
+	// Gutter is the space between adjacent columns, in CSS pixels.
+	Gutter int
+
+	// ContainerWidth is the width of the grid's container, in CSS
+	// pixels. A ContainerWidth of zero describes a fluid container that
+	// spans the viewport (100vw).
+	ContainerWidth int
+}
+
+// slotSize returns the CSS length of a single column of the layout.
+func (bp GridBreakpoint) slotSize() string {
+	columns := bp.Columns
+	if columns < 1 {
+		columns = 1
+	}
+	gutters := (columns - 1) * bp.Gutter
+
+	if bp.ContainerWidth > 0 {
+		width := float64(bp.ContainerWidth-gutters) / float64(columns)
+		return formatFloat(width) + "px"
+	}
+
+	switch {
+	case columns == 1 && gutters == 0:
+		return "100vw"
+	case gutters == 0:
+		return "calc(100vw / " + strconv.Itoa(columns) + ")"
+	}
+	return "calc((100vw - " + strconv.Itoa(gutters) + "px) / " + strconv.Itoa(columns) + ")"
+}
+
+// GridSizes creates a sizes attribute for an image that fills a single
+// column of a CSS grid with the given breakpoints. Each breakpoint
+// yields a `(min-width: Xpx) Ypx` entry, where Y is the width of a
+// column: the container width, less the gutters, divided by the number
+// of columns. The entries are ordered from the widest breakpoint to the
+// narrowest, since browsers use the first entry that matches, and they
+// end with the default layout's size (or 100vw, if no breakpoint has a
+// MinWidth of zero).
+//
+// For example, a grid with three 300px columns and 20px gutters in a
+// 940px container from 1024px onwards, and a single, fluid column
+// otherwise, yields "(min-width: 1024px) 300px, 100vw".
+func GridSizes(breakpoints []GridBreakpoint) string {
+	sorted := append([]GridBreakpoint(nil), breakpoints...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].MinWidth > sorted[j].MinWidth
+	})
+
+	var entries []string
+	defaultSize := "100vw"
+	for _, bp := range sorted {
+		if bp.MinWidth <= 0 {
+			defaultSize = bp.slotSize()
+			break
+		}
+		entries = append(entries, "(min-width: "+strconv.Itoa(bp.MinWidth)+"px) "+bp.slotSize())
+	}
+	return strings.Join(append(entries, defaultSize), ", ")
+}
@@ -0,0 +1,38 @@
+package imgix
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizes_GridSizesTwoBreakpoints(t *testing.T) {
+	breakpoints := []GridBreakpoint{
+		{MinWidth: 0, Columns: 1},
+		{MinWidth: 1024, Columns: 3, Gutter: 20, ContainerWidth: 940},
+	}
+	assert.Equal(t, "(min-width: 1024px) 300px, 100vw", GridSizes(breakpoints))
+}
+
+func TestSizes_GridSizesThreeBreakpoints(t *testing.T) {
+	breakpoints := []GridBreakpoint{
+		{MinWidth: 640, Columns: 2, Gutter: 16},
+		{MinWidth: 1280, Columns: 4, Gutter: 24, ContainerWidth: 1200},
+		{MinWidth: 0, Columns: 1, Gutter: 16},
+	}
+	expected := "(min-width: 1280px) 282px, (min-width: 640px) calc((100vw - 16px) / 2), 100vw"
+	assert.Equal(t, expected, GridSizes(breakpoints))
+}
+
+func TestSizes_GridSizesDefaults(t *testing.T) {
+	assert.Equal(t, "100vw", GridSizes(nil))
+
+	breakpoints := []GridBreakpoint{
+		{MinWidth: 768, Columns: 3, ContainerWidth: 1000},
+		{Columns: 2},
+	}
+	assert.Equal(t, "(min-width: 768px) 333.333px, calc(100vw / 2)", GridSizes(breakpoints))
+
+	breakpoints = []GridBreakpoint{{MinWidth: 768, Columns: 0, ContainerWidth: 720}}
+	assert.Equal(t, "(min-width: 768px) 720px, 100vw", GridSizes(breakpoints))
+}
@@ -0,0 +1,45 @@
+package imgix
+
+import "net/url"
+
+// openGraphParams are the default params of OpenGraphURL. Open Graph
+// images are displayed at 1200x630 (1.91:1) by most platforms.
+var openGraphParams = url.Values{
+	"w":    {"1200"},
+	"h":    {"630"},
+	"fit":  {"crop"},
+	"auto": {"format"},
+	"fm":   {"jpg"},
+}
+
+// twitterCardParams are the default params of TwitterCardURL. Twitter's
+// summary_large_image cards are displayed at 2:1.
+var twitterCardParams = url.Values{
+	"w":    {"1200"},
+	"h":    {"600"},
+	"fit":  {"crop"},
+	"auto": {"format"},
+	"fm":   {"jpg"},
+}
+
+// OpenGraphURL creates the URL of an image suitable for an og:image
+// (social card) meta tag: the image cropped to 1200x630 (w=1200, h=630,
+// and fit=crop) and rendered as a JPEG (fm=jpg).
+//
+// The explicit fm matters: it takes precedence over auto=format (which
+// is also set, for any members the caller appends), and many scrapers
+// don't support the WebP or AVIF images that auto=format would
+// otherwise serve to them, leaving the preview broken. The params
+// replace the defaults of the same name, except that auto members are
+// appended. The URL is signed if the builder has a token, and the
+// params passed to this method are not modified.
+func (b *URLBuilder) OpenGraphURL(path string, params url.Values) string {
+	return b.createURLFromValues(path, mergeDefaultParams(openGraphParams, params))
+}
+
+// TwitterCardURL functions like OpenGraphURL except that the image is
+// cropped to 1200x600 (2:1), as displayed by Twitter's
+// summary_large_image cards.
+func (b *URLBuilder) TwitterCardURL(path string, params url.Values) string {
+	return b.createURLFromValues(path, mergeDefaultParams(twitterCardParams, params))
+}
@@ -0,0 +1,33 @@
+package imgix
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocial_OpenGraphURL(t *testing.T) {
+	u := testBuilder()
+	expected := "https://test.imgix.net/hero.png?auto=format&fit=crop&fm=jpg&h=630&w=1200"
+	assert.Equal(t, expected, u.OpenGraphURL("hero.png", nil))
+
+	params := url.Values{"h": {"600"}, "auto": {"compress"}, "crop": {"faces"}}
+	expected = "https://test.imgix.net/hero.png?auto=format%2Ccompress&crop=faces&fit=crop&fm=jpg&h=600&w=1200"
+	assert.Equal(t, expected, u.OpenGraphURL("hero.png", params))
+	assert.Equal(t, url.Values{"h": {"600"}, "auto": {"compress"}, "crop": {"faces"}}, params)
+}
+
+func TestSocial_OpenGraphURLSigned(t *testing.T) {
+	u := NewURLBuilder("my-social-network.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	query := "auto=format&fit=crop&fm=png&h=630&w=1200"
+	expected := "https://my-social-network.imgix.net/hero.png?" + query + "&s=" +
+		createMd5Signature("FOO123bar", "/hero.png", query)
+	assert.Equal(t, expected, u.OpenGraphURL("hero.png", url.Values{"fm": {"png"}}))
+}
+
+func TestSocial_TwitterCardURL(t *testing.T) {
+	u := testBuilder()
+	expected := "https://test.imgix.net/hero.png?auto=format&fit=crop&fm=jpg&h=600&w=1200"
+	assert.Equal(t, expected, u.TwitterCardURL("hero.png", nil))
+}
@@ -1,6 +1,7 @@
 package imgix
 
 import (
+	"errors"
 	"log"
 	"math"
 	"net/url"
@@ -24,6 +25,10 @@ const defaultMaxWidth int = 8192
 // defaultTolerance is the default width tolerance (percentage).
 const defaultTolerance float64 = 0.08
 
+// defaultSeparator is the default delimiter between the image candidate
+// strings of a srcset attribute.
+const defaultSeparator = ",\n"
+
 // DefaultWidths is an array of image widths generated by
 // calling TargetWidths(100, 8192, 0.08). These defaults are quite
 // good, cover a wide range of widths, and are easy to start with.
@@ -42,6 +47,64 @@ type SrcsetOpts struct {
 	maxWidth        int
 	tolerance       float64
 	variableQuality bool
+	perWidth        func(width int, base url.Values) url.Values
+	rounding        Rounding
+	descriptorKind  DescriptorKind
+	widths          []int
+	maxCandidates   int
+	dprQualities    map[int]int
+	separator       string
+	descending      bool
+	checksumComment bool
+}
+
+// DescriptorKind determines how the candidates of a srcset built from an
+// explicit list of widths (see CreateSrcsetFromWidths and WithWidths) are
+// described.
+type DescriptorKind int
+
+const (
+	// WidthDescriptor describes each candidate by its width, e.g. "480w".
+	// This is the default.
+	WidthDescriptor DescriptorKind = iota
+
+	// DPRDescriptor describes each candidate by its pixel density
+	// relative to the smallest width, e.g. "2x" for a 960px candidate
+	// when the smallest width is 480px. It suits fixed-layout components
+	// displayed at the smallest width, and requires a short, ascending
+	// list of widths (see validateDprWidths).
+	DPRDescriptor
+)
+
+// Rounding determines how the fractional widths of a srcset width-range
+// are rounded to integer widths.
+type Rounding int
+
+const (
+	// RoundNearest rounds each width to the nearest integer. This is
+	// the default.
+	RoundNearest Rounding = iota
+
+	// RoundCeil rounds each width up. Candidates are never slightly
+	// narrower than their step (which could cause upscaling blur) at
+	// the cost of slightly larger downloads.
+	RoundCeil
+
+	// RoundFloor rounds each width down, favoring slightly smaller
+	// downloads.
+	RoundFloor
+)
+
+// round rounds the width according to the Rounding.
+func (r Rounding) round(width float64) int {
+	switch r {
+	case RoundCeil:
+		return int(math.Ceil(width))
+	case RoundFloor:
+		return int(math.Floor(width))
+	default:
+		return int(math.Round(width))
+	}
 }
 
 type SrcsetOption func(opt *SrcsetOpts)
@@ -59,43 +122,254 @@ type SrcsetOption func(opt *SrcsetOpts)
 // Otherwise if no explicit width, height, or aspect ratio were found
 // this function will create a fluid-width srcset attribute wherein
 // each URL (or image candidate string) is described by a width in the
-// specified width-range.
+// specified width-range (or by one of the widths given by WithWidths).
+//
+// If the SrcsetOptions that the srcset uses are invalid (see
+// SrcsetOpts.Validate), this function logs the error and exits; use
+// CreateSrcsetE to handle the error instead. A dpr-based srcset doesn't
+// use the width options (e.g. WithMinWidth), so they aren't checked.
 func (b *URLBuilder) CreateSrcset(
 	path string,
 	params []IxParam,
 	options ...SrcsetOption) string {
 
-	urlParams := url.Values{}
+	urlParams := applyParams(params)
+
+	opts := newSrcsetOpts(options...)
+	if err := opts.validateFor(b.isDprBased(path, urlParams)); err != nil {
+		log.Fatalln(err)
+	}
+
+	b = b.checkOnce(path, urlParams)
+	return b.createSrcsetFromValues(path, urlParams, opts)
+}
+
+// createSrcsetFromValues functions like CreateSrcset except that it
+// accepts url.Values and SrcsetOpts.
+func (b *URLBuilder) createSrcsetFromValues(path string, urlParams url.Values, opts SrcsetOpts) string {
+	return opts.join(b.srcsetCandidates(path, urlParams, opts))
+}
+
+// srcsetCandidates creates the image candidates of the srcset attribute
+// that CreateSrcset infers from the params (see CreateSrcset).
+func (b *URLBuilder) srcsetCandidates(path string, urlParams url.Values, opts SrcsetOpts) []srcsetCandidate {
+	// If params has either a width or _both_ height and aspect ratio,
+	// build a dpr-based srcset attribute.
+	if b.isDprBased(path, urlParams) {
+		return b.buildSrcSetDpr(path, urlParams, opts.variableQuality, opts.dprQualities, 0)
+	}
+
+	// Otherwise, get the target widths from the opts and build a
+	// width-pairs based srcset attribute.
+	if opts.widths != nil && opts.descriptorKind == DPRDescriptor {
+		return b.buildSrcSetDprWidths(path, urlParams, opts.widths)
+	}
+	return b.buildSrcSetPairs(path, urlParams, opts.targets(), opts.perWidth)
+}
+
+// CreateSrcsetPaths creates the path and query (e.g. "/image.png?w=100&s=…")
+// of each of the candidates in the srcset attribute that CreateSrcset
+// creates for the same path, params, and options, in the same order and
+// without their descriptors. Candidates are signed if the builder has a
+// token. This suits build-time manifests, to which the scheme and domain
+// are prepended later (e.g. by a CDN layer). The params passed to this
+// method are not modified.
+func (b *URLBuilder) CreateSrcsetPaths(
+	path string,
+	params url.Values,
+	options ...SrcsetOption) []string {
+
+	opts := newSrcsetOpts(options...)
+	if err := opts.validateFor(b.isDprBased(path, params)); err != nil {
+		log.Fatalln(err)
+	}
+
+	relative := *b.checkOnce(path, params)
+	relative.pathOnly = true
+	candidates := opts.order(relative.srcsetCandidates(path, cloneValues(params), opts))
+
+	paths := make([]string, len(candidates))
+	for i, c := range candidates {
+		paths[i] = c.url
+	}
+	return paths
+}
+
+// CreateSrcsetE functions like CreateSrcset except that it returns an
+// error, rather than exiting, when the SrcsetOptions that the srcset
+// uses are invalid (see SrcsetOpts.Validate). Like CreateURLE, it also
+// returns an error that wraps ErrEmptyToken, rather than creating
+// unsigned candidates, when the path must be signed (see WithSignWhen)
+// but the builder has no token.
+func (b *URLBuilder) CreateSrcsetE(
+	path string,
+	params []IxParam,
+	options ...SrcsetOption) (string, error) {
+
+	urlParams := applyParams(params)
+
+	opts := newSrcsetOpts(options...)
+	if err := opts.validateFor(b.isDprBased(path, urlParams)); err != nil {
+		return "", err
+	}
+	if err := b.checkToken(path); err != nil {
+		return "", err
+	}
+
+	b = b.checkOnce(path, urlParams)
+	return b.createSrcsetFromValues(path, urlParams, opts), nil
+}
+
+// AutoFormatSrcset functions like CreateSrcset except that every
+// candidate lets imgix choose the output format by content negotiation,
+// i.e. "format" is added to the auto param (merged with any other auto
+// members, e.g. auto=compress becomes auto=compress,format) and any fm
+// param, which would take precedence over auto=format, is removed,
+// including one among the builder's default params (see
+// WithDefaultParams). This is the simplest way to serve modern formats
+// (e.g. AVIF or WebP) to the browsers that support them, without
+// separate <picture> sources.
+func (b *URLBuilder) AutoFormatSrcset(
+	path string,
+	params []IxParam,
+	options ...SrcsetOption) string {
+
+	if b.defaultParams.Get("fm") != "" {
+		autoFormat := *b
+		autoFormat.defaultParams = cloneValues(b.defaultParams)
+		autoFormat.defaultParams.Del("fm")
+		b = &autoFormat
+	}
+
+	urlParams := applyParams(params)
+	urlParams.Del("fm")
+	urlParams.Set("auto", canonicalizeSetParam(append(urlParams["auto"], "format"), b.memberOrder))
 
-	for _, fn := range params {
-		fn(&urlParams)
+	opts := newSrcsetOpts(options...)
+	if err := opts.validateFor(b.isDprBased(path, urlParams)); err != nil {
+		log.Fatalln(err)
 	}
+	b = b.checkOnce(path, urlParams)
+	return b.createSrcsetFromValues(path, urlParams, opts)
+}
+
+// ResponsiveSet creates a width-described srcset attribute for modern
+// browsers along with a fallback src for browsers that don't support
+// srcset. The srcset is always width-described, using the width-range
+// given by the SrcsetOptions, regardless of whether the params contain
+// a width. Any dpr param is dropped from the srcset, since each
+// candidate's w descriptor already accounts for the display's density.
+// The fallback src is built from the params with the dpr pinned to "1"
+// (unless the params already specify a dpr).
+func (b *URLBuilder) ResponsiveSet(
+	path string,
+	params []IxParam,
+	options ...SrcsetOption) (srcset string, src string) {
+
+	urlParams := applyParams(params)
+
+	opts := newSrcsetOpts(options...)
+	if err := opts.validateFor(false); err != nil {
+		log.Fatalln(err)
+	}
+
+	b = b.checkOnce(path, urlParams)
+	srcParams := cloneValues(urlParams)
+	if srcParams.Get("dpr") == "" {
+		srcParams.Set("dpr", "1")
+	}
+	src = b.createURLFromValues(path, srcParams)
+
+	urlParams.Del("dpr")
+	srcset = opts.join(b.buildSrcSetPairs(path, urlParams, opts.targets(), opts.perWidth))
+	return srcset, src
+}
+
+// FixedImage creates the src and the dpr-described srcset attribute for an
+// image displayed at a fixed width, e.g. a 300px avatar. The src is the
+// image at the width, and the srcset contains candidates from 1x up to
+// maxDPR (at most 5x) with the recommended per-dpr quality reduction
+// applied, unless the params contain an explicit q. A maxDPR less than
+// one is treated as one. Every URL is signed if the builder has a token.
+// The params passed to this method are not modified.
+func (b *URLBuilder) FixedImage(
+	path string,
+	width int,
+	params url.Values,
+	maxDPR int) (src string, srcset string) {
+
+	urlParams := cloneValues(params)
+	urlParams.Set("w", strconv.Itoa(width))
+	b = b.checkOnce(path, urlParams)
+	src = b.createURLFromValues(path, urlParams)
+
+	if maxDPR < 1 {
+		maxDPR = 1
+	}
+	srcset = joinCandidates(b.buildSrcSetDpr(path, urlParams, true, nil, maxDPR), defaultSeparator)
+	return src, srcset
+}
+
+// containerDPRStep is the step between the pixel densities of the
+// candidates created by ContainerSrcset.
+const containerDPRStep = 0.5
+
+// ContainerSrcset creates a width-described srcset attribute for an
+// image displayed in a container of a known width, e.g. a 400px card
+// image. Rather than following the geometric width ladder, the srcset
+// contains exactly the widths that the container needs at each pixel
+// density, from 1x up to maxDPR (at most 5x) in steps of 0.5x: for a
+// 400px container and a maxDPR of 2, the widths 400, 600, and 800 (each
+// rounded to the nearest pixel, see RoundNearest). Widths are clamped to
+// the builder's maximum width, if any (see WithMaxDimensions), and
+// duplicates are dropped.
+//
+// A maxDPR less than one is treated as one, and an empty srcset is
+// returned if the containerWidth isn't positive. Every URL is signed if
+// the builder has a token. The params passed to this method are not
+// modified.
+func (b *URLBuilder) ContainerSrcset(
+	path string,
+	containerWidth int,
+	maxDPR float64,
+	params url.Values) string {
 
+	if containerWidth <= 0 {
+		return ""
+	}
+	if !(maxDPR >= 1) {
+		maxDPR = 1
+	}
+	maxDPR = math.Min(maxDPR, 5)
+
+	var widths []int
+	steps := int(math.Floor(maxDPR/containerDPRStep + 1e-9))
+	for i := int(1 / containerDPRStep); i <= steps; i++ {
+		w := RoundNearest.round(float64(containerWidth) * float64(i) * containerDPRStep)
+		if len(widths) == 0 || w != widths[len(widths)-1] {
+			widths = append(widths, w)
+		}
+	}
+
+	b = b.checkOnce(path, params)
+	candidates := b.buildSrcSetPairs(path, cloneValues(params), widths, nil)
+	return joinCandidates(candidates, defaultSeparator)
+}
+
+// newSrcsetOpts creates the default SrcsetOpts and then applies each of
+// the given options to it.
+func newSrcsetOpts(options ...SrcsetOption) SrcsetOpts {
 	opts := SrcsetOpts{
 		minWidth:        defaultMinWidth,
 		maxWidth:        defaultMaxWidth,
 		tolerance:       defaultTolerance,
-		variableQuality: true}
+		variableQuality: true,
+		separator:       defaultSeparator}
 
 	for _, fn := range options {
 		fn(&opts)
 	}
-
-	// Check params contains a width (w) or height (h) _and_ aspect ratio (ar);
-	hasWidth := urlParams.Get("w") != ""
-	hasHeight := urlParams.Get("h") != ""
-	hasAspectRatio := urlParams.Get("ar") != ""
-
-	// If params has either a width or _both_ height and aspect ratio,
-	// build a dpr-based srcset attribute.
-	if hasWidth || (hasHeight && hasAspectRatio) {
-		return b.buildSrcSetDpr(path, urlParams, opts.variableQuality)
-	}
-
-	// Otherwise, get the widthRange values from the opts and build a
-	// width-pairs based srcset attribute.
-	targets := TargetWidths(opts.minWidth, opts.maxWidth, opts.tolerance)
-	return b.buildSrcSetPairs(path, urlParams, targets)
+	return opts
 }
 
 func WithMinWidth(minWidth int) SrcsetOption {
@@ -122,45 +396,299 @@ func WithVariableQuality(variableQuality bool) SrcsetOption {
 	}
 }
 
+// WithPerWidth returns a SrcsetOption that customizes the params of each
+// candidate in a width-described srcset. The perWidth callback is invoked
+// with each candidate's width and base params (which include that
+// width) before the candidate is signed, and the returned params are
+// used for that candidate only. If perWidth returns nil, the base params
+// are used.
+//
+// The callback must not modify base in place, as it is shared between
+// candidates; copy it, modify the copy, and return that instead.
+func WithPerWidth(perWidth func(width int, base url.Values) url.Values) SrcsetOption {
+	return func(s *SrcsetOpts) {
+		s.perWidth = perWidth
+	}
+}
+
+// WithRounding returns a SrcsetOption that sets how the fractional widths
+// of a width-range are rounded to integer widths (see Rounding).
+func WithRounding(rounding Rounding) SrcsetOption {
+	return func(s *SrcsetOpts) {
+		s.rounding = rounding
+	}
+}
+
+// WithWidths returns a SrcsetOption that sets the target widths of a
+// fluid-width srcset explicitly, in place of the widths generated from
+// the width-range. The widths must be ascending, unique, and within the
+// width-range (see WithMinWidth and WithMaxWidth).
+func WithWidths(widths ...int) SrcsetOption {
+	return func(s *SrcsetOpts) {
+		s.widths = widths
+	}
+}
+
+// Validate checks that the options produce a sane srcset: the width-range
+// and tolerance must be valid and the target widths (either generated or
+// given by WithWidths) must be ascending, unique, and within the
+// width-range. Explicit widths described by pixel density must also
+// satisfy the requirements of DPRDescriptor, the maximum number of
+// candidates must not be negative, the dpr qualities must be valid, and
+// the separator must contain a comma.
+func (o SrcsetOpts) Validate() error {
+	if err := o.validateWidthOptions(); err != nil {
+		return err
+	}
+	return validateDprQualities(o.dprQualities)
+}
+
+// validateFor functions like Validate except that it only checks the
+// options that a srcset of the kind uses: a dpr-based srcset (see
+// CreateSrcset) ignores the width options, while a width-described
+// srcset ignores the dpr qualities.
+func (o SrcsetOpts) validateFor(dprBased bool) error {
+	if dprBased {
+		if err := validateDprQualities(o.dprQualities); err != nil {
+			return err
+		}
+		return validateSeparator(o.separator)
+	}
+	return o.validateWidthOptions()
+}
+
+// validateWidthOptions checks the options of a width-described srcset
+// (see Validate), along with the separator.
+func (o SrcsetOpts) validateWidthOptions() error {
+	if _, err := validateRangeWithTolerance(o.minWidth, o.maxWidth, o.tolerance); err != nil {
+		return err
+	}
+
+	if o.maxCandidates < 0 {
+		return errors.New("`maxCandidates` must be greater than, or equal to, zero")
+	}
+
+	if err := validateSeparator(o.separator); err != nil {
+		return err
+	}
+
+	if o.widths != nil && o.descriptorKind == DPRDescriptor {
+		if err := validateDprWidths(o.widths); err != nil {
+			return err
+		}
+	}
+	return validateLadder(o.targets(), o.minWidth, o.maxWidth)
+}
+
+// targets returns the target widths of a fluid-width srcset: the widths
+// given by WithWidths or, if there are none, those generated from the
+// width-range. The widths are then thinned to the maximum number of
+// candidates, if any (see WithMaxCandidates).
+func (o SrcsetOpts) targets() []int {
+	widths := o.widths
+	if widths == nil {
+		widths = targetWidths(o.minWidth, o.maxWidth, o.tolerance, o.rounding)
+	}
+	return thinWidths(widths, o.maxCandidates)
+}
+
+// thinWidths returns at most maxCandidates of the widths, spread evenly
+// from the first width to the last. A maxCandidates of zero (or less)
+// returns the widths unchanged, and a maxCandidates of one returns only
+// the last (i.e. largest) width.
+func thinWidths(widths []int, maxCandidates int) []int {
+	if maxCandidates <= 0 || len(widths) <= maxCandidates {
+		return widths
+	}
+	if maxCandidates == 1 {
+		return widths[len(widths)-1:]
+	}
+
+	thinned := make([]int, maxCandidates)
+	last := len(widths) - 1
+	for i := range thinned {
+		idx := int(math.Round(float64(i*last) / float64(maxCandidates-1)))
+		thinned[i] = widths[idx]
+	}
+	return thinned
+}
+
+// WithMaxCandidates returns a SrcsetOption that limits the number of
+// candidates in a fluid-width srcset. If there are more target widths,
+// they are thinned to maxCandidates widths spread evenly across them,
+// always including the smallest and largest. Zero is unlimited.
+func WithMaxCandidates(maxCandidates int) SrcsetOption {
+	return func(s *SrcsetOpts) {
+		s.maxCandidates = maxCandidates
+	}
+}
+
+// WithDprQualities returns a SrcsetOption that overrides the variable
+// quality (q) of the candidates in a dpr-based srcset. The qualities are
+// keyed by dpr, from 1 to 5, e.g. map[int]int{1: 80, 2: 60}; the
+// candidates for any other dprs keep their default quality.
+func WithDprQualities(qualities map[int]int) SrcsetOption {
+	return func(s *SrcsetOpts) {
+		s.dprQualities = qualities
+	}
+}
+
+// WithSeparator returns a SrcsetOption that sets the delimiter between
+// the image candidate strings of a srcset attribute, which defaults to
+// ",\n". Browsers ignore whitespace around the commas, so a separator
+// such as ", " or ",\n\t" is equally valid; it must contain a comma.
+func WithSeparator(separator string) SrcsetOption {
+	return func(s *SrcsetOpts) {
+		s.separator = separator
+	}
+}
+
+// WithDescending returns a SrcsetOption that emits the candidates of a
+// srcset from the largest to the smallest descriptor, rather than from
+// the smallest (the default). Browsers pick a candidate regardless of
+// the order, so this is purely cosmetic, e.g. to match hand-written
+// markup.
+func WithDescending(descending bool) SrcsetOption {
+	return func(s *SrcsetOpts) {
+		s.descending = descending
+	}
+}
+
+// WithChecksumComment returns a SrcsetOption that makes the HTML helpers
+// (see PictureModernFormats and LazyImage) append a comment of the form
+// `<!-- imgix:0123abcd -->` that identifies the transform of the image's
+// src (see cacheKeyChecksum), so that rendered pages can be searched for
+// it when debugging caching. ImgAttributesWithSize and BuildManifest,
+// which create attributes rather than markup, set the checksum as the
+// ImgAttrs' Checksum instead. It has no effect on a srcset itself.
+func WithChecksumComment(checksumComment bool) SrcsetOption {
+	return func(s *SrcsetOpts) {
+		s.checksumComment = checksumComment
+	}
+}
+
+// WithDescriptorKind returns a SrcsetOption that sets how the candidates
+// of a srcset built from an explicit list of widths are described (see
+// DescriptorKind).
+func WithDescriptorKind(kind DescriptorKind) SrcsetOption {
+	return func(s *SrcsetOpts) {
+		s.descriptorKind = kind
+	}
+}
+
 // CreateSrcsetFromWidths takes a path, a set of params, and an array of widths
 // to create a srcset attribute with width-described URLs (image candidate strings).
-func (b *URLBuilder) CreateSrcsetFromWidths(path string, params []IxParam, widths []int) string {
-	urlParams := url.Values{}
+// Passing WithDescriptorKind(DPRDescriptor) describes the same URLs by pixel
+// density instead.
+func (b *URLBuilder) CreateSrcsetFromWidths(
+	path string,
+	params []IxParam,
+	widths []int,
+	options ...SrcsetOption) string {
 
-	for _, fn := range params {
-		fn(&urlParams)
+	urlParams := applyParams(params)
+
+	opts := newSrcsetOpts(options...)
+	if err := validateSeparator(opts.separator); err != nil {
+		log.Fatalln(err)
+	}
+	b = b.checkOnce(path, urlParams)
+	if opts.descriptorKind == DPRDescriptor {
+		if err := validateDprWidths(widths); err != nil {
+			log.Fatalln(err)
+		}
+		return opts.join(b.buildSrcSetDprWidths(path, urlParams, widths))
 	}
+	return opts.join(b.buildSrcSetPairs(path, urlParams, widths, opts.perWidth))
+}
 
-	return b.buildSrcSetPairs(path, urlParams, widths)
+// buildSrcSetDprWidths builds an image candidate for each of the
+// (validated) widths. Each candidate is described by its width's ratio
+// to the first, smallest width, e.g. "1x", "1.5x", and "2x" for the
+// widths 400, 600, and 800. Like those of a width ladder, the widths are
+// clamped to the builder's maximum width, if any (see clampWidths).
+func (b *URLBuilder) buildSrcSetDprWidths(path string, params url.Values, widths []int) []srcsetCandidate {
+	var srcSetEntries []srcsetCandidate
+
+	widths = clampWidths(widths, b.maxWidth)
+	for _, w := range widths {
+		params.Set("w", strconv.Itoa(w))
+		ratio := formatFloat(float64(w) / float64(widths[0]))
+		entry := b.createImageCandidate(path, params, ratio+"x")
+		srcSetEntries = append(srcSetEntries, entry)
+	}
+	return srcSetEntries
 }
 
-// buildSrcSetPairs builds a srcset attribute string containing width-described
-// image candidate strings.
-func (b *URLBuilder) buildSrcSetPairs(path string, params url.Values, targets []int) string {
-	var srcSetEntries []string
+// buildSrcSetPairs builds width-described image candidates. If
+// perWidth is not nil, it is used to customize the params of each
+// candidate (see WithPerWidth).
+func (b *URLBuilder) buildSrcSetPairs(
+	path string,
+	params url.Values,
+	targets []int,
+	perWidth func(width int, base url.Values) url.Values) []srcsetCandidate {
+
+	var srcSetEntries []srcsetCandidate
 
-	for _, w := range targets {
+	for _, w := range clampWidths(targets, b.maxWidth) {
 		widthValue := strconv.Itoa(w)
 		params.Set("w", widthValue)
-		entry := b.createImageCandidateString(path, params, widthValue+"w")
+
+		candidateParams := params
+		if perWidth != nil {
+			if p := perWidth(w, params); p != nil {
+				candidateParams = p
+			}
+		}
+		entry := b.createImageCandidate(path, candidateParams, widthValue+"w")
 		srcSetEntries = append(srcSetEntries, entry)
 	}
-	return strings.Join(srcSetEntries, ",\n")
+	return srcSetEntries
 }
 
-func (b *URLBuilder) buildSrcSetDpr(path string, params url.Values, useVariableQuality bool) string {
+// buildSrcSetDpr builds dpr-described image candidates, from 1x up to
+// 5x. If maxRatio is greater than zero, the candidates stop at maxRatio
+// (e.g. 1x and 2x for a maxRatio of 2). The qualities, keyed by ratio,
+// override the default variable qualities.
+func (b *URLBuilder) buildSrcSetDpr(
+	path string,
+	params url.Values,
+	useVariableQuality bool,
+	qualities map[int]int,
+	maxRatio int) []srcsetCandidate {
+
 	var DprQualities = map[string]string{"1": "75", "2": "50", "3": "35", "4": "23", "5": "20"}
-	var srcSetEntries []string
+	var srcSetEntries []srcsetCandidate
 
 	qValue := params.Get("q")
+	// The dimensions are capped as they're emitted, i.e. including any
+	// that the builder adds (e.g. a default w).
+	prepared := b.prepareParams(path, params)
+
 	// We could iterate over the map directly, but that doesn't yield
 	// deterministic results, ie. 5x might come before 1x in the final
 	// srcset attribute string. To prevent this, we iterate over the
 	// map "in order."
 	for i := 0; i < len(DprQualities); i++ {
+		// Stop the ladder at the maxRatio and at the builder's maximum
+		// DPR and dimensions, if any. The 1x candidate is kept
+		// regardless of the dimensions.
+		if maxRatio > 0 && i+1 > maxRatio {
+			break
+		}
+		if b.maxDPR > 0 && float64(i+1) > b.maxDPR {
+			break
+		}
+		if i > 0 && oversizedDimension(prepared, float64(i+1), b.maxWidth, b.maxHeight) != "" {
+			break
+		}
 		ratio := strconv.Itoa(i + 1)
 		params.Set("dpr", ratio)
 		dprQuality := DprQualities[ratio]
+		if q, ok := qualities[i+1]; ok {
+			dprQuality = strconv.Itoa(q)
+		}
 
 		if useVariableQuality && qValue != "" {
 			params.Set("q", qValue)
@@ -170,17 +698,87 @@ func (b *URLBuilder) buildSrcSetDpr(path string, params url.Values, useVariableQ
 			params.Set("q", qValue)
 		}
 
-		entry := b.createImageCandidateString(path, params, ratio+"x")
+		entry := b.createImageCandidate(path, params, ratio+"x")
 		srcSetEntries = append(srcSetEntries, entry)
 	}
-	return strings.Join(srcSetEntries, ",\n")
+	return srcSetEntries
 }
 
-// createImageCandidateString joins a URL with a space and a suffix in order
-// to create an image candidate string. For more information see:
+// clampWidths returns the widths that do not exceed maxWidth. If any
+// widths are dropped, maxWidth itself is included in their place (once).
+// A maxWidth of zero (or less) returns the widths unchanged.
+func clampWidths(widths []int, maxWidth int) []int {
+	if maxWidth <= 0 {
+		return widths
+	}
+
+	clamped := make([]int, 0, len(widths))
+	var dropped, hasMax bool
+	for _, w := range widths {
+		switch {
+		case w > maxWidth:
+			dropped = true
+		case w == maxWidth:
+			hasMax = true
+			clamped = append(clamped, w)
+		default:
+			clamped = append(clamped, w)
+		}
+	}
+
+	if dropped && !hasMax {
+		clamped = append(clamped, maxWidth)
+	}
+	return clamped
+}
+
+// srcsetCandidate is an image candidate of a srcset attribute: a URL and
+// its descriptor (e.g. "480w" or "2x").
+type srcsetCandidate struct {
+	url        string
+	descriptor string
+}
+
+// String joins the URL with a space and the descriptor in order to
+// create an image candidate string. For more information see:
 // https://html.spec.whatwg.org/multipage/images.html#srcset-attributes
-func (b *URLBuilder) createImageCandidateString(path string, params url.Values, suffix string) string {
-	return strings.Join([]string{b.createURLFromValues(path, params), " ", suffix}, "")
+func (c srcsetCandidate) String() string {
+	return strings.Join([]string{c.url, " ", c.descriptor}, "")
+}
+
+// createImageCandidate creates the image candidate for the path and
+// params, described by the suffix.
+func (b *URLBuilder) createImageCandidate(path string, params url.Values, suffix string) srcsetCandidate {
+	return srcsetCandidate{url: b.createURLFromValues(path, params), descriptor: suffix}
+}
+
+// join joins the candidates into a srcset attribute string, ordered and
+// delimited according to the options (see WithDescending and
+// WithSeparator).
+func (o SrcsetOpts) join(candidates []srcsetCandidate) string {
+	return joinCandidates(o.order(candidates), o.separator)
+}
+
+// order returns the candidates, which are built in ascending order, in
+// the order given by the options (see WithDescending). Descending
+// candidates are reversed in place.
+func (o SrcsetOpts) order(candidates []srcsetCandidate) []srcsetCandidate {
+	if o.descending {
+		for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		}
+	}
+	return candidates
+}
+
+// joinCandidates joins the image candidate strings of the candidates into
+// a srcset attribute string, delimited by the separator.
+func joinCandidates(candidates []srcsetCandidate, separator string) string {
+	entries := make([]string, len(candidates))
+	for i, c := range candidates {
+		entries[i] = c.String()
+	}
+	return strings.Join(entries, separator)
 }
 
 // TargetWidths creates an array of integer image widths.
@@ -188,6 +786,12 @@ func (b *URLBuilder) createImageCandidateString(path string, params url.Values,
 // maxWidth value––with a defaultTolerance amount of tolerable image
 // width-variance between them.
 func TargetWidths(minWidth int, maxWidth int, tolerance float64) []int {
+	return targetWidths(minWidth, maxWidth, tolerance, RoundNearest)
+}
+
+// targetWidths functions like TargetWidths except that each width is
+// rounded according to the rounding.
+func targetWidths(minWidth int, maxWidth int, tolerance float64, rounding Rounding) []int {
 	validRange, err := validateRangeWithTolerance(minWidth, maxWidth, tolerance)
 	if err != nil {
 		log.Fatalln(err)
@@ -196,7 +800,7 @@ func TargetWidths(minWidth int, maxWidth int, tolerance float64) []int {
 	end := validRange.maxWidth
 	tol := validRange.tolerance
 
-	if isNotCustom(begin, end, tol) {
+	if isNotCustom(begin, end, tol) && rounding == RoundNearest {
 		return DefaultWidths
 	}
 
@@ -207,7 +811,7 @@ func TargetWidths(minWidth int, maxWidth int, tolerance float64) []int {
 	var start = float64(begin)
 
 	for int(start) < end && int(start) < defaultMaxWidth {
-		resolutions = append(resolutions, int(math.Round(start)))
+		resolutions = append(resolutions, rounding.round(start))
 		start = start * (1.0 + tol*2.0)
 	}
 	lengthOfResolutions := len(resolutions)
@@ -224,8 +828,13 @@ func TargetWidths(minWidth int, maxWidth int, tolerance float64) []int {
 // isDprBased determines if we can infer from params whether we need
 // to create a dpr-based srcset attribute. If a width ("w") is present
 // or if both the height ("h") and the aspect ratio ("ar") are present,
-// then we can infer the desired srcset is dpr-based.
-func (b *URLBuilder) isDprBased(params url.Values) bool {
+// then we can infer the desired srcset is dpr-based. The params are
+// inspected after the builder's param transformations have been applied
+// (see prepareParams), so that e.g. a default w counts as well, and by
+// their canonical names, so that a width counts as a w.
+func (b *URLBuilder) isDprBased(path string, params url.Values) bool {
+	params = canonicalNames(b.prepareParams(path, params))
+
 	const EmptyStr = ""
 	hasWidth := params.Get("w")
 	hasHeight := params.Get("h")
@@ -1,6 +1,10 @@
 package imgix
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -147,3 +151,567 @@ func TestURLBuilder_CreateSrcsetQOverridesWithoutVariableQuality(t *testing.T) {
 	actual := c.CreateSrcset("image.png", params, WithVariableQuality(false))
 	assert.Equal(t, expected, actual)
 }
+
+func TestURLBuilder_ResponsiveSet(t *testing.T) {
+	c := testClient()
+	srcset, src := c.ResponsiveSet(
+		"image.png",
+		[]IxParam{Param("auto", "format")},
+		WithMinWidth(100),
+		WithMaxWidth(135))
+
+	expectedSrcset := "https://test.imgix.net/image.png?auto=format&w=100 100w,\n" +
+		"https://test.imgix.net/image.png?auto=format&w=116 116w,\n" +
+		"https://test.imgix.net/image.png?auto=format&w=135 135w"
+	assert.Equal(t, expectedSrcset, srcset)
+	assert.Equal(t, "https://test.imgix.net/image.png?auto=format&dpr=1", src)
+}
+
+func TestURLBuilder_ResponsiveSetFixedWidth(t *testing.T) {
+	c := testClient()
+	srcset, src := c.ResponsiveSet(
+		"image.png",
+		[]IxParam{Param("w", "320"), Param("dpr", "2")},
+		WithMinWidth(100),
+		WithMaxWidth(116))
+
+	// The srcset is width-described even though a width was given, and
+	// the dpr, which would scale each candidate past its descriptor, is
+	// dropped from it.
+	expectedSrcset := "https://test.imgix.net/image.png?w=100 100w,\n" +
+		"https://test.imgix.net/image.png?w=116 116w"
+	assert.Equal(t, expectedSrcset, srcset)
+
+	// An explicit dpr is left as-is in the fallback src.
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=2&w=320", src)
+}
+
+func TestURLBuilder_CreateSrcsetWithPerWidth(t *testing.T) {
+	c := testClient()
+	perWidth := func(width int, base url.Values) url.Values {
+		if width < 116 {
+			return nil
+		}
+		p := cloneValues(base)
+		p.Set("q", "40")
+		return p
+	}
+
+	actual := c.CreateSrcset(
+		"image.png",
+		[]IxParam{Param("auto", "format")},
+		WithMinWidth(100),
+		WithMaxWidth(135),
+		WithPerWidth(perWidth))
+
+	expected := "https://test.imgix.net/image.png?auto=format&w=100 100w,\n" +
+		"https://test.imgix.net/image.png?auto=format&q=40&w=116 116w,\n" +
+		"https://test.imgix.net/image.png?auto=format&q=40&w=135 135w"
+	assert.Equal(t, expected, actual)
+}
+
+func TestURLBuilder_CreateSrcsetWithMaxDPR(t *testing.T) {
+	c := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxDPR(3))
+	expected := "https://test.imgix.net/image.png?dpr=1&q=75&w=320 1x,\n" +
+		"https://test.imgix.net/image.png?dpr=2&q=50&w=320 2x,\n" +
+		"https://test.imgix.net/image.png?dpr=3&q=35&w=320 3x"
+	actual := c.CreateSrcset("image.png", []IxParam{Param("w", "320")})
+	assert.Equal(t, expected, actual)
+}
+
+func TestURLBuilder_targetWidthsRounding(t *testing.T) {
+	nearest := targetWidths(100, 380, 0.08, RoundNearest)
+	assert.Equal(t, []int{100, 116, 135, 156, 181, 210, 244, 283, 328, 380}, nearest)
+	assert.Equal(t, TargetWidths(100, 380, 0.08), nearest)
+
+	ceil := targetWidths(100, 380, 0.08, RoundCeil)
+	assert.Equal(t, []int{100, 116, 135, 157, 182, 211, 244, 283, 328, 380}, ceil)
+
+	floor := targetWidths(100, 380, 0.08, RoundFloor)
+	assert.Equal(t, []int{100, 115, 134, 156, 181, 210, 243, 282, 327, 380}, floor)
+
+	// The default range is computed, rather than using DefaultWidths,
+	// when rounding differs from the default.
+	assert.Equal(t, DefaultWidths, targetWidths(100, 8192, 0.08, RoundNearest))
+	assert.NotEqual(t, DefaultWidths, targetWidths(100, 8192, 0.08, RoundCeil))
+}
+
+func TestURLBuilder_CreateSrcsetWithRounding(t *testing.T) {
+	c := testClient()
+	actual := c.CreateSrcset(
+		"image.png",
+		[]IxParam{},
+		WithMinWidth(100),
+		WithMaxWidth(160),
+		WithRounding(RoundCeil))
+
+	expected := "https://test.imgix.net/image.png?w=100 100w,\n" +
+		"https://test.imgix.net/image.png?w=116 116w,\n" +
+		"https://test.imgix.net/image.png?w=135 135w,\n" +
+		"https://test.imgix.net/image.png?w=157 157w,\n" +
+		"https://test.imgix.net/image.png?w=160 160w"
+	assert.Equal(t, expected, actual)
+}
+
+func TestURLBuilder_FixedImage(t *testing.T) {
+	c := testClient()
+	params := url.Values{"fit": {"crop"}}
+	src, srcset := c.FixedImage("avatar.png", 300, params, 2)
+
+	assert.Equal(t, "https://test.imgix.net/avatar.png?fit=crop&w=300", src)
+	expected := "https://test.imgix.net/avatar.png?dpr=1&fit=crop&q=75&w=300 1x,\n" +
+		"https://test.imgix.net/avatar.png?dpr=2&fit=crop&q=50&w=300 2x"
+	assert.Equal(t, expected, srcset)
+	assert.Equal(t, url.Values{"fit": {"crop"}}, params)
+}
+
+func TestURLBuilder_FixedImageSigned(t *testing.T) {
+	c := NewURLBuilder("my-social-network.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	src, srcset := c.FixedImage("avatar.png", 300, nil, 10)
+
+	expectedSrc := "https://my-social-network.imgix.net/avatar.png?w=300&s=" +
+		createMd5Signature("FOO123bar", "/avatar.png", "w=300")
+	assert.Equal(t, expectedSrc, src)
+
+	entries := strings.Split(srcset, ",\n")
+	assert.Equal(t, 5, len(entries))
+	for _, entry := range entries {
+		assert.Contains(t, entry, "&s=")
+	}
+
+	_, srcset = c.FixedImage("avatar.png", 300, nil, 0)
+	assert.Equal(t, 1, len(strings.Split(srcset, ",\n")))
+}
+
+func TestURLBuilder_ContainerSrcset(t *testing.T) {
+	c := testClient()
+	params := url.Values{"fit": {"crop"}, "ar": {"4:3"}}
+
+	tests := []struct {
+		maxDPR   float64
+		expected []int
+	}{
+		{1, []int{333}},
+		{1.5, []int{333, 500}},
+		{2, []int{333, 500, 666}},
+		{2.75, []int{333, 500, 666, 833}},
+		{3, []int{333, 500, 666, 833, 999}},
+		{10, []int{333, 500, 666, 833, 999, 1166, 1332, 1499, 1665}},
+		{0, []int{333}},
+	}
+
+	for _, test := range tests {
+		var entries []string
+		for _, w := range test.expected {
+			entries = append(entries, fmt.Sprintf(
+				"https://test.imgix.net/image.png?ar=4%%3A3&fit=crop&w=%d %dw", w, w))
+		}
+		assert.Equal(t, strings.Join(entries, ",\n"), c.ContainerSrcset("image.png", 333, test.maxDPR, params))
+	}
+
+	assert.Equal(t, "", c.ContainerSrcset("image.png", 0, 2, params))
+	assert.Equal(t, url.Values{"fit": {"crop"}, "ar": {"4:3"}}, params)
+}
+
+func TestURLBuilder_ContainerSrcsetSigned(t *testing.T) {
+	c := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false),
+		WithMaxDimensions(700, 0))
+	srcset := c.ContainerSrcset("image.png", 300, 3, nil)
+
+	var widths []string
+	for _, entry := range strings.Split(srcset, ",\n") {
+		parts := strings.Split(entry, " ")
+		widths = append(widths, parts[1])
+
+		w := strings.TrimSuffix(parts[1], "w")
+		signature := createMd5Signature("FOO123bar", "/image.png", "w="+w)
+		assert.Equal(t, "https://test.imgix.net/image.png?w="+w+"&s="+signature, parts[0])
+	}
+
+	// The 900px candidate is clamped to the maximum width.
+	assert.Equal(t, []string{"300w", "450w", "600w", "700w"}, widths)
+}
+
+func TestURLBuilder_CreateSrcsetNilParams(t *testing.T) {
+	c := testClient()
+	expected := c.CreateSrcset("image.png", []IxParam{})
+	assert.Equal(t, expected, c.CreateSrcset("image.png", nil))
+	assert.Equal(t, expected, c.CreateSrcset("image.png", []IxParam{nil}))
+
+	expected = "https://test.imgix.net/image.png?w=100 100w"
+	assert.Equal(t, expected, c.CreateSrcsetFromWidths("image.png", nil, []int{100}))
+}
+
+func TestURLBuilder_CreateSrcsetWithMaxDimensions(t *testing.T) {
+	c := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxDimensions(4000, 0))
+
+	srcset := c.CreateSrcset("image.png", []IxParam{})
+	candidates := strings.Split(srcset, ",\n")
+	assert.Equal(t, "https://test.imgix.net/image.png?w=3524 3524w", candidates[len(candidates)-2])
+	assert.Equal(t, "https://test.imgix.net/image.png?w=4000 4000w", candidates[len(candidates)-1])
+
+	expected := "https://test.imgix.net/image.png?w=100 100w,\n" +
+		"https://test.imgix.net/image.png?w=4000 4000w"
+	assert.Equal(t, expected, c.CreateSrcsetFromWidths("image.png", []IxParam{}, []int{100, 4000, 5000}))
+
+	expected = "https://test.imgix.net/image.png?w=100 100w,\n" +
+		"https://test.imgix.net/image.png?w=4000 4000w"
+	assert.Equal(t, expected, c.CreateSrcsetFromWidths("image.png", []IxParam{}, []int{100, 5000}))
+}
+
+func TestURLBuilder_CreateSrcsetDprWithMaxDimensions(t *testing.T) {
+	c := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxDimensions(4000, 2000))
+
+	srcset := c.CreateSrcset("image.png", []IxParam{Param("w", "1000")})
+	assert.Equal(t, 4, len(strings.Split(srcset, ",\n")))
+
+	srcset = c.CreateSrcset("image.png", []IxParam{Param("h", "800"), Param("ar", "1:1")})
+	assert.Equal(t, 2, len(strings.Split(srcset, ",\n")))
+
+	srcset = c.CreateSrcset("image.png", []IxParam{Param("w", "5000")})
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=1&q=75&w=5000 1x", srcset)
+}
+
+func TestURLBuilder_CreateSrcsetDprWithMaxDimensionsDefaults(t *testing.T) {
+	// A default w is capped like a w param: 600 fits at 1x, but 1200
+	// doesn't fit 1000px at 2x.
+	c := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxDimensions(1000, 1000),
+		WithDefaultParams(url.Values{"w": {"600"}}))
+	srcset := c.CreateSrcset("image.png", nil)
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=1&q=75&w=600 1x", srcset)
+
+	// So is a long-form width.
+	l := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxDimensions(1000, 1000),
+		WithLongParamNames())
+	srcset = l.CreateSrcset("image.png", []IxParam{Param("w", "400")})
+	assert.Equal(t, 2, len(strings.Split(srcset, ",\n")))
+}
+
+func TestURLBuilder_CreateSrcsetFromWidthsDprWithMaxDimensions(t *testing.T) {
+	c := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxDimensions(1000, 0))
+	srcset := c.CreateSrcsetFromWidths("image.png", nil, []int{800, 1600, 2400},
+		WithDescriptorKind(DPRDescriptor))
+
+	expected := "https://test.imgix.net/image.png?w=800 1x,\n" +
+		"https://test.imgix.net/image.png?w=1000 1.25x"
+	assert.Equal(t, expected, srcset)
+}
+
+func TestURLBuilder_CreateSrcsetFromWidthsDescriptorKind(t *testing.T) {
+	c := testClient()
+	widths := []int{400, 600, 800}
+
+	expected := "https://test.imgix.net/image.png?w=400 400w,\n" +
+		"https://test.imgix.net/image.png?w=600 600w,\n" +
+		"https://test.imgix.net/image.png?w=800 800w"
+	assert.Equal(t, expected, c.CreateSrcsetFromWidths("image.png", nil, widths))
+	assert.Equal(t, expected, c.CreateSrcsetFromWidths("image.png", nil, widths, WithDescriptorKind(WidthDescriptor)))
+
+	expected = "https://test.imgix.net/image.png?w=400 1x,\n" +
+		"https://test.imgix.net/image.png?w=600 1.5x,\n" +
+		"https://test.imgix.net/image.png?w=800 2x"
+	assert.Equal(t, expected, c.CreateSrcsetFromWidths("image.png", nil, widths, WithDescriptorKind(DPRDescriptor)))
+}
+
+func TestURLBuilder_CreateSrcsetWithWidths(t *testing.T) {
+	c := testClient()
+	expected := "https://test.imgix.net/image.png?w=200 200w,\n" +
+		"https://test.imgix.net/image.png?w=400 400w"
+	actual, err := c.CreateSrcsetE("image.png", []IxParam{}, WithWidths(200, 400))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, expected, c.CreateSrcset("image.png", []IxParam{}, WithWidths(200, 400)))
+
+	expected = "https://test.imgix.net/image.png?w=200 1x,\n" +
+		"https://test.imgix.net/image.png?w=400 2x"
+	actual = c.CreateSrcset("image.png", []IxParam{}, WithWidths(200, 400), WithDescriptorKind(DPRDescriptor))
+	assert.Equal(t, expected, actual)
+}
+
+func TestURLBuilder_SrcsetOptsValidate(t *testing.T) {
+	assert.Equal(t, nil, newSrcsetOpts().Validate())
+	assert.Equal(t, nil, newSrcsetOpts(WithMinWidth(300), WithMaxWidth(900), WithRounding(RoundCeil)).Validate())
+
+	tests := []struct {
+		options  []SrcsetOption
+		expected string
+	}{
+		{
+			[]SrcsetOption{WithWidths(400, 200)},
+			"srcset widths must be ascending, found `200` after `400` at index `1`",
+		},
+		{
+			[]SrcsetOption{WithWidths(200, 200)},
+			"srcset widths must be unique, found duplicate width `200` at index `1`",
+		},
+		{
+			[]SrcsetOption{WithWidths(200, 9000)},
+			"srcset width `9000` at index `1` is outside of the range [100, 8192]",
+		},
+		{
+			[]SrcsetOption{WithMinWidth(300), WithWidths(200, 400)},
+			"srcset width `200` at index `0` is outside of the range [300, 8192]",
+		},
+		{
+			[]SrcsetOption{WithMinWidth(900), WithMaxWidth(300)},
+			"`minWidth` must be less than or equal to the `maxWidth`",
+		},
+		{
+			[]SrcsetOption{WithWidths(100, 200, 300, 400, 500, 600), WithDescriptorKind(DPRDescriptor)},
+			"dpr descriptors require between 1 and 5 widths, found 6",
+		},
+	}
+
+	c := testClient()
+	for _, test := range tests {
+		err := newSrcsetOpts(test.options...).Validate()
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, test.expected, err.Error())
+
+		actual, err := c.CreateSrcsetE("image.png", []IxParam{}, test.options...)
+		assert.Equal(t, test.expected, err.Error())
+		assert.Equal(t, "", actual)
+	}
+}
+
+func TestURLBuilder_thinWidths(t *testing.T) {
+	widths := []int{100, 200, 300, 400, 500, 600, 700}
+	assert.Equal(t, widths, thinWidths(widths, 0))
+	assert.Equal(t, widths, thinWidths(widths, 7))
+	assert.Equal(t, []int{100, 400, 700}, thinWidths(widths, 3))
+	assert.Equal(t, []int{100, 700}, thinWidths(widths, 2))
+	assert.Equal(t, []int{700}, thinWidths(widths, 1))
+}
+
+func TestURLBuilder_CreateSrcsetWithDprQualities(t *testing.T) {
+	c := testClient()
+	srcset := c.CreateSrcset("image.png", []IxParam{Param("w", "100")},
+		WithDprQualities(map[int]int{1: 90, 3: 30}))
+	candidates := strings.Split(srcset, ",\n")
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=1&q=90&w=100 1x", candidates[0])
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=2&q=50&w=100 2x", candidates[1])
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=3&q=30&w=100 3x", candidates[2])
+}
+
+func TestURLBuilder_CreateSrcsetPaths(t *testing.T) {
+	c := testClientWithToken()
+	params := url.Values{"auto": {"format"}}
+	for _, options := range [][]SrcsetOption{
+		{},
+		{WithMinWidth(200), WithMaxWidth(800)},
+	} {
+		srcset := c.CreateSrcset("image.png", []IxParam{Param("auto", "format")}, options...)
+		candidates := strings.Split(srcset, ",\n")
+
+		paths := c.CreateSrcsetPaths("image.png", params, options...)
+		assert.Equal(t, len(candidates), len(paths))
+		for i, p := range paths {
+			assert.True(t, strings.HasPrefix(p, "/image.png?"))
+			assert.Contains(t, p, "&s=")
+			assert.True(t, strings.HasPrefix(candidates[i], "https://my-social-network.imgix.net"+p+" "))
+		}
+	}
+
+	paths := c.CreateSrcsetPaths("image.png", url.Values{"w": {"100"}})
+	assert.Equal(t, 5, len(paths))
+	assert.Equal(t, url.Values{"auto": {"format"}}, params)
+}
+
+func TestURLBuilder_CreateSrcsetWithSeparator(t *testing.T) {
+	c := testClient()
+	params := []IxParam{Param("w", "100")}
+
+	expected := strings.Split(c.CreateSrcset("image.png", params), ",\n")
+	for _, separator := range []string{", ", ",", ",\n\t", " , "} {
+		srcset := c.CreateSrcset("image.png", params, WithSeparator(separator))
+		assert.Equal(t, strings.Join(expected, separator), srcset)
+
+		// The srcset still parses into the same candidates.
+		var candidates []string
+		for _, c := range strings.Split(srcset, ",") {
+			candidates = append(candidates, strings.TrimSpace(c))
+		}
+		assert.Equal(t, expected, candidates)
+	}
+
+	actual, err := c.CreateSrcsetE("image.png", params, WithSeparator(" "))
+	assert.Equal(t, "", actual)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestURLBuilder_AutoFormatSrcset(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false))
+
+	tests := []struct {
+		params   []IxParam
+		expected string
+	}{
+		{nil, "auto=format"},
+		{[]IxParam{Param("auto", "format")}, "auto=format"},
+		{[]IxParam{Param("auto", "compress")}, "auto=compress%2Cformat"},
+		{[]IxParam{Param("auto", "enhance,compress")}, "auto=compress%2Cenhance%2Cformat"},
+		{[]IxParam{Param("auto", "compress"), Format(WebP)}, "auto=compress%2Cformat"},
+	}
+
+	for _, test := range tests {
+		srcset := u.AutoFormatSrcset("image.png", test.params, WithMinWidth(100), WithMaxWidth(200))
+		for _, candidate := range strings.Split(srcset, ",\n") {
+			assert.Contains(t, candidate, "?"+test.expected+"&w=")
+			assert.NotContains(t, candidate, "fm=")
+		}
+	}
+
+	// CreateSrcset doesn't inject an fm that would conflict with auto=format.
+	srcset := u.CreateSrcset("image.png", []IxParam{Param("auto", "format")}, WithMinWidth(100), WithMaxWidth(200))
+	assert.NotContains(t, srcset, "fm=")
+	assert.Equal(t, srcset, u.AutoFormatSrcset("image.png", nil, WithMinWidth(100), WithMaxWidth(200)))
+}
+
+func TestURLBuilder_AutoFormatSrcsetDefaultFormat(t *testing.T) {
+	defaults := url.Values{"fm": {"jpg"}, "q": {"60"}}
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithDefaultParams(defaults))
+
+	// The default fm would take precedence over auto=format, so it's
+	// removed, whereas the other defaults are kept.
+	srcset := u.AutoFormatSrcset("image.png", nil, WithWidths(100))
+	assert.Equal(t, "https://test.imgix.net/image.png?auto=format&q=60&w=100 100w", srcset)
+
+	// The builder itself is unaffected.
+	assert.Equal(t, "https://test.imgix.net/image.png?fm=jpg&q=60", u.CreateURL("image.png"))
+	assert.Equal(t, url.Values{"fm": {"jpg"}, "q": {"60"}}, defaults)
+}
+
+func TestURLBuilder_CreateSrcsetWithDescending(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false))
+
+	ascending := strings.Split(u.CreateSrcset("image.png", nil, WithWidths(100, 200, 300)), ",\n")
+	descending := strings.Split(u.CreateSrcset("image.png", nil, WithWidths(100, 200, 300), WithDescending(true)), ",\n")
+	assert.Equal(t, []string{
+		"https://test.imgix.net/image.png?w=300 300w",
+		"https://test.imgix.net/image.png?w=200 200w",
+		"https://test.imgix.net/image.png?w=100 100w",
+	}, descending)
+	for i := range ascending {
+		assert.Equal(t, ascending[i], descending[len(descending)-1-i])
+	}
+
+	// dpr-based srcsets are reversed too.
+	dpr := strings.Split(u.CreateSrcset("image.png", []IxParam{Param("w", "100")},
+		WithVariableQuality(false), WithDescending(true)), ",\n")
+	assert.Equal(t, 5, len(dpr))
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=5&w=100 5x", dpr[0])
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=1&w=100 1x", dpr[4])
+
+	// As are the paths, which match the srcset's order.
+	paths := u.CreateSrcsetPaths("image.png", url.Values{}, WithWidths(100, 200), WithDescending(true))
+	assert.Equal(t, []string{"/image.png?w=200", "/image.png?w=100"}, paths)
+}
+
+func TestURLBuilder_CreateSrcsetEValidatesUsedOptions(t *testing.T) {
+	c := testClient()
+	invalidWidths := []SrcsetOption{WithMinWidth(-1), WithTolerance(0)}
+	invalidQualities := WithDprQualities(map[int]int{9: 50})
+
+	// A dpr-based srcset ignores the width options...
+	srcset, err := c.CreateSrcsetE("image.png", []IxParam{Param("w", "100")}, invalidWidths...)
+	assert.NoError(t, err)
+	assert.Equal(t, c.CreateSrcset("image.png", []IxParam{Param("w", "100")}, invalidWidths...), srcset)
+	_, err = c.CreateSrcsetE("image.png", []IxParam{Param("w", "100")}, invalidQualities)
+	assert.Error(t, err)
+
+	// ...and a width-described srcset ignores the dpr qualities.
+	_, err = c.CreateSrcsetE("image.png", nil, invalidWidths...)
+	assert.Error(t, err)
+	_, err = c.CreateSrcsetE("image.png", nil, invalidQualities)
+	assert.NoError(t, err)
+
+	// LazyImage only checks the options that its srcset uses, too.
+	lazy, _ := c.LazyImage("image.png", []IxParam{Param("w", "100")}, "", invalidWidths...)
+	assert.Contains(t, lazy, "dpr=1")
+}
+
+func TestURLBuilder_CreateSrcsetEEmptyToken(t *testing.T) {
+	signAll := WithSignWhen(func(path string) bool { return true })
+
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), signAll)
+	actual, err := u.CreateSrcsetE("image.png", []IxParam{}, WithWidths(100, 200))
+	assert.True(t, errors.Is(err, ErrEmptyToken))
+	assert.Equal(t, "", actual)
+
+	// Once the token is set, the candidates are signed.
+	u.SetToken("FOO123bar")
+	actual, err = u.CreateSrcsetE("image.png", []IxParam{}, WithWidths(100, 200))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://test.imgix.net/image.png?w=100&s="+createMd5Signature("FOO123bar", "/image.png", "w=100")+" 100w,\n"+
+		"https://test.imgix.net/image.png?w=200&s="+createMd5Signature("FOO123bar", "/image.png", "w=200")+" 200w", actual)
+
+	// Without a sign-required builder, an empty token isn't an error.
+	c := testClient()
+	_, err = c.CreateSrcsetE("image.png", []IxParam{}, WithWidths(100, 200))
+	assert.NoError(t, err)
+}
+
+func TestSrcset_ProtocolRelative(t *testing.T) {
+	c := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false),
+		WithProtocolRelative())
+
+	for _, srcset := range []string{
+		c.CreateSrcset("image.png", []IxParam{Param("ar", "4:3")}),
+		c.CreateSrcset("image.png", []IxParam{Param("w", "320")}),
+	} {
+		entries := strings.Split(srcset, ",\n")
+		assert.True(t, len(entries) > 1)
+
+		for _, entry := range entries {
+			src := strings.Split(entry, " ")[0]
+			assert.True(t, strings.HasPrefix(src, "//test.imgix.net/image.png?"), src)
+
+			// The signature is computed exactly as for the https URL.
+			u, err := url.Parse("https:" + src)
+			assert.NoError(t, err)
+			i := strings.LastIndex(u.RawQuery, "&s=")
+			query, signature := u.RawQuery[:i], u.RawQuery[i+len("&s="):]
+			assert.Equal(t, createMd5Signature("FOO123bar", u.EscapedPath(), query), signature)
+		}
+	}
+}
+
+func TestURLBuilder_CreateSrcsetMergedParams(t *testing.T) {
+	// A default w makes the srcset dpr-based, just like a w param.
+	d := NewURLBuilder("test.imgix.net", WithLibParam(false),
+		WithDefaultParams(url.Values{"w": {"100"}}))
+	srcset := d.CreateSrcset("image.png", nil)
+	assert.Equal(t, 5, len(strings.Split(srcset, ",\n")))
+	assert.True(t, strings.HasPrefix(srcset, "https://test.imgix.net/image.png?dpr=1&q=75&w=100 1x"))
+
+	// So does a w that the rewriter adds.
+	r := NewURLBuilder("test.imgix.net", WithLibParam(false),
+		WithParamRewriter(func(path string, params url.Values) url.Values {
+			if params.Get("w") == "" {
+				params.Set("w", "100")
+			}
+			return params
+		}))
+	srcset = r.CreateSrcset("image.png", nil)
+	assert.True(t, strings.HasPrefix(srcset, "https://test.imgix.net/image.png?dpr=1&q=75&w=100 1x"))
+
+	// Without either, the srcset is width-described.
+	c := testClient()
+	srcset = c.CreateSrcset("image.png", nil)
+	assert.True(t, strings.HasPrefix(srcset, "https://test.imgix.net/image.png?w=100 100w"))
+}
+
+func TestURLBuilder_CreateSrcsetLongParamNames(t *testing.T) {
+	c := NewURLBuilder("test.imgix.net", WithLibParam(false), WithLongParamNames())
+
+	// A fixed width is still a fixed width once it's emitted as width.
+	srcset := c.CreateSrcset("image.png", []IxParam{Param("w", "300")})
+	candidates := strings.Split(srcset, ",\n")
+	assert.Equal(t, 5, len(candidates))
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=1&quality=75&width=300 1x", candidates[0])
+	assert.True(t, strings.HasSuffix(candidates[4], " 5x"))
+
+	// So is a width given in its long form.
+	srcset = c.CreateSrcset("image.png", []IxParam{Param("width", "300")})
+	assert.Equal(t, 5, len(strings.Split(srcset, ",\n")))
+}
@@ -0,0 +1,96 @@
+package imgix
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// structTag is the key of the struct tags read by ParamsFromStruct.
+const structTag = "imgix"
+
+// ParamsFromStruct returns the params described by the imgix struct tags
+// of v, which must be a struct or a pointer to one. For example:
+//
+//	type Transform struct {
+//		Width int     `imgix:"w"`
+//		Fit   string  `imgix:"fit"`
+//		DPR   float64 `imgix:"dpr"`
+//		Flip  bool    `imgix:"flip,keepempty"`
+//	}
+//
+// Each tagged field is emitted as the param named by its tag. Fields with
+// zero values are skipped unless tagged with the keepempty option, and
+// fields that are unexported, untagged, or tagged "-" are ignored. The
+// supported field types are strings, bools, and the integer and float
+// kinds; floats are formatted canonically (e.g. 2.0 as "2"). An error is
+// returned for any other type.
+func ParamsFromStruct(v interface{}) (url.Values, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("ParamsFromStruct requires a struct, found nil %s", rv.Type())
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("ParamsFromStruct requires a struct, found %T", v)
+	}
+
+	params := url.Values{}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag, ok := field.Tag.Lookup(structTag)
+		if !ok || tag == "-" || field.PkgPath != "" {
+			continue
+		}
+
+		key, options := parseStructTag(tag)
+		if key == "" {
+			return nil, fmt.Errorf("field `%s` has an empty imgix param name", field.Name)
+		}
+
+		fv := rv.Field(i)
+		value, err := formatStructField(fv)
+		if err != nil {
+			return nil, fmt.Errorf("field `%s`: %w", field.Name, err)
+		}
+
+		if fv.IsZero() && !options["keepempty"] {
+			continue
+		}
+		params.Set(key, value)
+	}
+	return params, nil
+}
+
+// parseStructTag splits an imgix struct tag into the param name and the
+// set of options that follow it, e.g. "flip,keepempty".
+func parseStructTag(tag string) (key string, options map[string]bool) {
+	parts := strings.Split(tag, ",")
+	options = make(map[string]bool, len(parts)-1)
+	for _, o := range parts[1:] {
+		options[strings.TrimSpace(o)] = true
+	}
+	return strings.TrimSpace(parts[0]), options
+}
+
+// formatStructField formats the value of a struct field as a param value.
+func formatStructField(fv reflect.Value) (string, error) {
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return formatFloat(fv.Float()), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", fv.Type())
+}
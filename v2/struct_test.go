@@ -0,0 +1,81 @@
+package imgix
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testTransform struct {
+	Width    int     `imgix:"w"`
+	Height   uint    `imgix:"h"`
+	Fit      string  `imgix:"fit"`
+	DPR      float64 `imgix:"dpr"`
+	Flip     bool    `imgix:"flip,keepempty"`
+	Blur     int     `imgix:"blur,keepempty"`
+	Ignored  string  `imgix:"-"`
+	Untagged string
+	hidden   string `imgix:"hidden"`
+}
+
+func TestStruct_ParamsFromStruct(t *testing.T) {
+	transform := testTransform{
+		Width:    400,
+		Fit:      "crop",
+		DPR:      2.0,
+		Ignored:  "x",
+		Untagged: "y",
+		hidden:   "z",
+	}
+
+	expected := url.Values{
+		"w":    {"400"},
+		"fit":  {"crop"},
+		"dpr":  {"2"},
+		"flip": {"false"},
+		"blur": {"0"},
+	}
+
+	params, err := ParamsFromStruct(transform)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, params)
+
+	params, err = ParamsFromStruct(&transform)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, params)
+
+	u := testBuilder()
+	assert.Equal(t, "https://test.imgix.net/image.png?blur=0&dpr=2&fit=crop&flip=false&w=400",
+		u.PurgeVariants("image.png", []url.Values{params})[0])
+}
+
+func TestStruct_ParamsFromStructFloats(t *testing.T) {
+	params, err := ParamsFromStruct(struct {
+		FocalX float32 `imgix:"fp-x"`
+		FocalY float64 `imgix:"fp-y"`
+	}{0.5, 0.33333})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, url.Values{"fp-x": {"0.5"}, "fp-y": {"0.333"}}, params)
+}
+
+func TestStruct_ParamsFromStructInvalid(t *testing.T) {
+	var nilTransform *testTransform
+	invalid := []interface{}{
+		nil,
+		nilTransform,
+		"w=400",
+		struct {
+			Widths []int `imgix:"w"`
+		}{},
+		struct {
+			Width int `imgix:",keepempty"`
+		}{},
+	}
+
+	for _, v := range invalid {
+		params, err := ParamsFromStruct(v)
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, 0, len(params))
+	}
+}
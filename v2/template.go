@@ -0,0 +1,119 @@
+package imgix
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// WithPathTemplate returns a BuilderOption that NewURLBuilder consumes.
+// The constructor uses this closure to set the pattern of the paths that
+// CreateURLFromFields creates, e.g. "/{bucket}/{id}/{variant}.jpg". Each
+// placeholder is a field name in braces, made up of letters, digits,
+// '-', and '_'. Everything outside of the placeholders is used as-is.
+// An invalid pattern (e.g. "/{id") is fatal.
+func WithPathTemplate(pattern string) BuilderOption {
+	return func(b *URLBuilder) {
+		if err := validatePathTemplate(pattern); err != nil {
+			log.Fatalln(err)
+		}
+		b.pathTemplate = pattern
+	}
+}
+
+// CreateURLFromFields creates a URL whose path is the builder's path
+// template (see WithPathTemplate) with each placeholder replaced by the
+// field of the same name, e.g. the fields {"bucket": "users", "id": "1",
+// "variant": "thumb"} fill "/{bucket}/{id}/{variant}.jpg" as
+// "/users/1/thumb.jpg". The filled path is then escaped and signed, and
+// the URL validated, exactly like the path passed to CreateURLE, so a
+// '/' in a field separates path segments. Fields that the template
+// doesn't use are ignored.
+//
+// An error is returned if the builder has no path template or if a
+// field that the template uses is missing or empty. The params passed to
+// this method are not modified.
+func (b *URLBuilder) CreateURLFromFields(fields map[string]string, params url.Values) (string, error) {
+	if b.pathTemplate == "" {
+		return "", errors.New("the builder has no path template; see WithPathTemplate")
+	}
+
+	path, err := fillPathTemplate(b.pathTemplate, fields)
+	if err != nil {
+		return "", err
+	}
+	return b.createURLFromValuesE(path, cloneValues(params))
+}
+
+// validatePathTemplate checks that the pattern is a valid path template
+// (see WithPathTemplate): its braces must enclose valid field names.
+func validatePathTemplate(pattern string) error {
+	_, err := expandPathTemplate(pattern, func(string) (string, error) { return "", nil })
+	return err
+}
+
+// fillPathTemplate replaces each placeholder in the pattern with the
+// field of the same name. An error is returned if the pattern is
+// invalid or if a field is missing or empty.
+func fillPathTemplate(pattern string, fields map[string]string) (string, error) {
+	return expandPathTemplate(pattern, func(name string) (string, error) {
+		if fields[name] == "" {
+			return "", fmt.Errorf("path template field `%s` is missing or empty", name)
+		}
+		return fields[name], nil
+	})
+}
+
+// expandPathTemplate replaces each placeholder in the pattern with the
+// value that the lookup returns for its field name. An error is returned
+// if the pattern is invalid or if the lookup fails.
+func expandPathTemplate(pattern string, lookup func(name string) (string, error)) (string, error) {
+	var sb strings.Builder
+	rest := pattern
+	for {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			sb.WriteString(rest)
+			return sb.String(), nil
+		}
+		if rest[open] == '}' {
+			return "", fmt.Errorf("path template `%s` has an unmatched `}`", pattern)
+		}
+
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] != '}' {
+			return "", fmt.Errorf("path template `%s` has an unterminated placeholder", pattern)
+		}
+
+		name := rest[open+1 : open+1+end]
+		if !isFieldName(name) {
+			return "", fmt.Errorf("path template `%s` has an invalid field name `%s`", pattern, name)
+		}
+
+		value, err := lookup(name)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(rest[:open])
+		sb.WriteString(value)
+		rest = rest[open+1+end+1:]
+	}
+}
+
+// isFieldName reports whether the name is a valid path template field
+// name: a non-empty run of letters, digits, '-', and '_'.
+func isFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		isAlnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !isAlnum && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
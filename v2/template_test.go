@@ -0,0 +1,56 @@
+package imgix
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate_CreateURLFromFields(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false),
+		WithPathTemplate("/{bucket}/{id}/{variant}.jpg"))
+
+	fields := map[string]string{"bucket": "users", "id": "1", "variant": "thumb", "unused": "x"}
+	actual, err := u.CreateURLFromFields(fields, url.Values{"w": {"400"}})
+	assert.NoError(t, err)
+
+	signature := createMd5Signature("FOO123bar", "/users/1/thumb.jpg", "w=400")
+	assert.Equal(t, "https://test.imgix.net/users/1/thumb.jpg?w=400&s="+signature, actual)
+
+	// The filled path is escaped like any other.
+	fields["variant"] = "large image"
+	actual, err = u.CreateURLFromFields(fields, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, actual, "/users/1/large%20image.jpg?s=")
+}
+
+func TestTemplate_CreateURLFromFieldsMissingField(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithPathTemplate("/{bucket}/{id}/{variant}.jpg"))
+
+	for _, fields := range []map[string]string{
+		{"bucket": "users", "variant": "thumb"},
+		{"bucket": "users", "id": "", "variant": "thumb"},
+		nil,
+	} {
+		actual, err := u.CreateURLFromFields(fields, nil)
+		assert.Error(t, err)
+		assert.Equal(t, "", actual)
+	}
+
+	_, err := u.CreateURLFromFields(map[string]string{"bucket": "users", "variant": "thumb"}, nil)
+	assert.EqualError(t, err, "path template field `id` is missing or empty")
+
+	c := testClient()
+	_, err = c.CreateURLFromFields(map[string]string{"id": "1"}, nil)
+	assert.Error(t, err)
+}
+
+func TestTemplate_validatePathTemplate(t *testing.T) {
+	for _, pattern := range []string{"/image.jpg", "/{id}", "/{a-b}/{c_d}/{E1}.png"} {
+		assert.NoError(t, validatePathTemplate(pattern))
+	}
+	for _, pattern := range []string{"/{id", "/id}", "/{}", "/{a b}", "/{a{b}}"} {
+		assert.Error(t, validatePathTemplate(pattern))
+	}
+}
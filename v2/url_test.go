@@ -1,7 +1,16 @@
 package imgix
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -66,7 +75,7 @@ func TestURL_Base64WithUnicodeParam(t *testing.T) {
 
 func TestURL_WithRepeatedParamValues(t *testing.T) {
 	u := testBuilder()
-	expected := "https://test.imgix.net?auto=format%2Ccompress"
+	expected := "https://test.imgix.net/?auto=format%2Ccompress"
 	actual := u.CreateURL("", Param("auto", "format", "compress"))
 	assert.Equal(t, expected, actual)
 }
@@ -117,3 +126,1123 @@ func TestURL_SigningFullyQualifiedWithParams(t *testing.T) {
 	actual := u.CreateURL("/http%3A%2F%2Favatars.com%2Fjohn-smith.png", params...)
 	assert.Equal(t, expected, actual)
 }
+
+func TestURL_DefaultMaxURLLength(t *testing.T) {
+	u := testBuilder()
+	assert.Equal(t, DefaultMaxURLLength, u.MaxURLLength())
+}
+
+func TestURL_CreateURLEWithinMaxURLLength(t *testing.T) {
+	u := testBuilder()
+	expected := "https://test.imgix.net/image.png?w=100"
+	actual, err := u.CreateURLE("image.png", Param("w", "100"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestURL_CreateURLEExceedsMaxURLLength(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxURLLength(64))
+	source := "https://s3.amazonaws.com/bucket/image.png?X-Amz-Signature=" +
+		strings.Repeat("a", 64)
+
+	actual, err := u.CreateURLE(source)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, "", actual)
+	assert.Contains(t, err.Error(), "exceeds the maximum of 64")
+	assert.Contains(t, err.Error(), "url64")
+
+	// CreateURL does not enforce the maximum URL length.
+	assert.Greater(t, len(u.CreateURL(source)), 64)
+}
+
+func TestURL_CreateURLEMaxURLLengthDisabled(t *testing.T) {
+	u := testBuilder()
+	u.SetMaxURLLength(0)
+	path := strings.Repeat("a", DefaultMaxURLLength)
+
+	actual, err := u.CreateURLE(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://test.imgix.net/"+path, actual)
+}
+
+func TestURL_BuildProxyURLParam(t *testing.T) {
+	u := testBuilder()
+	const source = "https://s3.amazonaws.com/bucket/image.png?X-Amz-Date=20201007"
+	params := url.Values{"w": []string{"400"}}
+
+	actual := u.BuildProxyURLParam(source, params)
+	expected := "https://test.imgix.net/?url64=" +
+		"aHR0cHM6Ly9zMy5hbWF6b25hd3MuY29tL2J1Y2tldC9pbWFnZS5wbmc_WC1BbXotRGF0ZT0yMDIwMTAwNw" +
+		"&w=400"
+	assert.Equal(t, expected, actual)
+
+	// The caller's params are left untouched.
+	assert.Equal(t, url.Values{"w": []string{"400"}}, params)
+
+	// Round-trip the encoded source back to the original.
+	parsed, err := url.Parse(actual)
+	assert.Equal(t, nil, err)
+	decoded, err := base64.RawURLEncoding.DecodeString(parsed.Query().Get("url64"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, source, string(decoded))
+}
+
+func TestURL_BuildProxyURLParamSigned(t *testing.T) {
+	u := NewURLBuilder(
+		"my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false))
+	const source = "http://avatars.com/john-smith.png"
+
+	actual := u.BuildProxyURLParam(source, nil)
+	encodedSource := base64EncodeQueryParamValue(source)
+	query := "url64=" + encodedSource
+	signature := createMd5Signature("FOO123bar", "/", query)
+
+	expected := "https://my-social-network.imgix.net/?" + query + "&s=" + signature
+	assert.Equal(t, expected, actual)
+}
+
+func TestURL_DomainIsLowercased(t *testing.T) {
+	u := NewURLBuilder("MyCo.Imgix.Net", WithLibParam(false))
+	assert.Equal(t, "myco.imgix.net", u.Domain())
+
+	expected := "https://myco.imgix.net/image.png?w=100"
+	actual := u.CreateURL("image.png", Param("w", "100"))
+	assert.Equal(t, expected, actual)
+
+	// The signature doesn't cover the host, so signed URLs only differ
+	// by the (now canonical) host.
+	signed := NewURLBuilder("MyCo.Imgix.Net", WithToken("FOO123bar"), WithLibParam(false))
+	canonical := NewURLBuilder("myco.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	assert.Equal(t, canonical.CreateURL("image.png"), signed.CreateURL("image.png"))
+}
+
+func TestURL_DomainWithPort(t *testing.T) {
+	u := NewURLBuilder("localhost:8080", WithLibParam(false), WithHTTPS(false))
+	assert.Equal(t, "http://localhost:8080/image.png?w=100", u.CreateURL("image.png", Param("w", "100")))
+
+	u = NewURLBuilder("127.0.0.1:9000", WithLibParam(false), WithHTTPS(false))
+	assert.Equal(t, "http://127.0.0.1:9000/image.png?w=100", u.CreateURL("image.png", Param("w", "100")))
+
+	u = NewURLBuilder("http://127.0.0.1:9000", WithLibParam(false))
+	assert.Equal(t, "https://127.0.0.1:9000/image.png", u.CreateURL("image.png"))
+}
+
+func TestURL_DomainIPv6(t *testing.T) {
+	u := NewURLBuilder("[::1]:8080", WithLibParam(false), WithHTTPS(false))
+	expected := "http://[::1]:8080/image.png?w=100"
+	actual := u.CreateURL("image.png", Param("w", "100"))
+	assert.Equal(t, expected, actual)
+
+	parsed, err := url.Parse(actual)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "::1", parsed.Hostname())
+	assert.Equal(t, "8080", parsed.Port())
+
+	u = NewURLBuilder("::1", WithLibParam(false))
+	assert.Equal(t, "https://[::1]/image.png", u.CreateURL("image.png"))
+}
+
+func TestURL_WithSignWhen(t *testing.T) {
+	u := NewURLBuilder(
+		"my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithSignWhen(func(path string) bool {
+			return strings.HasPrefix(path, "/users/")
+		}))
+
+	params := []IxParam{Param("h", "300"), Param("w", "400")}
+	expected := "https://my-social-network.imgix.net/users/1.png?h=300&w=400&s=1a4e48641614d1109c6a7af51be23d18"
+	assert.Equal(t, expected, u.CreateURL("/users/1.png", params...))
+
+	expected = "https://my-social-network.imgix.net/public/1.png?h=300&w=400"
+	assert.Equal(t, expected, u.CreateURL("/public/1.png", params...))
+}
+
+func TestURL_WithUnsignedTrailingParams(t *testing.T) {
+	trailing := url.Values{"cb": {"1602000000"}}
+	u := NewURLBuilder(
+		"my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithUnsignedTrailingParams(trailing))
+
+	// The signature is identical to the one computed without the
+	// trailing params (see TestURL_BluePrintSigningWithParams).
+	expected := "https://my-social-network.imgix.net/users/1.png" +
+		"?h=300&w=400&s=1a4e48641614d1109c6a7af51be23d18&cb=1602000000"
+	actual := u.CreateURL("/users/1.png", Param("h", "300"), Param("w", "400"))
+	assert.Equal(t, expected, actual)
+
+	signature := createMd5Signature("FOO123bar", "/users/1.png", "h=300&w=400")
+	assert.Equal(t, "1a4e48641614d1109c6a7af51be23d18", signature)
+
+	expected = "https://my-social-network.imgix.net/users/1.png" +
+		"?s=6797c24146142d5b40bde3141fd3600c&cb=1602000000"
+	actual = u.CreateURL("/users/1.png")
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, "6797c24146142d5b40bde3141fd3600c",
+		createMd5Signature("FOO123bar", "/users/1.png", ""))
+}
+
+func TestURL_WithUnsignedTrailingParamsUnsigned(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net",
+		WithLibParam(false),
+		WithUnsignedTrailingParams(url.Values{"cb": {"1"}}))
+	assert.Equal(t, "https://test.imgix.net/image.png?cb=1", u.CreateURL("image.png"))
+}
+
+func TestURL_WithMaxDPR(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxDPR(3))
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=3&w=100",
+		u.CreateURL("image.png", Param("w", "100"), Param("dpr", "5")))
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=2.5&w=100",
+		u.CreateURL("image.png", Param("w", "100"), Param("dpr", "2.5")))
+}
+
+func TestURL_WithMaxDPRLogsInValidationMode(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	u := NewURLBuilder("test.imgix.net",
+		WithLibParam(false),
+		WithMaxDPR(2),
+		WithValidation(ValidationWarn))
+	assert.Equal(t, "https://test.imgix.net/image.png?dpr=2", u.CreateURL("image.png", DPR(4)))
+	assert.Contains(t, buf.String(), "clamped `dpr=4` to the maximum dpr of 2")
+}
+
+func TestURL_WithEmptyParams(t *testing.T) {
+	params := []IxParam{Param("flag", ""), Param("w", "100")}
+	tests := []struct {
+		mode  EmptyParamMode
+		query string
+	}{
+		{EmitEmptyParams, "flag=&w=100"},
+		{DropEmptyParams, "w=100"},
+		{BareEmptyParams, "flag&w=100"},
+	}
+
+	for _, test := range tests {
+		u := NewURLBuilder(
+			"my-social-network.imgix.net",
+			WithToken("FOO123bar"),
+			WithLibParam(false),
+			WithEmptyParams(test.mode))
+
+		signature := createMd5Signature("FOO123bar", "/image.png", test.query)
+		expected := "https://my-social-network.imgix.net/image.png?" + test.query + "&s=" + signature
+		assert.Equal(t, expected, u.CreateURL("image.png", params...))
+	}
+}
+
+func TestURL_EmptyParamsDefault(t *testing.T) {
+	u := testBuilder()
+	assert.Equal(t, "https://test.imgix.net/image.png?flag=", u.CreateURL("image.png", Param("flag", "")))
+}
+
+func TestURL_WithFormatQuality(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net",
+		WithLibParam(false),
+		WithFormatQuality(map[ImageFormat]int{WebP: 45, AVIF: 45, JPG: 75}),
+		WithDefaultQuality(60))
+
+	tests := []struct {
+		path     string
+		params   []IxParam
+		expected string
+	}{
+		{"image.jpg", nil, "https://test.imgix.net/image.jpg?q=75"},
+		{"image.jpg", []IxParam{Format(WebP)}, "https://test.imgix.net/image.jpg?fm=webp&q=45"},
+		{"image.png", []IxParam{Format(AVIF)}, "https://test.imgix.net/image.png?fm=avif&q=45"},
+		// Unknown formats fall back to the default quality.
+		{"image.png", nil, "https://test.imgix.net/image.png?q=60"},
+		{"image.jpg", []IxParam{Param("auto", "format")}, "https://test.imgix.net/image.jpg?auto=format&q=60"},
+		// An explicit q is never overridden.
+		{"image.jpg", []IxParam{Param("q", "90")}, "https://test.imgix.net/image.jpg?q=90"},
+		{"image.jpg", []IxParam{Format(WebP), Param("q", "90")}, "https://test.imgix.net/image.jpg?fm=webp&q=90"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, u.CreateURL(test.path, test.params...))
+	}
+}
+
+func TestURL_WithFormatQualityNoDefault(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net",
+		WithLibParam(false),
+		WithFormatQuality(map[ImageFormat]int{JPG: 75}))
+	assert.Equal(t, "https://test.imgix.net/image.png", u.CreateURL("image.png"))
+}
+
+func TestURL_WithAggressivePathEscaping(t *testing.T) {
+	const path = "users/~jane/me@2x.png"
+
+	u := testBuilder()
+	assert.Equal(t, "https://test.imgix.net/users/~jane/me@2x.png", u.CreateURL(path))
+
+	u = NewURLBuilder("my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithAggressivePathEscaping())
+	expected := "https://my-social-network.imgix.net/users/%7Ejane/me%402x.png?s=" +
+		createMd5Signature("FOO123bar", "/users/%7Ejane/me%402x.png", "")
+	assert.Equal(t, expected, u.CreateURL(path))
+}
+
+func TestURL_WithAggressivePathEscapingExtra(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithAggressivePathEscaping('!'))
+	assert.Equal(t, "https://test.imgix.net/a%21b%40c%3Dd.png", u.CreateURL("a!b@c=d.png"))
+}
+
+func TestURL_PurgeVariants(t *testing.T) {
+	u := NewURLBuilder("my-social-network.imgix.net", WithToken("FOO123bar"))
+	variants := []url.Values{
+		{"w": {"400"}, "h": {"300"}},
+		{"auto": {"format", "compress"}, "txt64": {"Hello, 世界"}},
+		{},
+	}
+
+	expected := []string{
+		u.CreateURL("users/1.png", Param("w", "400"), Param("h", "300")),
+		u.CreateURL("users/1.png", Param("auto", "format", "compress"), Param("txt64", "Hello, 世界")),
+		u.CreateURL("users/1.png"),
+	}
+	assert.Equal(t, expected, u.PurgeVariants("users/1.png", variants))
+
+	// The variants are left untouched (e.g. no ixlib param is added).
+	assert.Equal(t, url.Values{"w": {"400"}, "h": {"300"}}, variants[0])
+}
+
+func TestURL_NilParams(t *testing.T) {
+	u := testBuilder()
+	expected := "https://test.imgix.net/image.png"
+
+	assert.Equal(t, expected, u.CreateURL("image.png", nil))
+	assert.Equal(t, expected, u.CreateURL("image.png", []IxParam(nil)...))
+	assert.Equal(t, "https://test.imgix.net/image.png?w=100", u.CreateURL("image.png", nil, Param("w", "100")))
+
+	actual, err := u.CreateURLE("image.png", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, actual)
+
+	assert.Equal(t, expected, u.createURLFromValues("image.png", nil))
+	assert.Equal(t, "https://test.imgix.net/?url64=YS5wbmc", u.BuildProxyURLParam("a.png", nil))
+	assert.Equal(t, []string{expected}, u.PurgeVariants("image.png", []url.Values{nil}))
+}
+
+func TestURL_DebugSignatureBase(t *testing.T) {
+	u := NewURLBuilder(
+		"my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false))
+
+	params := []IxParam{Param("w", "400"), Param("h", "300")}
+	assert.Equal(t, "FOO123bar/users/1.png?h=300&w=400", u.DebugSignatureBase("users/1.png", params...))
+
+	// The blueprint's fully-qualified proxy example.
+	expected := "FOO123bar/http%3A%2F%2Favatars.com%2Fjohn-smith.png?h=300&w=400"
+	actual := u.DebugSignatureBase("/http%3A%2F%2Favatars.com%2Fjohn-smith.png", params...)
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, "a201fe1a3caef4944dcb40f6ce99e746", createMd5Signature("FOO123bar",
+		"/http%3A%2F%2Favatars.com%2Fjohn-smith.png", "h=300&w=400"))
+
+	unsigned := testBuilder()
+	assert.Equal(t, "", unsigned.DebugSignatureBase("users/1.png", params...))
+}
+
+func TestURL_WithParamOrder(t *testing.T) {
+	u := NewURLBuilder(
+		"my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithParamOrder("w", "h"))
+
+	expected := "https://my-social-network.imgix.net/users/1.png?w=400&h=300&fit=crop&s=" +
+		createMd5Signature("FOO123bar", "/users/1.png", "w=400&h=300&fit=crop")
+	actual := u.CreateURL("users/1.png", Param("fit", "crop"), Param("h", "300"), Param("w", "400"))
+	assert.Equal(t, expected, actual)
+}
+
+func TestURL_WithLogicalParamOrder(t *testing.T) {
+	u := NewURLBuilder("my-social-network.imgix.net", WithToken("FOO123bar"),
+		WithLibraryParamValue("test-1.0"), WithLogicalParamOrder())
+
+	actual := u.CreateURL("users/1.png",
+		Param("auto", "format"), Param("txt", "hi"), Param("sat", "-20"), Param("custom", "1"),
+		Param("fm", "webp"), Param("crop", "faces"), Param("fit", "crop"), Param("blur", "10"),
+		Param("rot", "90"), Param("h", "300"), Param("w", "400"), Param("mark", "logo.png"))
+
+	query := "w=400&h=300&fit=crop&crop=faces&rot=90&sat=-20&blur=10&mark=logo.png&txt=hi" +
+		"&auto=format&fm=webp&custom=1&ixlib=test-1.0"
+	expected := "https://my-social-network.imgix.net/users/1.png?" + query + "&s=" +
+		createMd5Signature("FOO123bar", "/users/1.png", query)
+	assert.Equal(t, expected, actual)
+}
+
+func TestURL_WithLogicalParamOrderExtended(t *testing.T) {
+	defer func(order []ParamGroup) { LogicalParamOrder = order }(LogicalParamOrder)
+	LogicalParamOrder = append([]ParamGroup{{"custom", []string{"zz"}}}, LogicalParamOrder...)
+
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithLogicalParamOrder())
+	assert.Equal(t, "https://test.imgix.net/image.png?zz=1&w=400&aa=1",
+		u.CreateURL("image.png", Param("aa", "1"), Param("w", "400"), Param("zz", "1")))
+}
+
+func TestURL_WithStrictPathEncoding(t *testing.T) {
+	const path = "images/it's (1) & more!.png"
+
+	u := testBuilder()
+	assert.Equal(t, "https://test.imgix.net/images/it%27s%20%281%29%20&%20more%21.png", u.CreateURL(path))
+
+	u = NewURLBuilder("my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithStrictPathEncoding())
+	expected := "https://my-social-network.imgix.net/images/it's%20(1)%20%26%20more!.png?w=400&s=" +
+		createMd5Signature("FOO123bar", "/images/it's%20(1)%20%26%20more!.png", "w=400")
+	assert.Equal(t, expected, u.CreateURL(path, Param("w", "400")))
+}
+
+func TestURL_WithRequireSecureProxySource(t *testing.T) {
+	secure := NewURLBuilder("test.imgix.net", WithLibParam(false), WithRequireSecureProxySource())
+	permissive := testBuilder()
+
+	for _, source := range []string{
+		"http://example.com/image.png",
+		"/http://example.com/image.png",
+		"http%3A%2F%2Fexample.com%2Fimage.png",
+		"http%3a%2f%2fexample.com%2fimage.png",
+	} {
+		actual, err := secure.CreateURLE(source)
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, "", actual)
+
+		_, err = permissive.CreateURLE(source)
+		assert.Equal(t, nil, err)
+	}
+
+	for _, source := range []string{
+		"https://example.com/image.png",
+		"https%3A%2F%2Fexample.com%2Fimage.png",
+		"images/http.png",
+	} {
+		actual, err := secure.CreateURLE(source)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, permissive.CreateURL(source), actual)
+	}
+}
+
+func TestURL_WithBasePath(t *testing.T) {
+	u := NewURLBuilder("localhost:8080",
+		WithHTTPS(false),
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithBasePath("/imgix/"))
+
+	expected := "http://localhost:8080/imgix/users/1.png?w=400&s=" +
+		createMd5Signature("FOO123bar", "/imgix/users/1.png", "w=400")
+	assert.Equal(t, expected, u.CreateURL("users/1.png", Param("w", "400")))
+	assert.Equal(t, "FOO123bar/imgix/users/1.png?w=400", u.DebugSignatureBase("users/1.png", Param("w", "400")))
+
+	expected = "http://localhost:8080/imgix/https%3A%2F%2Fexample.com%2Fa.png?s=" +
+		createMd5Signature("FOO123bar", "/imgix/https%3A%2F%2Fexample.com%2Fa.png", "")
+	assert.Equal(t, expected, u.CreateURL("https://example.com/a.png"))
+}
+
+func TestURL_WithBasePathEmpty(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithBasePath("/"))
+	assert.Equal(t, "https://test.imgix.net/users/1.png", u.CreateURL("users/1.png"))
+}
+
+func TestURL_WithMaxDimensions(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxDimensions(4000, 3000))
+
+	for _, params := range [][]IxParam{
+		{Param("w", "4001")},
+		{Param("h", "3000.5")},
+		{Param("w", "100"), Param("h", "9000")},
+	} {
+		actual, err := u.CreateURLE("image.png", params...)
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, "", actual)
+	}
+
+	_, err := u.CreateURLE("image.png", Param("w", "4001"))
+	assert.Equal(t, "`w` of 4001 exceeds the maximum of 4000", err.Error())
+
+	for _, params := range [][]IxParam{
+		{Param("w", "4000"), Param("h", "3000")},
+		{Param("w", "0.5")},
+		{},
+	} {
+		_, err := u.CreateURLE("image.png", params...)
+		assert.Equal(t, nil, err)
+	}
+
+	// Zero is unbounded, and CreateURL is unaffected.
+	unbounded := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxDimensions(0, 3000))
+	_, err = unbounded.CreateURLE("image.png", Param("w", "9000"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://test.imgix.net/image.png?w=9000", u.CreateURL("image.png", Param("w", "9000")))
+}
+
+func TestURL_WithMaxDimensionsLongParamNames(t *testing.T) {
+	// A long-form width is capped like a w...
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxDimensions(1000, 1000))
+	actual, err := u.CreateURLE("a.png", Param("width", "5000"))
+	assert.EqualError(t, err, "`w` of 5000 exceeds the maximum of 1000")
+	assert.Equal(t, "", actual)
+
+	// ...and so is a w that the builder emits in its long form.
+	l := NewURLBuilder("test.imgix.net", WithLibParam(false), WithMaxDimensions(1000, 1000), WithLongParamNames())
+	for _, param := range []IxParam{Param("w", "5000"), Param("width", "5000"), Param("height", "1001")} {
+		_, err = l.CreateURLE("a.png", param)
+		assert.True(t, errors.Is(err, ErrValueOutOfRange))
+	}
+
+	actual, err = l.CreateURLE("a.png", Param("w", "1000"))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://test.imgix.net/a.png?width=1000", actual)
+}
+
+func TestURL_CreateURLFromURLAbsolute(t *testing.T) {
+	u := testBuilder()
+	source, err := url.Parse("https://example.com/images/a.png?v=2#top")
+	assert.Equal(t, nil, err)
+
+	expected := u.CreateURL("https://example.com/images/a.png?v=2", Param("w", "400"))
+	assert.Equal(t, expected, u.CreateURLFromURL(source, url.Values{"w": {"400"}}))
+	assert.Equal(t, "https://example.com/images/a.png?v=2#top", source.String())
+}
+
+func TestURL_CreateURLFromURLRelative(t *testing.T) {
+	u := testBuilder()
+	for _, raw := range []string{"/users/1.png", "users/1.png"} {
+		source, err := url.Parse(raw)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, u.CreateURL(raw, Param("w", "400")), u.CreateURLFromURL(source, url.Values{"w": {"400"}}))
+	}
+
+	// The path is decoded, so it isn't double-encoded.
+	source := &url.URL{Path: "/users/my photo.png"}
+	assert.Equal(t, "https://test.imgix.net/users/my%20photo.png", u.CreateURLFromURL(source, nil))
+
+	// The query's params are applied beneath the given params.
+	source, _ = url.Parse("/users/1.png?w=100&h=100")
+	expected := "https://test.imgix.net/users/1.png?h=100&w=400"
+	assert.Equal(t, expected, u.CreateURLFromURL(source, url.Values{"w": {"400"}}))
+}
+
+func TestURL_CreateURLFromURLNil(t *testing.T) {
+	u := testBuilder()
+	assert.Equal(t, "", u.CreateURLFromURL(nil, url.Values{"w": {"400"}}))
+}
+
+func TestURL_WithLibraryParamValue(t *testing.T) {
+	u := NewURLBuilder("my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibraryParamValue("go-test"))
+
+	expected := "https://my-social-network.imgix.net/users/1.png?ixlib=go-test&w=400&s=" +
+		createMd5Signature("FOO123bar", "/users/1.png", "ixlib=go-test&w=400")
+	assert.Equal(t, expected, u.CreateURL("users/1.png", Param("w", "400")))
+
+	u = NewURLBuilder("test.imgix.net")
+	assert.Equal(t, "https://test.imgix.net/users/1.png?ixlib="+LibVersion, u.CreateURL("users/1.png"))
+
+	u = NewURLBuilder("test.imgix.net", WithLibParam(false), WithLibraryParamValue("go-test"))
+	assert.Equal(t, "https://test.imgix.net/users/1.png", u.CreateURL("users/1.png"))
+}
+
+func TestURL_WithSignatureHexCase(t *testing.T) {
+	signature := createMd5Signature("FOO123bar", "/users/1.png", "w=400")
+	lower := "https://my-social-network.imgix.net/users/1.png?w=400&s=" + signature
+	upper := "https://my-social-network.imgix.net/users/1.png?w=400&s=" + strings.ToUpper(signature)
+	assert.NotEqual(t, lower, upper)
+
+	for _, test := range []struct {
+		options  []BuilderOption
+		expected string
+	}{
+		{[]BuilderOption{}, lower},
+		{[]BuilderOption{WithSignatureHexCase(HexLower)}, lower},
+		{[]BuilderOption{WithSignatureHexCase(HexUpper)}, upper},
+	} {
+		options := append([]BuilderOption{WithToken("FOO123bar"), WithLibParam(false)}, test.options...)
+		u := NewURLBuilder("my-social-network.imgix.net", options...)
+		assert.Equal(t, test.expected, u.CreateURL("users/1.png", Param("w", "400")))
+	}
+}
+
+func TestURL_RootPath(t *testing.T) {
+	u := testBuilder()
+	for _, path := range []string{"", "/"} {
+		assert.Equal(t, "https://test.imgix.net/", u.CreateURL(path))
+		assert.Equal(t, "https://test.imgix.net/?w=100", u.CreateURL(path, Param("w", "100")))
+	}
+
+	signed := NewURLBuilder("my-social-network.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	expected := "https://my-social-network.imgix.net/?w=100&s=" + createMd5Signature("FOO123bar", "/", "w=100")
+	for _, path := range []string{"", "/"} {
+		assert.Equal(t, expected, signed.CreateURL(path, Param("w", "100")))
+		actual, err := signed.CreateURLE(path, Param("w", "100"))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, expected, actual)
+	}
+	assert.Equal(t, "FOO123bar/?w=100", signed.DebugSignatureBase("", Param("w", "100")))
+}
+
+func TestURL_WithCollapseSlashes(t *testing.T) {
+	u := testBuilder()
+	assert.Equal(t, "https://test.imgix.net/a//b.jpg", u.CreateURL("/a//b.jpg"))
+
+	u = NewURLBuilder("my-social-network.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithCollapseSlashes())
+	expected := "https://my-social-network.imgix.net/a/b.jpg?s=" + createMd5Signature("FOO123bar", "/a/b.jpg", "")
+	assert.Equal(t, expected, u.CreateURL("/a//b.jpg"))
+	assert.Equal(t, expected, u.CreateURL("//a///b.jpg"))
+
+	// Proxy paths are never collapsed.
+	preserved := testBuilder()
+	proxied := NewURLBuilder("test.imgix.net", WithLibParam(false), WithCollapseSlashes())
+	for _, source := range []string{"https://example.com//a//b.jpg", "/https://example.com//a//b.jpg"} {
+		assert.Equal(t, preserved.CreateURL(source), proxied.CreateURL(source))
+	}
+	assert.Equal(t, "https://test.imgix.net/https%3A%2F%2Fexample.com%2F%2Fa%2F%2Fb.jpg",
+		proxied.CreateURL("https://example.com//a//b.jpg"))
+}
+
+func TestURL_WithDefaultFit(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithDefaultFit(FitCrop))
+
+	tests := []struct {
+		params   []IxParam
+		expected string
+	}{
+		{[]IxParam{Param("w", "400"), Param("h", "300")}, "https://test.imgix.net/a.png?fit=crop&h=300&w=400"},
+		{[]IxParam{Param("w", "400"), Param("h", "300"), Param("fit", "max")}, "https://test.imgix.net/a.png?fit=max&h=300&w=400"},
+		{[]IxParam{Param("w", "400")}, "https://test.imgix.net/a.png?w=400"},
+		{[]IxParam{Param("h", "300")}, "https://test.imgix.net/a.png?h=300"},
+		{[]IxParam{}, "https://test.imgix.net/a.png"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, u.CreateURL("a.png", test.params...))
+	}
+
+	// Without the option, imgix's default applies.
+	plain := testBuilder()
+	assert.Equal(t, "https://test.imgix.net/a.png?h=300&w=400", plain.CreateURL("a.png", Param("w", "400"), Param("h", "300")))
+
+	long := NewURLBuilder("test.imgix.net", WithLibParam(false), WithDefaultFit(FitCrop), WithLongParamNames())
+	assert.Equal(t, "https://test.imgix.net/a.png?fit=crop&height=300&width=400",
+		long.CreateURL("a.png", Param("w", "400"), Param("h", "300")))
+}
+
+func TestURL_URLLength(t *testing.T) {
+	u := NewURLBuilder("my-social-network.imgix.net", WithToken("FOO123bar"), WithMaxURLLength(160))
+	params := url.Values{"w": {"400"}, "txt64": {"Hello, 世界"}}
+
+	length, err := u.URLLength("users/1.png", params)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(u.CreateURL("users/1.png", Param("w", "400"), Param("txt64", "Hello, 世界"))), length)
+	assert.Equal(t, url.Values{"w": {"400"}, "txt64": {"Hello, 世界"}}, params)
+
+	long := "https://example.com/" + strings.Repeat("a", 160) + ".png"
+	length, err = u.URLLength(long, nil)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, len(u.CreateURL(long)), length)
+	assert.True(t, length > 160)
+}
+
+func TestURL_WithTreatPathAsLiteral(t *testing.T) {
+	u := testBuilder()
+	literal := NewURLBuilder("test.imgix.net", WithLibParam(false), WithTreatPathAsLiteral())
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"a%b.jpg", "https://test.imgix.net/a%25b.jpg"},
+		{"a%41.jpg", "https://test.imgix.net/a%2541.jpg"},
+		{"a%zz.jpg", "https://test.imgix.net/a%25zz.jpg"},
+		{"100%.jpg", "https://test.imgix.net/100%25.jpg"},
+		{"https://example.com/a%41.png", "https://test.imgix.net/https%3A%2F%2Fexample.com%2Fa%2541.png"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, literal.CreateURL(test.path))
+		assert.Equal(t, test.expected, u.CreateURL(test.path))
+	}
+
+	// A path that looks like a pre-encoded proxy source is only encoded
+	// when the option is set.
+	const encoded = "https%3A%2F%2Fexample.com%2Fa.png"
+	assert.Equal(t, "https://test.imgix.net/"+encoded, u.CreateURL(encoded))
+	assert.Equal(t, "https://test.imgix.net/https%253A%252F%252Fexample.com%252Fa.png", literal.CreateURL(encoded))
+
+	strict := NewURLBuilder("test.imgix.net", WithLibParam(false), WithTreatPathAsLiteral(), WithStrictPathEncoding())
+	assert.Equal(t, "https://test.imgix.net/https%253A%252F%252Fexample.com%252Fa.png", strict.CreateURL(encoded))
+}
+
+func TestURL_CreateURLOrdered(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+
+	pairs := []Pair{{"w", "400"}, {"auto", "format"}, {"fit", "crop"}, {"auto", "compress"}}
+	query := "w=400&auto=format%2Ccompress&fit=crop"
+	expected := "https://test.imgix.net/users/1.png?" + query + "&s=" +
+		createMd5Signature("FOO123bar", "/users/1.png", query)
+
+	// The same pairs always yield the same URL.
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, expected, u.CreateURLOrdered("users/1.png", pairs))
+	}
+
+	// Reordering the pairs reorders the query, and so the signature.
+	reordered := u.CreateURLOrdered("users/1.png", []Pair{{"fit", "crop"}, {"w", "400"}, {"auto", "format"}, {"auto", "compress"}})
+	assert.Equal(t, "https://test.imgix.net/users/1.png?fit=crop&w=400&auto=format%2Ccompress&s="+
+		createMd5Signature("FOO123bar", "/users/1.png", "fit=crop&w=400&auto=format%2Ccompress"), reordered)
+}
+
+func TestURL_CreateURLOrderedBuilderParams(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithParamOrder("q", "ixlib"))
+	u.SetUseLibParam(true)
+
+	actual := u.CreateURLOrdered("image.png", []Pair{{"w", "400"}, {"h", "300"}})
+	assert.Equal(t, "https://test.imgix.net/image.png?w=400&h=300&ixlib="+LibVersion, actual)
+}
+
+func TestURL_SignedParams(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibraryParamValue("test-1.0"),
+		WithDefaultParams(url.Values{"auto": {"format"}, "q": {"75"}}),
+		WithUnsignedTrailingParams(url.Values{"utm_source": {"email"}}))
+
+	params := url.Values{"auto": {"compress"}, "q": {"50"}, "w": {"400"}}
+	expected := url.Values{
+		"auto":  {"format", "compress"},
+		"q":     {"50"},
+		"w":     {"400"},
+		"ixlib": {"test-1.0"}}
+	assert.Equal(t, expected, u.SignedParams("users/1.png", params))
+
+	// The signed params are exactly those of the signed query.
+	actual := u.CreateURL("users/1.png", Param("auto", "compress"), Param("q", "50"), Param("w", "400"))
+	query := strings.Join(encodeQuery(expected), "&")
+	assert.Contains(t, actual, "?"+query+"&s="+createMd5Signature("FOO123bar", "/users/1.png", query)+"&utm_source=email")
+
+	// The params passed to SignedParams are not modified.
+	assert.Equal(t, url.Values{"auto": {"compress"}, "q": {"50"}, "w": {"400"}}, params)
+}
+
+func TestURL_SignedParamsUnsigned(t *testing.T) {
+	c := testClient()
+	assert.Nil(t, c.SignedParams("users/1.png", url.Values{"w": {"400"}}))
+
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"),
+		WithSignWhen(func(path string) bool { return false }))
+	assert.Nil(t, u.SignedParams("users/1.png", url.Values{"w": {"400"}}))
+}
+
+func TestURL_WithProtocolRelative(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false),
+		WithProtocolRelative())
+	signature := createMd5Signature("FOO123bar", "/users/1.png", "w=400")
+	assert.Equal(t, "//test.imgix.net/users/1.png?w=400&s="+signature,
+		u.CreateURL("users/1.png", Param("w", "400")))
+
+	// The option takes precedence over the scheme, and the display host
+	// is honored.
+	d := NewURLBuilder("test.imgix.net", WithLibParam(false), WithHTTPS(false),
+		WithProtocolRelative(), WithDisplayHost("images.example.com"))
+	assert.Equal(t, "//images.example.com/users/1.png", d.CreateURL("users/1.png"))
+}
+
+func TestURL_WithPreEscapedPath(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false),
+		WithPreEscapedPath())
+	signature := createMd5Signature("FOO123bar", "/users/my%20image.png", "w=400")
+	assert.Equal(t, "https://test.imgix.net/users/my%20image.png?w=400&s="+signature,
+		u.CreateURL("users/my%20image.png", Param("w", "400")))
+
+	// A leading slash is only added if it is missing.
+	assert.Equal(t, u.CreateURL("users/my%20image.png", Param("w", "400")),
+		u.CreateURL("/users/my%20image.png", Param("w", "400")))
+
+	// Without the option, the path is escaped again.
+	c := testClient()
+	assert.Equal(t, "https://test.imgix.net/users/my%2520image.png",
+		c.CreateURL("users/my%20image.png"))
+
+	// The option overrides the other path-escaping options, but the
+	// base path is still prepended.
+	s := NewURLBuilder("test.imgix.net", WithLibParam(false), WithPreEscapedPath(),
+		WithStrictPathEncoding(), WithAggressivePathEscaping('!'), WithBasePath("/imgix"))
+	assert.Equal(t, "https://test.imgix.net/imgix/a!b/my%20image.png",
+		s.CreateURL("a!b/my%20image.png"))
+}
+
+func TestURL_CreateExpiringURL(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	u.nowFunc = func() time.Time { return time.Unix(1700000000, 0) }
+
+	params := url.Values{"w": {"400"}, "exp": {"1"}}
+	actual, err := u.CreateExpiringURL("users/1.png", params, 5*time.Minute)
+	assert.NoError(t, err)
+
+	query := "exp=1700000300&w=400"
+	signature := createMd5Signature("FOO123bar", "/users/1.png", query)
+	assert.Equal(t, "https://test.imgix.net/users/1.png?"+query+"&s="+signature, actual)
+
+	// The expiry follows the clock.
+	u.nowFunc = func() time.Time { return time.Unix(1700000000, 0).Add(time.Hour) }
+	actual, err = u.CreateExpiringURL("users/1.png", params, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Contains(t, actual, "exp=1700003900&")
+
+	// The params passed to CreateExpiringURL are not modified.
+	assert.Equal(t, url.Values{"w": {"400"}, "exp": {"1"}}, params)
+}
+
+func TestURL_CreateExpiringURLDefaultClock(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	before := time.Now().Add(time.Minute).Unix()
+	actual, err := u.CreateExpiringURL("users/1.png", nil, time.Minute)
+	after := time.Now().Add(time.Minute).Unix()
+	assert.NoError(t, err)
+
+	parsed, err := ParseURL(actual)
+	assert.NoError(t, err)
+	exp, err := strconv.ParseInt(parsed.Params.Get("exp"), 10, 64)
+	assert.NoError(t, err)
+	assert.True(t, exp >= before && exp <= after)
+}
+
+func TestURL_CreateExpiringURLError(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"))
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		actual, err := u.CreateExpiringURL("users/1.png", nil, ttl)
+		assert.Error(t, err)
+		assert.Equal(t, "", actual)
+	}
+
+	c := testClient()
+	_, err := c.CreateExpiringURL("users/1.png", nil, time.Minute)
+	assert.True(t, errors.Is(err, ErrEmptyToken))
+
+	// A path that the builder doesn't sign can't expire either.
+	s := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"),
+		WithSignWhen(func(path string) bool { return strings.HasPrefix(path, "private/") }))
+	actual, err := s.CreateExpiringURL("public/1.png", nil, time.Minute)
+	assert.EqualError(t, err, "expiring urls must be signed, but path `public/1.png` isn't signed; see WithSignWhen")
+	assert.Equal(t, "", actual)
+
+	actual, err = s.CreateExpiringURL("private/1.png", nil, time.Minute)
+	assert.NoError(t, err)
+	assert.Contains(t, actual, "&s=")
+}
+
+func TestURL_WithOutputValidation(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithOutputValidation())
+
+	tests := []struct {
+		path   string
+		params []IxParam
+	}{
+		{"users/1 2#3?4+5%6.png", []IxParam{Param("txt", "100% #1 & more+"), Param("txt64", "😱 ☃")}},
+		{"https://example.com/a b.png?x=1&y=%20", []IxParam{Param("w", "400")}},
+		{"http%3A%2F%2Fexample.com%2Fimage.png", []IxParam{Param("mark64", "https://example.com/logo.png")}},
+		{"/ünïcödé/日本語.png", []IxParam{Param("auto", "format", "compress"), Param("blend", "#FF0000")}},
+		{"a;b=c,d@e:f/g.png", []IxParam{Param("ar", "16:9"), Param("", "")}},
+	}
+
+	for _, test := range tests {
+		actual, err := u.CreateURLE(test.path, test.params...)
+		assert.NoError(t, err, test.path)
+		assert.Equal(t, u.CreateURL(test.path, test.params...), actual)
+	}
+}
+
+func TestURL_WithOutputValidationBadEncoding(t *testing.T) {
+	// Pre-escaped paths stand in for a faulty encoder that lets
+	// characters slip through unescaped.
+	u := NewURLBuilder("test.imgix.net", WithPreEscapedPath(), WithOutputValidation())
+	for _, path := range []string{"users/1 2.png", "users/%zz.png", "users/ü.png"} {
+		actual, err := u.CreateURLE(path, Param("w", "400"))
+		assert.True(t, errors.Is(err, ErrMalformedURL), path)
+		assert.Equal(t, "", actual)
+
+		_, err = u.Build(path, url.Values{"w": {"400"}})
+		assert.True(t, errors.Is(err, ErrMalformedURL), path)
+	}
+
+	// Without output validation, the URLs are returned as-is.
+	p := NewURLBuilder("test.imgix.net", WithLibParam(false), WithPreEscapedPath())
+	actual, err := p.CreateURLE("users/1 2.png")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://test.imgix.net/users/1 2.png", actual)
+}
+
+func TestURL_WithParamRewriter(t *testing.T) {
+	var paths []string
+	u := NewURLBuilder("test.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibraryParamValue("test-1.0"),
+		WithDefaultParams(url.Values{"q": {"75"}}),
+		WithParamRewriter(func(path string, params url.Values) url.Values {
+			paths = append(paths, path)
+			params.Set("auto", "format")
+			params.Del("secret")
+			return params
+		}))
+
+	params := url.Values{"w": {"400"}, "secret": {"1"}}
+	actual := u.CreateURL("users/1.png", Param("w", "400"), Param("secret", "1"))
+
+	// The rewritten params (including the defaults) are emitted and signed.
+	query := "auto=format&ixlib=test-1.0&q=75&w=400"
+	expected := "https://test.imgix.net/users/1.png?" + query + "&s=" +
+		createMd5Signature("FOO123bar", "/users/1.png", query)
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, []string{"users/1.png"}, paths)
+
+	// Every srcset candidate is rewritten.
+	srcset := u.CreateSrcsetFromWidths("users/1.png", nil, []int{100, 200})
+	assert.Equal(t, 2, strings.Count(srcset, "auto=format"))
+
+	// The caller's params are never passed to the rewriter.
+	_, err := u.Build("users/1.png", params)
+	assert.NoError(t, err)
+	assert.Equal(t, url.Values{"w": {"400"}, "secret": {"1"}}, params)
+}
+
+func TestURL_WithParamRewriterShared(t *testing.T) {
+	shared := url.Values{"w": {"100"}}
+	u := NewURLBuilder("test.imgix.net", WithParamRewriter(func(string, url.Values) url.Values {
+		return shared
+	}))
+	assert.Equal(t, "https://test.imgix.net/image.png?ixlib="+LibVersion+"&w=100",
+		u.CreateURL("image.png", Param("h", "300")))
+	assert.Equal(t, url.Values{"w": {"100"}}, shared)
+
+	// A nil result leaves the params unchanged.
+	n := NewURLBuilder("test.imgix.net", WithLibParam(false),
+		WithParamRewriter(func(string, url.Values) url.Values { return nil }))
+	assert.Equal(t, "https://test.imgix.net/image.png?h=300", n.CreateURL("image.png", Param("h", "300")))
+}
+
+func TestURL_Build(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibraryParamValue("test-1.0"),
+		WithSignatureHexCase(HexUpper),
+		WithUnsignedTrailingParams(url.Values{"utm_source": {"email"}}))
+
+	params := url.Values{"w": {"400"}, "txt": {"hello world"}}
+	result, err := u.Build("users/1 2.png", params)
+	assert.NoError(t, err)
+
+	query := "ixlib=test-1.0&txt=hello+world&w=400"
+	signature := strings.ToUpper(createMd5Signature("FOO123bar", "/users/1%202.png", query))
+	assert.Equal(t, "/users/1%202.png", result.Path)
+	assert.Equal(t, query, result.Query)
+	assert.Equal(t, "{TOKEN}/users/1%202.png?"+query, result.SignatureBase)
+	assert.Equal(t, signature, result.Signature)
+	assert.Equal(t, "https://test.imgix.net/users/1%202.png?"+query+"&s="+signature+"&utm_source=email",
+		result.URL)
+
+	// The URL is exactly the one that CreateURLE creates.
+	expected, err := u.CreateURLE("users/1 2.png", Param("w", "400"), Param("txt", "hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result.URL)
+
+	// The token never appears in the result.
+	assert.NotContains(t, result.SignatureBase, "FOO123bar")
+
+	// The params passed to Build are not modified.
+	assert.Equal(t, url.Values{"w": {"400"}, "txt": {"hello world"}}, params)
+}
+
+func TestURL_BuildUnsigned(t *testing.T) {
+	c := testClient()
+	result, err := c.Build("users/1.png", url.Values{"w": {"400"}})
+	assert.NoError(t, err)
+	assert.Equal(t, BuildResult{
+		Path:  "/users/1.png",
+		Query: "w=400",
+		URL:   "https://test.imgix.net/users/1.png?w=400"}, result)
+}
+
+func TestURL_BuildError(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithMaxURLLength(20))
+	result, err := u.Build("users/1.png", url.Values{"w": {"400"}})
+	assert.True(t, errors.Is(err, ErrURLTooLong))
+	assert.Equal(t, BuildResult{}, result)
+
+	s := NewURLBuilder("test.imgix.net", WithSignWhen(func(string) bool { return true }))
+	_, err = s.Build("users/1.png", nil)
+	assert.True(t, errors.Is(err, ErrEmptyToken))
+}
+
+func TestURL_WithSignatureParamName(t *testing.T) {
+	signature := createMd5Signature("FOO123bar", "/users/1.png", "w=400")
+
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false),
+		WithSignatureParamName("sig"))
+	assert.Equal(t, "https://test.imgix.net/users/1.png?w=400&sig="+signature,
+		u.CreateURL("users/1.png", Param("w", "400")))
+
+	// The default name is s, and the signature is identical.
+	d := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	assert.Equal(t, "https://test.imgix.net/users/1.png?w=400&s="+signature,
+		d.CreateURL("users/1.png", Param("w", "400")))
+}
+
+func TestURL_WithDisplayHostAndSignedPathPrefix(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithDisplayHost("www.Example.com"),
+		WithSignedPathPrefix("/cdn/"))
+
+	// The emitted host and prefix differ from the signed path, which is
+	// the one imgix sees once the proxy has rewritten the request.
+	signature := createMd5Signature("FOO123bar", "/users/1.png", "w=400")
+	assert.Equal(t, "https://www.example.com/cdn/users/1.png?w=400&s="+signature,
+		u.CreateURL("users/1.png", Param("w", "400")))
+
+	d := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false))
+	assert.Equal(t, "https://test.imgix.net/users/1.png?w=400&s="+signature,
+		d.CreateURL("users/1.png", Param("w", "400")))
+
+	// Srcset paths keep the prefix, as the host is prepended later.
+	paths := u.CreateSrcsetPaths("users/1.png", url.Values{}, WithWidths(100))
+	assert.Equal(t, []string{"/cdn/users/1.png?w=100&s=" +
+		createMd5Signature("FOO123bar", "/users/1.png", "w=100")}, paths)
+}
+
+func TestURL_WithSignedPathPrefixAndBasePath(t *testing.T) {
+	u := NewURLBuilder("localhost:8080",
+		WithToken("FOO123bar"),
+		WithLibParam(false),
+		WithBasePath("imgix"),
+		WithSignedPathPrefix("cdn"))
+
+	// The base path is signed, but the prefix isn't.
+	assert.Equal(t, "https://localhost:8080/cdn/imgix/users/1.png?w=400&s="+
+		createMd5Signature("FOO123bar", "/imgix/users/1.png", "w=400"),
+		u.CreateURL("users/1.png", Param("w", "400")))
+
+	// An empty prefix is no prefix.
+	e := NewURLBuilder("test.imgix.net", WithLibParam(false), WithSignedPathPrefix("/"))
+	assert.Equal(t, "https://test.imgix.net/users/1.png", e.CreateURL("users/1.png"))
+}
+
+func TestURL_WithCacheBust(t *testing.T) {
+	source := WithCacheBustSource(func() string { return "k1x3" })
+	u := NewURLBuilder("test.imgix.net", WithToken("FOO123bar"), WithLibParam(false), WithCacheBust(true), source)
+	assert.Equal(t, "https://test.imgix.net/users/1.png?v=k1x3&w=400&s="+
+		createMd5Signature("FOO123bar", "/users/1.png", "v=k1x3&w=400"),
+		u.CreateURL("users/1.png", Param("w", "400"), Bust("1")))
+
+	d := NewURLBuilder("test.imgix.net", WithLibParam(false), WithCacheBust(false), source)
+	assert.Equal(t, "https://test.imgix.net/users/1.png?w=400", d.CreateURL("users/1.png", Param("w", "400")))
+}
+
+func TestURL_WithCacheBustSource(t *testing.T) {
+	n := 0
+	counter := func() string {
+		n++
+		return strconv.Itoa(n)
+	}
+
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithCacheBust(true), WithCacheBustSource(counter))
+	assert.Equal(t, "https://test.imgix.net/users/1.png?v=1", u.CreateURL("users/1.png"))
+	assert.Equal(t, "https://test.imgix.net/users/1.png?v=2", u.CreateURL("users/1.png"))
+
+	// Each builder has its own source.
+	d := NewURLBuilder("test.imgix.net", WithLibParam(false), WithCacheBust(true),
+		WithCacheBustSource(func() string { return "deploy-42" }))
+	assert.Equal(t, "https://test.imgix.net/users/1.png?v=deploy-42", d.CreateURL("users/1.png"))
+	assert.Equal(t, "https://test.imgix.net/users/1.png?v=3", u.CreateURL("users/1.png"))
+}
+
+func TestURL_WithCacheBustDefaultSource(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithCacheBust(true))
+	parsed, err := ParseURL(u.CreateURL("users/1.png"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, parsed.Params.Get("v"))
+}
+
+func TestURL_EffectiveQuality(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net",
+		WithFormatQuality(map[ImageFormat]int{WebP: 45, JPG: 75}),
+		WithDefaultQuality(60))
+
+	tests := []struct {
+		path     string
+		params   url.Values
+		expected int
+	}{
+		// An explicit q takes precedence over every default.
+		{"image.jpg", url.Values{"q": {"90"}, "fm": {"webp"}}, 90},
+		// The format's quality, from fm or else the extension.
+		{"image.jpg", url.Values{"fm": {"webp"}}, 45},
+		{"image.jpg", url.Values{}, 75},
+		// The default quality, for other formats.
+		{"image.png", url.Values{}, 60},
+		{"image.jpg", url.Values{"auto": {"format"}}, 60},
+		// A dpr doesn't reduce the quality of a single URL.
+		{"image.jpg", url.Values{"dpr": {"2"}}, 75},
+		{"image.jpg", url.Values{"dpr": {"3"}, "q": {"35"}}, 35},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, u.EffectiveQuality(test.path, test.params))
+
+		// The effective quality is the one CreateURL emits.
+		parsed, err := ParseURL(u.createURLFromValues(test.path, test.params))
+		assert.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(test.expected), parsed.Params.Get("q"))
+	}
+
+	d := testClient()
+	assert.Equal(t, 0, d.EffectiveQuality("image.jpg", url.Values{"dpr": {"2"}}))
+	assert.Equal(t, 0, d.EffectiveQuality("image.jpg", url.Values{"q": {"high"}}))
+}
+
+func TestURL_WithFormatFromExtension(t *testing.T) {
+	u := NewURLBuilder("test.imgix.net", WithLibParam(false), WithFormatFromExtension())
+
+	tests := []struct {
+		path     string
+		params   []IxParam
+		expected string
+	}{
+		{"image.png", nil, "https://test.imgix.net/image.png?fm=png"},
+		{"image.jpg", nil, "https://test.imgix.net/image.jpg?fm=jpg"},
+		{"photos/image.JPEG", nil, "https://test.imgix.net/photos/image.JPEG?fm=jpg"},
+		{"image.webp", nil, "https://test.imgix.net/image.webp?fm=webp"},
+		{"image.tiff", nil, "https://test.imgix.net/image.tiff"},
+		{"image", nil, "https://test.imgix.net/image"},
+		{"image.png", []IxParam{Format(WebP)}, "https://test.imgix.net/image.png?fm=webp"},
+		{"image.png", []IxParam{Param("auto", "format,compress")}, "https://test.imgix.net/image.png?auto=format%2Ccompress"},
+		{"https://example.com/a.png?v=1", nil, "https://test.imgix.net/https%3A%2F%2Fexample.com%2Fa.png%3Fv=1?fm=png"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, u.CreateURL(test.path, test.params...))
+	}
+
+	// The format's quality applies to the injected fm.
+	q := NewURLBuilder("test.imgix.net", WithLibParam(false), WithFormatFromExtension(),
+		WithFormatQuality(map[ImageFormat]int{PNG: 80}))
+	assert.Equal(t, "https://test.imgix.net/image.png?fm=png&q=80", q.CreateURL("image.png"))
+}
@@ -3,8 +3,11 @@ package imgix
 import (
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // rangePair is a convenience structure used during validation.
@@ -22,18 +25,28 @@ type widthRange struct {
 	tolerance float64
 }
 
-// validateDomain uses Go's url.Parse and url.Hostname functions to
-// validate the domain. Elsewhere we use a regex to filter invalid
-// domains. However, the same regex won't work in this case as Go
-// does not support positive look-a-heads (i.e. `(?=)`).
+// validateDomain uses Go's url.Parse function to validate the domain.
+// Elsewhere we use a regex to filter invalid domains. However, the same
+// regex won't work in this case as Go does not support positive
+// look-a-heads (i.e. `(?=)`).
+//
+// The returned host is lowercased. DNS is case-insensitive, but a
+// canonical host keeps the URLs created by the builder (and any cache
+// keys derived from them) consistent. A port, if present, is preserved
+// (e.g. localhost:8080), as are the brackets of IPv6 literals (e.g.
+// [::1]:8080). A bare IPv6 literal (e.g. ::1) is bracketed.
 func validateDomain(domain string) (string, error) {
+	if ip := net.ParseIP(domain); ip != nil && strings.Contains(domain, ":") {
+		return "[" + strings.ToLower(domain) + "]", nil
+	}
+
 	if strings.HasPrefix(domain, "http") {
 		u, err := url.Parse(domain)
 		if err != nil {
 			return "", fmt.Errorf(
 				"failed to parse URL form from domain %s due to %w", domain, err)
 		}
-		return u.Hostname(), nil
+		return strings.ToLower(u.Host), nil
 	}
 
 	// Otherwise, apply a "dummy" prefix so that the domain (hostname)
@@ -43,8 +56,7 @@ func validateDomain(domain string) (string, error) {
 		return "", fmt.Errorf(
 			"failed to parse domain %s with scheme: https, due to: %w", domain, err)
 	}
-	return u.Hostname(), nil
-
+	return strings.ToLower(u.Host), nil
 }
 
 // validateMinWidth checks if the value is a valid minWidth.
@@ -138,6 +150,90 @@ func validateWidths(widthValues []int) ([]int, error) {
 	return widthValues, nil
 }
 
+// validateLadder checks the invariants of a srcset width-ladder: its
+// widths must be strictly ascending (and so unique) and must lie within
+// the range defined by minWidth and maxWidth.
+func validateLadder(widths []int, minWidth int, maxWidth int) error {
+	for i, w := range widths {
+		if w < minWidth || w > maxWidth {
+			return fmt.Errorf("srcset width `%d` at index `%d` is outside of the "+
+				"range [%d, %d]", w, i, minWidth, maxWidth)
+		}
+		if i > 0 && w == widths[i-1] {
+			return fmt.Errorf("srcset widths must be unique, found duplicate "+
+				"width `%d` at index `%d`", w, i)
+		}
+		if i > 0 && w < widths[i-1] {
+			return fmt.Errorf("srcset widths must be ascending, "+
+				"found `%d` after `%d` at index `%d`", w, widths[i-1], i)
+		}
+	}
+	return nil
+}
+
+// maxDprWidths is the maximum number of widths that can be described by
+// pixel density, matching the 1x to 5x candidates of a dpr-based srcset.
+const maxDprWidths = 5
+
+// validateDprWidths checks that the widths can be described by pixel
+// density (see DPRDescriptor): there must be between one and
+// maxDprWidths positive widths in strictly ascending order. Longer lists
+// describe densities no display has and are better described by width.
+func validateDprWidths(widths []int) error {
+	if len(widths) == 0 || len(widths) > maxDprWidths {
+		return fmt.Errorf("dpr descriptors require between 1 and %d widths, found %d",
+			maxDprWidths, len(widths))
+	}
+
+	if widths[0] <= 0 {
+		return fmt.Errorf("dpr descriptors require positive widths, found `%d`", widths[0])
+	}
+
+	for i := 1; i < len(widths); i++ {
+		if widths[i] <= widths[i-1] {
+			return fmt.Errorf("dpr descriptors require ascending widths, "+
+				"found `%d` after `%d` at index `%d`", widths[i], widths[i-1], i)
+		}
+	}
+	return nil
+}
+
+// validateDprQualities checks that each of the qualities, keyed by dpr,
+// has a dpr between 1 and 5 and a quality between 0 and 100.
+func validateDprQualities(qualities map[int]int) error {
+	for dpr, q := range qualities {
+		if dpr < 1 || dpr > 5 {
+			return fmt.Errorf("dpr qualities must have a dpr between 1 and 5, found `%d`", dpr)
+		}
+		if q < 0 || q > 100 {
+			return fmt.Errorf("dpr quality `%d` for dpr `%d` must be between 0 and 100", q, dpr)
+		}
+	}
+	return nil
+}
+
+// validateSeparator checks that the srcset separator is a comma, with
+// only whitespace (if anything) around it.
+func validateSeparator(separator string) error {
+	if strings.Count(separator, ",") != 1 || strings.TrimSpace(strings.Replace(separator, ",", "", 1)) != "" {
+		return fmt.Errorf("srcset separator must be a comma surrounded by optional "+
+			"whitespace, found %q", separator)
+	}
+	return nil
+}
+
+// validateAggressiveEscapes checks that the extra characters of
+// WithAggressivePathEscaping are ASCII and aren't '%', which begins the
+// escapes themselves.
+func validateAggressiveEscapes(extra []rune) error {
+	for _, r := range extra {
+		if r == '%' || r >= utf8.RuneSelf {
+			return fmt.Errorf("aggressive path escapes must be ASCII characters other than '%%', found %q", r)
+		}
+	}
+	return nil
+}
+
 // allPositive returns true if every value in values is positive, false otherwise.
 func allPositive(values []int) (int, bool) {
 	const zero = 0
@@ -149,3 +245,119 @@ func allPositive(values []int) (int, bool) {
 	}
 	return idx, true
 }
+
+// validateURLLength checks that the length of the URL u does not exceed
+// maxLength. A maxLength of zero (or less) disables the check. The error
+// wraps ErrURLTooLong and names both lengths and, because the usual
+// culprit is a long proxy source, suggests passing the source as a
+// base64-encoded url64 param.
+func validateURLLength(u string, maxLength int) error {
+	if maxLength <= 0 || len(u) <= maxLength {
+		return nil
+	}
+	msg := fmt.Sprintf("url length %d exceeds the maximum of %d characters; "+
+		"if this is a proxy source, consider passing it as a base64-encoded "+
+		"`url64` param instead of in the path", len(u), maxLength)
+	return &kindError{ErrURLTooLong, msg}
+}
+
+// validateOutputURL checks that the URL u parses and that its encoding
+// is canonical: re-encoding the parsed URL must yield u unchanged, and
+// its query must parse as well. Otherwise, e.g. if a space or an invalid
+// escape such as "%zz" slipped through, the error wraps ErrMalformedURL.
+func validateOutputURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return &kindError{ErrMalformedURL, fmt.Sprintf("url `%s` doesn't parse: %s", u, err)}
+	}
+	if _, err := url.ParseQuery(parsed.RawQuery); err != nil {
+		return &kindError{ErrMalformedURL, fmt.Sprintf("url `%s` has a malformed query: %s", u, err)}
+	}
+	if reencoded := parsed.String(); reencoded != u {
+		msg := fmt.Sprintf("url `%s` isn't encoded canonically, expected `%s`", u, reencoded)
+		return &kindError{ErrMalformedURL, msg}
+	}
+	return nil
+}
+
+// validateDimensions checks that the explicit width (w) and height (h)
+// in params do not exceed maxWidth and maxHeight, respectively (see
+// oversizedDimension). The error is a ParamError that wraps
+// ErrValueOutOfRange and names the dimension by its canonical name, even
+// if it was given by its long form (e.g. width).
+func validateDimensions(params url.Values, maxWidth int, maxHeight int) error {
+	key := oversizedDimension(params, 1, maxWidth, maxHeight)
+	if key == "" {
+		return nil
+	}
+	params = canonicalNames(params)
+
+	max := maxWidth
+	if key == "h" {
+		max = maxHeight
+	}
+	return &ParamError{
+		Param: key,
+		Value: params.Get(key),
+		Err:   ErrValueOutOfRange,
+		msg:   fmt.Sprintf("`%s` of %s exceeds the maximum of %d", key, params.Get(key), max)}
+}
+
+// oversizedDimension returns the key ("w" or "h") of the first dimension
+// in params that, scaled by the ratio, exceeds maxWidth or maxHeight,
+// respectively. If neither does, the key is empty. A maximum of zero (or
+// less) is unbounded, and values that aren't absolute pixel sizes (e.g.
+// the ratio w=0.5) are never oversized. The dimensions are looked up by
+// their canonical names (see canonicalNames), so a width counts as a w.
+func oversizedDimension(params url.Values, ratio float64, maxWidth int, maxHeight int) string {
+	params = canonicalNames(params)
+	checks := []struct {
+		key string
+		max int
+	}{{"w", maxWidth}, {"h", maxHeight}}
+
+	for _, c := range checks {
+		if c.max <= 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(params.Get(c.key), 64)
+		if err != nil || v < 1 {
+			continue
+		}
+		if v*ratio > float64(c.max) {
+			return c.key
+		}
+	}
+	return ""
+}
+
+// validateChromaSub checks that the value is a valid chroma subsampling
+// (chromasub) value: one of 420, 422, or 444.
+func validateChromaSub(value string) error {
+	switch value {
+	case "420", "422", "444":
+		return nil
+	}
+	return fmt.Errorf("`chromasub` must be one of 420, 422, or 444, found `%s`", value)
+}
+
+// validateColorQuant checks that the value is a valid color quantization
+// (colorquant) value: an integer between 2 and 256, inclusive.
+func validateColorQuant(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 2 || n > 256 {
+		return fmt.Errorf("`colorquant` must be an integer between 2 and 256, found `%s`", value)
+	}
+	return nil
+}
+
+// validateQuality checks that the value is a valid quality (q) value: a
+// number between 0 and 100, inclusive. Fractional values are valid (see
+// QualityFloat).
+func validateQuality(value string) error {
+	q, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(q >= 0 && q <= 100) {
+		return fmt.Errorf("`q` must be a number between 0 and 100, found `%s`", value)
+	}
+	return nil
+}
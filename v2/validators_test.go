@@ -1,7 +1,9 @@
 package imgix
 
 import (
+	"errors"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -79,3 +81,122 @@ func TestValidators_validateRangeWithToleranceValid(t *testing.T) {
 	_, err := validateRangeWithTolerance(100, 200, invalidTolerance)
 	assert.Equal(t, nil, err)
 }
+
+func TestValidators_validateURLLength(t *testing.T) {
+	assert.Equal(t, nil, validateURLLength("https://test.imgix.net/a.png", 28))
+	assert.Equal(t, nil, validateURLLength("https://test.imgix.net/a.png", 0))
+
+	err := validateURLLength("https://test.imgix.net/a.png", 27)
+	assert.NotEqual(t, nil, err)
+	assert.Contains(t, err.Error(), "url length 28")
+}
+
+func TestValidators_validateDomainLowercases(t *testing.T) {
+	domain, err := validateDomain("MyCo.Imgix.Net")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "myco.imgix.net", domain)
+
+	domain, err = validateDomain("https://MyCo.Imgix.Net")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "myco.imgix.net", domain)
+}
+
+func TestValidators_validateDomainHostWithPort(t *testing.T) {
+	domains := map[string]string{
+		"localhost:8080":        "localhost:8080",
+		"127.0.0.1:9000":        "127.0.0.1:9000",
+		"[::1]:8080":            "[::1]:8080",
+		"::1":                   "[::1]",
+		"http://localhost:8080": "localhost:8080",
+		"test.imgix.net":        "test.imgix.net",
+	}
+
+	for domain, expected := range domains {
+		actual, err := validateDomain(domain)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func TestValidators_validateChromaSub(t *testing.T) {
+	for _, v := range []string{"420", "422", "444"} {
+		assert.Equal(t, nil, validateChromaSub(v))
+	}
+	for _, v := range []string{"", "411", "444 "} {
+		assert.NotEqual(t, nil, validateChromaSub(v))
+	}
+}
+
+func TestValidators_validateColorQuant(t *testing.T) {
+	for _, v := range []string{"2", "16", "256"} {
+		assert.Equal(t, nil, validateColorQuant(v))
+	}
+	for _, v := range []string{"", "1", "257", "2.5"} {
+		assert.NotEqual(t, nil, validateColorQuant(v))
+	}
+}
+
+func TestValidators_validateQuality(t *testing.T) {
+	for _, v := range []string{"0", "75", "82.5", "100"} {
+		assert.Equal(t, nil, validateQuality(v))
+	}
+	for _, v := range []string{"", "-1", "100.5", "NaN", "high"} {
+		assert.NotEqual(t, nil, validateQuality(v))
+	}
+}
+
+func TestValidators_validateOutputURL(t *testing.T) {
+	for _, u := range []string{
+		"https://test.imgix.net/image.png",
+		"https://test.imgix.net/users/1%202.png?txt=a%20%26%20b&w=400",
+		"//test.imgix.net/image.png?s=abc",
+	} {
+		assert.Equal(t, nil, validateOutputURL(u))
+	}
+	for _, u := range []string{
+		"https://test.imgix.net/a b.png",
+		"https://test.imgix.net/%zz.png",
+		"https://test.imgix.net/image.png?txt=100%",
+		"https://test.imgix.net/image.png?txt=%zz",
+		"https://test.imgix.net/ü.png",
+		"https://test imgix.net/image.png",
+	} {
+		err := validateOutputURL(u)
+		assert.True(t, errors.Is(err, ErrMalformedURL), u)
+	}
+}
+
+func TestValidators_validateDprWidths(t *testing.T) {
+	assert.Equal(t, nil, validateDprWidths([]int{100}))
+	assert.Equal(t, nil, validateDprWidths([]int{100, 200, 300, 400, 500}))
+
+	invalid := [][]int{
+		{},
+		{100, 200, 300, 400, 500, 600},
+		{0, 100},
+		{200, 100},
+		{100, 100},
+	}
+	for _, widths := range invalid {
+		assert.NotEqual(t, nil, validateDprWidths(widths))
+	}
+}
+
+func TestValidators_validateAggressiveEscapes(t *testing.T) {
+	assert.Equal(t, nil, validateAggressiveEscapes(nil))
+	assert.Equal(t, nil, validateAggressiveEscapes([]rune("!'()*")))
+	for _, r := range []rune{'%', 'é', '世', utf8.RuneError} {
+		assert.NotEqual(t, nil, validateAggressiveEscapes([]rune{'!', r}))
+	}
+	assert.EqualError(t, validateAggressiveEscapes([]rune{'%'}),
+		"aggressive path escapes must be ASCII characters other than '%', found '%'")
+}
+
+func TestValidators_validateSeparator(t *testing.T) {
+	for _, separator := range []string{",", ", ", ",\n", " ,\n\t"} {
+		assert.Equal(t, nil, validateSeparator(separator))
+	}
+	for _, separator := range []string{"", " ", "\n", ",,", ", ;", "|"} {
+		assert.NotEqual(t, nil, validateSeparator(separator))
+	}
+}